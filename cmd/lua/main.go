@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/uganh16/golua/pkg/golua"
+	"github.com/uganh16/golua/pkg/lua"
+	"github.com/uganh16/golua/pkg/stdlib"
+)
+
+const LUA_PROGNAME = "lua"
+
+const (
+	LUA_PROMPT  = "> "
+	LUA_PROMPT2 = ">> "
+)
+
+const LUA_INIT_VAR = "LUA_INIT"
+
+const LUA_INITVARVERSION = LUA_INIT_VAR + "_5_3"
+
+var progname = LUA_PROGNAME
+
+/* standard input, shared by the interactive loop and 'dofile' */
+var stdin = bufio.NewReader(os.Stdin)
+
+/**
+ * lua_stdin_is_tty detects whether the standard input is a 'tty' (that
+ * is, whether we're running lua interactively).
+ */
+func stdinIsTTY() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+/* Prints usage message. */
+func printUsage(badOption string) {
+	fmt.Fprintf(os.Stderr, "%s: ", progname)
+	if badOption[1] == 'e' || badOption[1] == 'l' {
+		fmt.Fprintf(os.Stderr, "'%s' needs argument\n", badOption)
+	} else {
+		fmt.Fprintf(os.Stderr, "unrecognized option '%s'\n", badOption)
+	}
+	fmt.Fprintf(os.Stderr,
+		"usage: %s [options] [script [args]]\n"+
+			"Available options are:\n"+
+			"  -e stat  execute string 'stat'\n"+
+			"  -i       enter interactive mode after executing 'script'\n"+
+			"  -l name  require library 'name'\n"+
+			"  -v       show version information\n"+
+			"  -E       ignore environment variables\n"+
+			"  --       stop handling options\n"+
+			"  -        stop handling options and execute stdin\n",
+		progname)
+}
+
+/**
+ * Prints an error message, adding the program name in front of it
+ * (if present)
+ */
+func lMessage(pname, msg string) {
+	if pname != "" {
+		fmt.Fprintf(os.Stderr, "%s: ", pname)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", msg)
+}
+
+/**
+ * Check whether 'status' is not OK and, if so, prints the error
+ * message on the top of the stack.
+ */
+func report(L lua.State, status int) int {
+	if status != lua.OK {
+		msg := L.ToString(-1)
+		lMessage(progname, msg)
+		L.Pop(1) /* remove message */
+	}
+	return status
+}
+
+/* Message handler used to run all chunks */
+func msgHandler(L lua.State) int {
+	msg, ok := L.ToStringX(1)
+	if !ok { /* is error object not a string? */
+		if L.CallMeta(1, "__tostring") && /* does it have a metamethod */
+			L.Type(-1) == lua.TSTRING { /* that produces a string? */
+			return 1 /* that is the message */
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", L.TypeName(L.Type(1)))
+	}
+	L.Traceback(L, msg, 1) /* append a standard traceback */
+	return 1               /* return the traceback */
+}
+
+/**
+ * Interface to 'PCall', which sets appropriate message function.
+ * Used to run all chunks.
+ */
+func doCall(L lua.State, nArg, nRes int) int {
+	base := L.GetTop() - nArg    /* function index */
+	L.PushGoFunction(msgHandler) /* push message handler */
+	L.Insert(base)               /* put it under function and args */
+	status := L.PCall(nArg, nRes, base)
+	L.Remove(base) /* remove message handler from the stack */
+	return status
+}
+
+func printVersion() {
+	fmt.Println(lua.COPYRIGHT)
+}
+
+/**
+ * Create the 'arg' table, which stores all arguments from the
+ * command line ('argv'). It should be aligned so that, at index 0,
+ * it has 'argv[script]', which is the script name. The arguments
+ * to the script (everything after 'script') go to positive indices;
+ * other arguments (before the script name) go to negative indices.
+ * If there is no script name, assume interpreter's name as base.
+ */
+func createArgTable(L lua.State, argv []string, script int) {
+	if script == len(argv) {
+		script = 0 /* no script name? */
+	}
+	nArg := len(argv) - (script + 1) /* number of positive indices */
+	L.CreateTable(nArg, script+1)
+	for i, arg := range argv {
+		L.PushString(arg)
+		L.RawSetI(-2, lua.Integer(i-script))
+	}
+	L.SetGlobal("arg")
+}
+
+func doChunk(L lua.State, status int) int {
+	if status == lua.OK {
+		status = doCall(L, 0, 0)
+	}
+	return report(L, status)
+}
+
+func doFile(L lua.State, name string) int {
+	return doChunk(L, L.LoadFile(name))
+}
+
+func doString(L lua.State, s, name string) int {
+	return doChunk(L, L.Load(strings.NewReader(s), name, ""))
+}
+
+/**
+ * Calls 'require(name)' and stores the result in a global variable
+ * with the given name.
+ */
+func doLibrary(L lua.State, name string) int {
+	L.GetGlobal("require")
+	L.PushString(name)
+	status := doCall(L, 1, 1) /* call 'require(name)' */
+	if status == lua.OK {
+		L.SetGlobal(name) /* global[name] = require return */
+	}
+	return report(L, status)
+}
+
+/*
+** {==================================================================
+** Read-Eval-Print Loop (REPL)
+** ===================================================================
+ */
+
+/* Returns the string to be used as a prompt by the interpreter. */
+func getPrompt(L lua.State, firstLine bool) string {
+	if firstLine {
+		L.GetGlobal("_PROMPT")
+	} else {
+		L.GetGlobal("_PROMPT2")
+	}
+	p, ok := L.ToStringX(-1)
+	L.Pop(1)
+	if !ok {
+		if firstLine {
+			p = LUA_PROMPT
+		} else {
+			p = LUA_PROMPT2
+		}
+	}
+	return p
+}
+
+/* mark in error messages for incomplete statements */
+const EOFMARK = "<eof>"
+
+/**
+ * Check whether 'status' signals a syntax error and the error
+ * message at the top of the stack ends with the above mark for
+ * incomplete statements.
+ */
+func incomplete(L lua.State, status int) bool {
+	if status == lua.ERRSYNTAX {
+		if msg := L.ToString(-1); strings.HasSuffix(msg, EOFMARK) {
+			L.Pop(1)
+			return true
+		}
+	}
+	return false /* else... */
+}
+
+/**
+ * Prompt the user, read a line, and push it into the Lua stack.
+ * Returns false if there is no more input.
+ */
+func pushLine(L lua.State, firstLine bool) bool {
+	b, ok := readLine(L, getPrompt(L, firstLine))
+	if !ok {
+		return false /* no input */
+	}
+	if firstLine && strings.HasPrefix(b, "=") { /* for compatibility with 5.2, ... */
+		L.PushString("return " + b[1:]) /* change '=' to 'return' */
+	} else {
+		L.PushString(b)
+	}
+	return true
+}
+
+/**
+ * Try to compile line on the stack as 'return <line>'; on return, stack
+ * has either compiled chunk or original line (if compilation failed).
+ */
+func addReturn(L lua.State) int {
+	line := L.ToString(-1) /* original line */
+	retLine := "return " + line + ";"
+	status := L.Load(strings.NewReader(retLine), "=stdin", "")
+	if status == lua.OK {
+		saveLine(line) /* keep history */
+	} else {
+		L.Pop(1) /* pop result from 'Load' */
+	}
+	return status
+}
+
+/* Read multiple lines until a complete Lua statement */
+func multiLine(L lua.State) int {
+	for { /* repeat until gets a complete statement */
+		line := L.ToString(1)
+		status := L.Load(strings.NewReader(line), "=stdin", "") /* try it */
+		if !incomplete(L, status) || !pushLine(L, false) {
+			saveLine(line) /* keep history */
+			return status  /* cannot or should not try to add continuation line */
+		}
+		L.PushString("\n") /* add newline... */
+		L.Insert(-2)       /* ...between the two lines */
+		L.Concat(3)        /* join them */
+	}
+}
+
+/**
+ * Read a line and try to load (compile) it first as an expression (by
+ * adding "return " in front of it) and second as a statement. Return
+ * the final status of load/call with the resulting function (if any)
+ * in the top of the stack.
+ */
+func loadLine(L lua.State) int {
+	L.SetTop(0)
+	if !pushLine(L, true) {
+		return -1 /* no input */
+	}
+	status := addReturn(L)
+	if status != lua.OK { /* 'return ...' did not work? */
+		status = multiLine(L) /* try as command, maybe with continuation lines */
+	}
+	L.Remove(1) /* remove line from the stack */
+	return status
+}
+
+/* Prints (calling the Lua 'print' function) any values on the stack */
+func lPrint(L lua.State) {
+	n := L.GetTop()
+	if n > 0 { /* any result to be printed? */
+		if !L.CheckStack(lua.MINSTACK) {
+			L.ErrorF("stack overflow (%s)", "too many results to print")
+		}
+		L.GetGlobal("print")
+		L.Insert(1)
+		if L.PCall(n, 0, 0) != lua.OK {
+			lMessage(progname, fmt.Sprintf("error calling 'print' (%s)", L.ToString(-1)))
+		}
+	}
+}
+
+/**
+ * Do the REPL: repeatedly read (load) a line, evaluate (call) it, and
+ * print any results.
+ */
+func doREPL(L lua.State) {
+	oldProgname := progname
+	progname = "" /* no 'progname' on errors in interactive mode */
+	for {
+		status := loadLine(L)
+		if status == -1 {
+			break
+		}
+		if status == lua.OK {
+			status = doCall(L, 0, lua.MULTRET)
+		}
+		if status == lua.OK {
+			lPrint(L)
+		} else {
+			report(L, status)
+		}
+	}
+	L.SetTop(0) /* clear stack */
+	fmt.Println()
+	progname = oldProgname
+}
+
+/* }================================================================== */
+
+/* Push on the stack the contents of table 'arg' from 1 to #arg */
+func pushArgs(L lua.State) int {
+	if L.GetGlobal("arg") != lua.TTABLE {
+		L.ErrorF("'arg' is not a table")
+	}
+	L.Len(-1)
+	n := int(L.ToInteger(-1))
+	L.Pop(1)
+	if !L.CheckStack(n + 3) {
+		L.ErrorF("stack overflow (%s)", "too many arguments to script")
+	}
+	i := 1
+	for ; i <= n; i++ {
+		L.RawGetI(-i, lua.Integer(i))
+	}
+	L.Remove(-i) /* remove table from the stack */
+	return n
+}
+
+func handleScript(L lua.State, argv []string, script int) int {
+	fname := argv[script]
+	if fname == "-" && argv[script-1] != "--" {
+		fname = "" /* stdin */
+	}
+	status := L.LoadFile(fname)
+	if status == lua.OK {
+		n := pushArgs(L) /* push arguments to script */
+		status = doCall(L, n, lua.MULTRET)
+	}
+	return report(L, status)
+}
+
+/* bits of various argument indicators in 'args' */
+const (
+	has_error = 1  /* bad option */
+	has_i     = 2  /* -i */
+	has_v     = 4  /* -v */
+	has_e     = 8  /* -e */
+	has_E     = 16 /* -E */
+)
+
+/**
+ * Traverses all arguments from 'argv', returning a mask with those
+ * needed before running any Lua code (or an error code if it finds
+ * any invalid argument). 'first' returns the first not-handled argument
+ * (either the script name or a bad argument in case of error).
+ */
+func collectArgs(argv []string) (args int, first int) {
+	i := 1
+	for ; i < len(argv); i++ {
+		first = i
+		arg := argv[i]
+		if arg == "" || arg[0] != '-' { /* not an option? */
+			return args, first /* stop handling options */
+		}
+		if len(arg) == 1 { /* '-' */
+			return args, first /* script "name" is '-' */
+		}
+		switch arg[1] { /* else check option */
+		case '-': /* '--' */
+			if len(arg) > 2 { /* extra characters after '--'? */
+				return has_error, first /* invalid option */
+			}
+			return args, i + 1
+		case 'E':
+			if len(arg) > 2 { /* extra characters after 1st? */
+				return has_error, first /* invalid option */
+			}
+			args |= has_E
+		case 'i', 'v':
+			if len(arg) > 2 { /* extra characters after 1st? */
+				return has_error, first /* invalid option */
+			}
+			if arg[1] == 'i' {
+				args |= has_i /* (-i implies -v) */
+			}
+			args |= has_v
+		case 'e', 'l': /* both options need an argument */
+			if arg[1] == 'e' {
+				args |= has_e
+			}
+			if len(arg) == 2 { /* no concatenated argument? */
+				i++ /* try next 'argv' */
+				if i == len(argv) || strings.HasPrefix(argv[i], "-") {
+					return has_error, first /* no next argument or it is another option */
+				}
+			}
+		default: /* invalid option */
+			return has_error, first
+		}
+	}
+	return args, i /* no script name */
+}
+
+/**
+ * Processes options 'e' and 'l', which involve running Lua code.
+ * Returns false if some code raises an error.
+ */
+func runArgs(L lua.State, argv []string, n int) bool {
+	for i := 1; i < n; i++ {
+		option := argv[i][1]
+		if option == 'e' || option == 'l' {
+			var status int
+			extra := argv[i][2:] /* both options need an argument */
+			if extra == "" {
+				i++
+				extra = argv[i]
+			}
+			if option == 'e' {
+				status = doString(L, extra, "=(command line)")
+			} else {
+				status = doLibrary(L, extra)
+			}
+			if status != lua.OK {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func handleLuaInit(L lua.State) int {
+	name := "=" + LUA_INITVARVERSION
+	init, ok := os.LookupEnv(name[1:])
+	if !ok {
+		name = "=" + LUA_INIT_VAR
+		init, ok = os.LookupEnv(name[1:]) /* try alternative name */
+	}
+	if !ok {
+		return lua.OK
+	} else if strings.HasPrefix(init, "@") {
+		return doFile(L, init[1:])
+	} else {
+		return doString(L, init, name)
+	}
+}
+
+/**
+ * Main body of stand-alone interpreter (to be called in protected
+ * mode). Leaves a boolean on the stack telling whether there were no
+ * errors.
+ */
+func pmain(argv []string) lua.GoFunction {
+	return func(L lua.State) int {
+		args, script := collectArgs(argv)
+		if argv[0] != "" {
+			progname = argv[0]
+		}
+		if args == has_error { /* bad arg? */
+			printUsage(argv[script]) /* 'script' has index of bad arg. */
+			return 0
+		}
+		if args&has_v != 0 { /* option '-v'? */
+			printVersion()
+		}
+		if args&has_E != 0 { /* option '-E'? */
+			L.PushBoolean(true) /* signal for libraries to ignore env. vars. */
+			L.SetField(lua.REGISTRYINDEX, "LUA_NOENV")
+		}
+		stdlib.OpenLibs(L)              /* open standard libraries */
+		createArgTable(L, argv, script) /* create table 'arg' */
+		if args&has_E == 0 {            /* no option '-E'? */
+			if handleLuaInit(L) != lua.OK { /* run LUA_INIT */
+				return 0 /* error running LUA_INIT */
+			}
+		}
+		if !runArgs(L, argv, script) { /* execute arguments -e and -l */
+			return 0 /* something failed */
+		}
+		if script < len(argv) && /* execute main script (if there is one) */
+			handleScript(L, argv, script) != lua.OK {
+			return 0
+		}
+		if args&has_i != 0 { /* -i option? */
+			doREPL(L) /* do read-eval-print loop */
+		} else if script == len(argv) && args&(has_e|has_v) == 0 { /* no arguments? */
+			if stdinIsTTY() { /* running in interactive mode? */
+				printVersion()
+				doREPL(L) /* do read-eval-print loop */
+			} else {
+				doFile(L, "") /* executes stdin as a file */
+			}
+		}
+		L.PushBoolean(true) /* signal no errors */
+		return 1
+	}
+}
+
+func main() {
+	L := golua.NewState()            /* create state */
+	L.PushGoFunction(pmain(os.Args)) /* to call 'pmain' in protected mode */
+	status := L.PCall(0, 1, 0)       /* do the call */
+	result := L.ToBoolean(-1)        /* get result */
+	report(L, status)
+	if result && status == lua.OK {
+		os.Exit(0)
+	}
+	os.Exit(1)
+}
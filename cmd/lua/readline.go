@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/*
+** {==================================================================
+** Line editing
+** ===================================================================
+ */
+
+/* lines saved by 'saveLine', oldest first */
+var history []string
+
+/* Add a line to the history (lua_saveline). */
+func saveLine(line string) {
+	if line != "" && (len(history) == 0 || history[len(history)-1] != line) {
+		history = append(history, line)
+	}
+}
+
+/**
+ * Show 'prompt' and read a line (lua_readline). When the standard input
+ * is a terminal, the line can be edited and the history browsed;
+ * otherwise the line is read as is. Returns false if there is no more
+ * input.
+ */
+func readLine(L lua.State, prompt string) (string, bool) {
+	if stdinIsTTY() {
+		if restore, err := makeRaw(os.Stdin.Fd()); err == nil {
+			defer restore()
+			e := &lineEditor{L: L, prompt: prompt, hist: len(history)}
+			return e.edit()
+		}
+	}
+	fmt.Print(prompt)
+	b, err := stdin.ReadString('\n')
+	if b == "" && err != nil {
+		return "", false /* no input */
+	}
+	return strings.TrimSuffix(b, "\n"), true
+}
+
+type lineEditor struct {
+	L      lua.State
+	prompt string
+	buf    []rune /* line being edited */
+	pos    int    /* cursor position in 'buf' */
+	hist   int    /* index of the history entry shown */
+	saved  []rune /* line being edited before browsing the history */
+}
+
+/* Redraw the whole line and put the cursor back at 'pos'. */
+func (e *lineEditor) refresh() {
+	fmt.Printf("\r%s%s\x1b[K", e.prompt, string(e.buf))
+	if n := len(e.buf) - e.pos; n > 0 {
+		fmt.Printf("\x1b[%dD", n)
+	}
+}
+
+func (e *lineEditor) insert(r ...rune) {
+	buf := make([]rune, 0, len(e.buf)+len(r))
+	buf = append(buf, e.buf[:e.pos]...)
+	buf = append(buf, r...)
+	e.buf = append(buf, e.buf[e.pos:]...)
+	e.pos += len(r)
+}
+
+/* Delete runes in [from, to) */
+func (e *lineEditor) delete(from, to int) {
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	e.pos = from
+}
+
+/* Show the history entry 'hist + delta', if there is one. */
+func (e *lineEditor) browse(delta int) {
+	i := e.hist + delta
+	if i < 0 || i > len(history) {
+		return
+	}
+	if e.hist == len(history) { /* leaving the line being edited? */
+		e.saved = e.buf
+	}
+	e.hist = i
+	if i == len(history) {
+		e.buf = e.saved
+	} else {
+		e.buf = []rune(history[i])
+	}
+	e.pos = len(e.buf)
+}
+
+/* Handle an escape sequence (arrows, home, end and delete keys). */
+func (e *lineEditor) escape() {
+	c, _, err := stdin.ReadRune()
+	if err != nil || (c != '[' && c != 'O') {
+		return
+	}
+	c, _, err = stdin.ReadRune()
+	if err != nil {
+		return
+	}
+	if c >= '0' && c <= '9' { /* ESC [ n ~ */
+		n := c
+		for c != '~' {
+			if c, _, err = stdin.ReadRune(); err != nil {
+				return
+			}
+		}
+		switch n {
+		case '1', '7':
+			c = 'H'
+		case '4', '8':
+			c = 'F'
+		case '3':
+			if e.pos < len(e.buf) {
+				e.delete(e.pos, e.pos+1)
+			}
+			return
+		default:
+			return
+		}
+	}
+	switch c {
+	case 'A': /* up */
+		e.browse(-1)
+	case 'B': /* down */
+		e.browse(1)
+	case 'C': /* right */
+		if e.pos < len(e.buf) {
+			e.pos++
+		}
+	case 'D': /* left */
+		if e.pos > 0 {
+			e.pos--
+		}
+	case 'H': /* home */
+		e.pos = 0
+	case 'F': /* end */
+		e.pos = len(e.buf)
+	}
+}
+
+func (e *lineEditor) edit() (string, bool) {
+	fmt.Print(e.prompt)
+	for {
+		c, _, err := stdin.ReadRune()
+		if err != nil {
+			if len(e.buf) == 0 {
+				return "", false /* no input */
+			}
+			fmt.Print("\r\n")
+			return string(e.buf), true
+		}
+		switch c {
+		case '\r', '\n': /* enter */
+			fmt.Print("\r\n")
+			return string(e.buf), true
+		case 4: /* ^D */
+			if len(e.buf) == 0 {
+				return "", false /* end of input */
+			}
+			if e.pos < len(e.buf) {
+				e.delete(e.pos, e.pos+1)
+			}
+		case 127, 8: /* backspace */
+			if e.pos > 0 {
+				e.delete(e.pos-1, e.pos)
+			}
+		case 1: /* ^A */
+			e.pos = 0
+		case 5: /* ^E */
+			e.pos = len(e.buf)
+		case 2: /* ^B */
+			if e.pos > 0 {
+				e.pos--
+			}
+		case 6: /* ^F */
+			if e.pos < len(e.buf) {
+				e.pos++
+			}
+		case 11: /* ^K */
+			e.buf = e.buf[:e.pos]
+		case 21: /* ^U */
+			e.delete(0, e.pos)
+		case 23: /* ^W */
+			i := e.pos
+			for i > 0 && e.buf[i-1] == ' ' {
+				i--
+			}
+			for i > 0 && e.buf[i-1] != ' ' {
+				i--
+			}
+			e.delete(i, e.pos)
+		case 12: /* ^L */
+			fmt.Print("\x1b[H\x1b[2J")
+		case 16: /* ^P */
+			e.browse(-1)
+		case 14: /* ^N */
+			e.browse(1)
+		case '\t':
+			e.complete()
+		case 27: /* ESC */
+			e.escape()
+		default:
+			if c < ' ' {
+				continue /* ignore other control characters */
+			}
+			e.insert(c)
+		}
+		e.refresh()
+	}
+}
+
+/* }================================================================== */
+
+/*
+** {==================================================================
+** Completion
+** ===================================================================
+ */
+
+func isNameChar(c rune) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+/**
+ * Complete the word before the cursor. A single match is inserted; with
+ * several matches their common prefix is inserted or, if there is
+ * nothing more to insert, all of them are listed.
+ */
+func (e *lineEditor) complete() {
+	start := e.pos
+	for start > 0 && (isNameChar(e.buf[start-1]) || e.buf[start-1] == '.' || e.buf[start-1] == ':') {
+		start--
+	}
+	word := string(e.buf[start:e.pos])
+	matches := completions(e.L, word)
+	if len(matches) == 0 {
+		return
+	}
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		i := 0
+		for i < len(prefix) && i < len(m) && prefix[i] == m[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	if len(prefix) > len(word) {
+		e.insert([]rune(prefix[len(word):])...)
+	} else if len(matches) > 1 {
+		fmt.Printf("\r\n%s\r\n", strings.Join(matches, "  "))
+	}
+}
+
+/**
+ * Returns the sorted names that complete 'word', which is a (possibly
+ * empty) name optionally preceded by a chain of fields, as in 'a.b.c'
+ * or 'a.b:c'. Fields are looked up raw, starting from the globals table
+ * in the registry; after a ':' only functions are offered.
+ */
+func completions(L lua.State, word string) []string {
+	var matches []string
+	sep := strings.LastIndexAny(word, ".:")
+	path, partial := word[:sep+1], word[sep+1:]
+	L.RawGetI(lua.REGISTRYINDEX, lua.RIDX_GLOBALS)
+	if path != "" {
+		for _, field := range strings.Split(path[:len(path)-1], ".") {
+			if L.Type(-1) != lua.TTABLE {
+				break
+			}
+			L.PushString(field)
+			L.RawGet(-2)
+			L.Remove(-2) /* remove enclosing table */
+		}
+	}
+	if L.Type(-1) == lua.TTABLE {
+		for _, key := range tableKeys(L, -1) {
+			if !strings.HasPrefix(key, partial) {
+				continue
+			}
+			if strings.HasSuffix(path, ":") {
+				L.PushString(key)
+				t := L.RawGet(-2)
+				L.Pop(1)
+				if t != lua.TFUNCTION {
+					continue
+				}
+			}
+			matches = append(matches, path+key)
+		}
+	}
+	L.Pop(1)
+	sort.Strings(matches)
+	return matches
+}
+
+/* Returns the string keys of the table at 'idx' that are valid names. */
+func tableKeys(L lua.State, idx int) []string {
+	var keys []string
+	idx = L.AbsIndex(idx)
+	L.PushNil() /* first key */
+	for L.Next(idx) {
+		if L.Type(-2) == lua.TSTRING {
+			if key := L.ToString(-2); isName(key) {
+				keys = append(keys, key)
+			}
+		}
+		L.Pop(1) /* remove value, keep key for next iteration */
+	}
+	return keys
+}
+
+func isName(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, c := range s {
+		if !isNameChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+/* }================================================================== */
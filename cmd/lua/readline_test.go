@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/uganh16/golua/pkg/golua"
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+func newCompletionState(t *testing.T) lua.State {
+	L := golua.NewState()
+	chunk := `
+		t = {alpha = 1, beta = function() end, sub = {x = 1}, [1] = 2, ["not a name"] = 3}
+		tab, n, s = {}, 5, "str"`
+	if err := golua.DoString(L, chunk); err != nil {
+		t.Fatal(err)
+	}
+	return L
+}
+
+func TestCompletions(t *testing.T) {
+	L := newCompletionState(t)
+	tests := []struct {
+		word     string
+		expected string
+	}{
+		{"t", "t tab"},
+		{"n", "n"},
+		{"x", ""},
+		{"t.", "t.alpha t.beta t.sub"},
+		{"t.b", "t.beta"},
+		{"t:", "t:beta"},
+		{"t.sub.", "t.sub.x"},
+		{"t.alpha.", ""}, /* not a table */
+		{"n.", ""},
+		{"s:", ""},
+		{"missing.x", ""},
+	}
+	for _, test := range tests {
+		got := strings.Join(completions(L, test.word), " ")
+		if got != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.word, test.expected, got)
+		}
+		if L.GetTop() != 0 {
+			t.Fatalf("%q: expected an empty stack, got %d values", test.word, L.GetTop())
+		}
+	}
+	all := completions(L, "")
+	if strings.Join(all, " ") != "n s t tab" {
+		t.Errorf("expected all globals, got %q", all)
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	L := newCompletionState(t)
+	e := &lineEditor{L: L}
+	e.insert([]rune("x = t.al")...)
+	e.complete()
+	if got := string(e.buf); got != "x = t.alpha" || e.pos != len(e.buf) {
+		t.Errorf("expected completed line, got %q at %d", got, e.pos)
+	}
+	e.pos = 4
+	e.insert('(')
+	e.pos = len(e.buf)
+	e.insert(')')
+	e.delete(0, 4)
+	if got := string(e.buf); got != "(t.alpha)" {
+		t.Errorf("expected edited line, got %q", got)
+	}
+
+	history = nil
+	saveLine("first")
+	saveLine("second")
+	saveLine("second") /* duplicates are not saved */
+	e = &lineEditor{L: L, buf: []rune("draft"), pos: 5, hist: len(history)}
+	e.browse(-1)
+	e.browse(-1)
+	e.browse(-1) /* already at the oldest entry */
+	if got := string(e.buf); got != "first" {
+		t.Errorf("expected oldest entry, got %q", got)
+	}
+	e.browse(1)
+	e.browse(1)
+	if got := string(e.buf); got != "draft" || e.pos != 5 {
+		t.Errorf("expected the line being edited, got %q at %d", got, e.pos)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+/* line editing is only available on Linux terminals */
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errors.New("raw mode not supported")
+}
@@ -3,146 +3,419 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/uganh16/golua/internal/binary"
 	"github.com/uganh16/golua/internal/bytecode"
+	"github.com/uganh16/golua/internal/number"
+	"github.com/uganh16/golua/internal/state"
 	"github.com/uganh16/golua/pkg/lua"
 )
 
-func main() {
-	for _, file := range os.Args[1:] {
-		var p *binary.Proto
-		f, err := os.Open(file)
-		if err == nil {
-			p, err = binary.Undump(f)
-			f.Close()
+const PROGNAME = "luac"          /* default program name */
+const OUTPUT = PROGNAME + ".out" /* default output file */
+
+type luaState interface {
+	lua.State
+	ToProto(idx int) *binary.Proto
+}
+
+var (
+	listing   = 0      /* list bytecodes? */
+	dumping   = true   /* dump bytecodes? */
+	stripping = false  /* strip debug information? */
+	output    = OUTPUT /* actual output file name */
+	progname  = PROGNAME
+)
+
+func fatal(message string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progname, message)
+	os.Exit(1)
+}
+
+func cannot(what string, err error) {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	fmt.Fprintf(os.Stderr, "%s: cannot %s %s: %v\n", progname, what, output, err)
+	os.Exit(1)
+}
+
+func usage(message string) {
+	if message[0] == '-' {
+		fmt.Fprintf(os.Stderr, "%s: unrecognized option '%s'\n", progname, message)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", progname, message)
+	}
+	fmt.Fprintf(os.Stderr,
+		"usage: %s [options] [filenames]\n"+
+			"Available options are:\n"+
+			"  -l       list (use -l -l for full listing)\n"+
+			"  -o name  output to file 'name' (default is \"%s\")\n"+
+			"  -p       parse only\n"+
+			"  -s       strip debug information\n"+
+			"  -v       show version information\n"+
+			"  --       stop handling options\n"+
+			"  -        stop handling options and process stdin\n",
+		progname, OUTPUT)
+	os.Exit(1)
+}
+
+func doArgs(args []string) []string {
+	version := 0
+	if args[0] != "" {
+		progname = args[0]
+	}
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "" || arg[0] != '-' { /* end of options; keep it */
+			break
+		} else if arg == "--" { /* end of options; skip it */
+			i++
+			if version != 0 {
+				version++
+			}
+			break
+		} else if arg == "-" { /* end of options; use stdin */
+			break
+		} else if arg == "-l" { /* list */
+			listing++
+		} else if arg == "-o" { /* output file */
+			i++
+			if i == len(args) || args[i] == "" || (args[i][0] == '-' && args[i] != "-") {
+				usage("'-o' needs argument")
+			}
+			output = args[i]
+		} else if arg == "-p" { /* parse only */
+			dumping = false
+		} else if arg == "-s" { /* strip debug information */
+			stripping = true
+		} else if arg == "-v" { /* show version */
+			version++
+		} else { /* unknown option */
+			usage(arg)
 		}
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
-			continue
+	}
+	files := args[i:]
+	if len(files) == 0 && (listing != 0 || !dumping) {
+		dumping = false
+		files = []string{OUTPUT}
+	}
+	if version != 0 {
+		fmt.Println(lua.COPYRIGHT)
+		if version == len(args)-1 {
+			os.Exit(0)
 		}
-		list(p)
 	}
+	return files
 }
 
-func list(p *binary.Proto) {
-	printHeader(p)
-	printCode(p)
-	printDebug(p)
-	for _, p := range p.Protos {
-		list(p)
+/**
+ * Combine the main functions of several chunks into a single main
+ * function that calls each of them in turn.
+ */
+func combine(L luaState, n int) *binary.Proto {
+	if n == 1 {
+		return L.ToProto(-1)
+	}
+	chunk := strings.Repeat("(function()end)();", n)
+	if L.Load(strings.NewReader(chunk), "=("+PROGNAME+")", "") != lua.OK {
+		fatal(L.ToString(-1))
+	}
+	f := L.ToProto(-1)
+	for i := 0; i < n; i++ {
+		f.Protos[i] = L.ToProto(i - n - 1)
+		if len(f.Protos[i].Upvalues) > 0 {
+			f.Protos[i].Upvalues[0].InStack = false
+		}
 	}
+	f.LineInfo = nil
+	return f
 }
 
-func printHeader(p *binary.Proto) {
-	funcType := "main"
-	if p.LineDefined > 0 {
-		funcType = "function"
+func main() {
+	files := doArgs(os.Args)
+	if len(files) == 0 {
+		usage("no input files given")
+	}
+	L := state.New()
+	if !L.CheckStack(len(files)) {
+		fatal("too many input files")
 	}
+	for _, file := range files {
+		if file == "-" {
+			file = "" /* stdin */
+		}
+		if L.LoadFile(file) != lua.OK {
+			fatal(L.ToString(-1))
+		}
+	}
+	f := combine(L, len(files))
+	if listing != 0 {
+		printFunction(f, listing > 1)
+	}
+	if dumping {
+		out := os.Stdout
+		if output != "-" {
+			var err error
+			if out, err = os.Create(output); err != nil {
+				cannot("open", err)
+			}
+		}
+		if err := binary.Dump(f, out, stripping); err != nil {
+			cannot("write", err)
+		}
+		if err := out.Close(); err != nil {
+			cannot("close", err)
+		}
+	}
+}
 
-	source := p.Source
-	if source == "" {
-		source = "=?"
+/*
+** {======================================================
+** print bytecodes
+** =======================================================
+ */
+
+func printFunction(f *binary.Proto, full bool) {
+	printHeader(f)
+	printCode(f)
+	if full {
+		printDebug(f)
 	}
-	if source[0] == '@' || source[0] == '=' {
-		source = source[1:]
-	} else if source[0] == lua.SIGNATURE[0] {
-		source = "(bstring)"
-	} else {
-		source = "(string)"
+	for _, p := range f.Protos {
+		printFunction(p, full)
 	}
+}
 
-	varargFlag := ""
-	if p.IsVararg {
-		varargFlag = "+"
+func printString(s string) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			b.WriteString("\\\"")
+		case '\\':
+			b.WriteString("\\\\")
+		case '\a':
+			b.WriteString("\\a")
+		case '\b':
+			b.WriteString("\\b")
+		case '\f':
+			b.WriteString("\\f")
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\v':
+			b.WriteString("\\v")
+		default:
+			if c >= 0x20 && c < 0x7f {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "\\%03d", c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	fmt.Print(b.String())
+}
+
+func printConstant(f *binary.Proto, i int) {
+	switch k := f.Constants[i].(type) {
+	case nil:
+		fmt.Print("nil")
+	case bool:
+		fmt.Printf("%t", k)
+	case lua.Number:
+		fmt.Print(number.FloatToString(k))
+	case lua.Integer:
+		fmt.Printf("%d", k)
+	case string:
+		printString(k)
+	default: /* cannot happen */
+		fmt.Printf("? type=%T", k)
 	}
+}
 
-	fmt.Printf("\n%s <%s:%d,%d> (%d instruction%s)\n", funcType, source, p.LineDefined, p.LastLineDefined, len(p.Code), s(len(p.Code)))
-	fmt.Printf("%d%s param%s, %d slot%s, %d upvalue%s, %d local%s, %d constant%s, %d function%s\n", p.NumParams, varargFlag, s(int(p.NumParams)), p.MaxStackSize, s(int(p.MaxStackSize)), len(p.Upvalues), s(len(p.Upvalues)), len(p.LocVars), s(len(p.LocVars)), len(p.Constants), s(len(p.Constants)), len(p.Protos), s(len(p.Protos)))
+func upvalName(f *binary.Proto, x int) string {
+	if x < len(f.UpvalueNames) && f.UpvalueNames[x] != "" {
+		return f.UpvalueNames[x]
+	}
+	return "-"
 }
 
-func printCode(p *binary.Proto) {
-	for pc, i := range p.Code {
-		line := "-"
-		if len(p.LineInfo) > pc {
-			line = fmt.Sprintf("%d", p.LineInfo[pc])
+func myK(x int) int {
+	return -1 - x
+}
+
+func printCode(f *binary.Proto) {
+	code := f.Code
+	for pc := 0; pc < len(code); pc++ {
+		i := code[pc]
+		o := i.Opcode()
+		a, b, c := i.ABC()
+		_, bx := i.ABx()
+		_, sbx := i.AsBx()
+		ax := i.Ax()
+		line := 0
+		if pc < len(f.LineInfo) {
+			line = int(f.LineInfo[pc])
+		}
+		fmt.Printf("\t%d\t", pc+1)
+		if line > 0 {
+			fmt.Printf("[%d]\t", line)
+		} else {
+			fmt.Printf("[-]\t")
 		}
-		fmt.Printf("\t%d\t[%s]\t%-9s\t", pc+1, line, i.OpName())
+		fmt.Printf("%-9s\t", i.OpName())
 		switch i.OpMode() {
 		case bytecode.IABC:
-			a, b, c := i.ABC()
 			fmt.Printf("%d", a)
 			if i.BMode() != bytecode.OpArgN {
-				if b > 0xff {
-					fmt.Printf(" %d", -1-(b&0xff))
+				if bytecode.ISK(b) {
+					fmt.Printf(" %d", myK(b&bytecode.MAXINDEXRK))
 				} else {
 					fmt.Printf(" %d", b)
 				}
 			}
 			if i.CMode() != bytecode.OpArgN {
-				if c > 0xff {
-					fmt.Printf(" %d", -1-(c&0xff))
+				if bytecode.ISK(c) {
+					fmt.Printf(" %d", myK(c&bytecode.MAXINDEXRK))
 				} else {
 					fmt.Printf(" %d", c)
 				}
 			}
 		case bytecode.IABx:
-			a, bx := i.ABx()
 			fmt.Printf("%d", a)
-			switch i.BMode() {
-			case bytecode.OpArgK:
-				fmt.Printf(" %d", -1-bx)
-			case bytecode.OpArgU:
+			if i.BMode() == bytecode.OpArgK {
+				fmt.Printf(" %d", myK(bx))
+			}
+			if i.BMode() == bytecode.OpArgU {
 				fmt.Printf(" %d", bx)
 			}
 		case bytecode.IAsBx:
-			a, sbx := i.AsBx()
 			fmt.Printf("%d %d", a, sbx)
 		case bytecode.IAx:
-			ax := i.Ax()
-			fmt.Printf("%d", -1-ax)
+			fmt.Printf("%d", myK(ax))
+		}
+		switch o {
+		case bytecode.OP_LOADK:
+			fmt.Printf("\t; ")
+			printConstant(f, bx)
+		case bytecode.OP_GETUPVAL, bytecode.OP_SETUPVAL:
+			fmt.Printf("\t; %s", upvalName(f, b))
+		case bytecode.OP_GETTABUP:
+			fmt.Printf("\t; %s", upvalName(f, b))
+			if bytecode.ISK(c) {
+				fmt.Printf(" ")
+				printConstant(f, c&bytecode.MAXINDEXRK)
+			}
+		case bytecode.OP_SETTABUP:
+			fmt.Printf("\t; %s", upvalName(f, a))
+			if bytecode.ISK(b) {
+				fmt.Printf(" ")
+				printConstant(f, b&bytecode.MAXINDEXRK)
+			}
+			if bytecode.ISK(c) {
+				fmt.Printf(" ")
+				printConstant(f, c&bytecode.MAXINDEXRK)
+			}
+		case bytecode.OP_GETTABLE, bytecode.OP_SELF:
+			if bytecode.ISK(c) {
+				fmt.Printf("\t; ")
+				printConstant(f, c&bytecode.MAXINDEXRK)
+			}
+		case bytecode.OP_SETTABLE, bytecode.OP_ADD, bytecode.OP_SUB, bytecode.OP_MUL,
+			bytecode.OP_MOD, bytecode.OP_POW, bytecode.OP_DIV, bytecode.OP_IDIV,
+			bytecode.OP_BAND, bytecode.OP_BOR, bytecode.OP_BXOR, bytecode.OP_SHL,
+			bytecode.OP_SHR, bytecode.OP_EQ, bytecode.OP_LT, bytecode.OP_LE:
+			if bytecode.ISK(b) || bytecode.ISK(c) {
+				fmt.Printf("\t; ")
+				if bytecode.ISK(b) {
+					printConstant(f, b&bytecode.MAXINDEXRK)
+				} else {
+					fmt.Printf("-")
+				}
+				fmt.Printf(" ")
+				if bytecode.ISK(c) {
+					printConstant(f, c&bytecode.MAXINDEXRK)
+				} else {
+					fmt.Printf("-")
+				}
+			}
+		case bytecode.OP_JMP, bytecode.OP_FORLOOP, bytecode.OP_FORPREP, bytecode.OP_TFORLOOP:
+			fmt.Printf("\t; to %d", sbx+pc+2)
+		case bytecode.OP_SETLIST:
+			if c == 0 {
+				pc++
+				fmt.Printf("\t; %d", int(code[pc]))
+			} else {
+				fmt.Printf("\t; %d", c)
+			}
+		case bytecode.OP_EXTRAARG:
+			fmt.Printf("\t; ")
+			printConstant(f, ax)
 		}
 		fmt.Printf("\n")
 	}
 }
 
-func printDebug(p *binary.Proto) {
-	fmt.Printf("constants (%d):\n", len(p.Constants))
-	for i, k := range p.Constants {
-		s := "?"
-		switch k := k.(type) {
-		case nil:
-			s = "nil"
-		case bool:
-			s = fmt.Sprintf("%t", k)
-		case lua.Integer:
-			s = fmt.Sprintf("%d", k)
-		case lua.Number:
-			s = fmt.Sprintf("%g", k)
-		case string:
-			s = fmt.Sprintf("%q", k)
-		}
-		fmt.Printf("\t%d\t%s\n", i+1, s)
+func s(n int) string {
+	if n != 1 {
+		return "s"
+	}
+	return ""
+}
+
+func printHeader(f *binary.Proto) {
+	source := f.Source
+	if source == "" {
+		source = "=?"
 	}
+	if source[0] == '@' || source[0] == '=' {
+		source = source[1:]
+	} else if source[0] == lua.SIGNATURE[0] {
+		source = "(bstring)"
+	} else {
+		source = "(string)"
+	}
+	funcType := "function"
+	if f.LineDefined == 0 {
+		funcType = "main"
+	}
+	varargFlag := ""
+	if f.IsVararg {
+		varargFlag = "+"
+	}
+	fmt.Printf("\n%s <%s:%d,%d> (%d instruction%s)\n", funcType, source, f.LineDefined, f.LastLineDefined, len(f.Code), s(len(f.Code)))
+	fmt.Printf("%d%s param%s, %d slot%s, %d upvalue%s, ", f.NumParams, varargFlag, s(int(f.NumParams)), f.MaxStackSize, s(int(f.MaxStackSize)), len(f.Upvalues), s(len(f.Upvalues)))
+	fmt.Printf("%d local%s, %d constant%s, %d function%s\n", len(f.LocVars), s(len(f.LocVars)), len(f.Constants), s(len(f.Constants)), len(f.Protos), s(len(f.Protos)))
+}
 
-	fmt.Printf("locals (%d):\n", len(p.LocVars))
-	for i, locVar := range p.LocVars {
+func printDebug(f *binary.Proto) {
+	fmt.Printf("constants (%d):\n", len(f.Constants))
+	for i := range f.Constants {
+		fmt.Printf("\t%d\t", i+1)
+		printConstant(f, i)
+		fmt.Printf("\n")
+	}
+	fmt.Printf("locals (%d):\n", len(f.LocVars))
+	for i, locVar := range f.LocVars {
 		fmt.Printf("\t%d\t%s\t%d\t%d\n", i, locVar.VarName, locVar.StartPC+1, locVar.EndPC+1)
 	}
-
-	fmt.Printf("upvalues (%d):\n", len(p.Upvalues))
-	for i, upvalue := range p.Upvalues {
-		upvalueName := "-"
-		if len(p.UpvalueNames) > 0 {
-			upvalueName = p.UpvalueNames[i]
+	fmt.Printf("upvalues (%d):\n", len(f.Upvalues))
+	for i, upvalue := range f.Upvalues {
+		inStack := 0
+		if upvalue.InStack {
+			inStack = 1
 		}
-		fmt.Printf("\t%d\t%s\t%d\t%d\n", i, upvalueName, upvalue.InStack, upvalue.Idx)
+		fmt.Printf("\t%d\t%s\t%d\t%d\n", i, upvalName(f, i), inStack, upvalue.Idx)
 	}
 }
 
-func s(n int) string {
-	if n != 1 {
-		return "s"
-	}
-	return ""
-}
+/* }====================================================== */
@@ -1,6 +1,8 @@
 package binary
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 
@@ -37,6 +39,9 @@ const (
 	LUA_TLNGSTR = lua.TSTRING | (1 << 4)
 )
 
+/* maximum length for short strings */
+const LUAI_MAXSHORTLEN = 40
+
 /**
  * variant tags for numbers
  */
@@ -78,19 +83,41 @@ type LocVar struct {
 	EndPC   uint32
 }
 
-func Undump(in io.Reader) (proto *Proto, err error) {
+/**
+ * Dump writes 'proto' as a precompiled chunk to 'out', in the format
+ * read by Undump. If 'strip' is true, debug information is left out.
+ */
+func Dump(proto *Proto, out io.Writer, strip bool) error {
+	w := &writer{out: out, order: binary.LittleEndian, strip: strip}
+	w.writeHeader()
+	w.writeByte(byte(len(proto.Upvalues)))
+	w.writeProto(proto, "")
+	return w.err
+}
+
+func Undump(in io.Reader, name string) (proto *Proto, err error) {
+	if name != "" && (name[0] == '@' || name[0] == '=') {
+		name = name[1:]
+	} else if name != "" && name[0] == lua.SIGNATURE[0] {
+		name = "binary string"
+	}
+
 	defer func() {
 		switch x := recover().(type) {
 		case nil:
 			/* no panic */
 		case bailout:
-			err = fmt.Errorf("%s precompiled chunk", x)
+			err = fmt.Errorf("%s: %s precompiled chunk", name, x)
 		default:
 			panic(x)
 		}
 	}()
 
-	r := &reader{in}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{bytes.NewReader(data)}
 	order := r.checkHeader()
 	r.readByte() // size_upvalues
 	proto = r.readProto(order, "")
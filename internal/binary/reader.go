@@ -1,6 +1,7 @@
 package binary
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 	"math"
@@ -10,7 +11,7 @@ import (
 )
 
 type reader struct {
-	in io.Reader
+	in *bytes.Reader
 }
 
 func (r *reader) checkHeader() binary.ByteOrder {
@@ -77,7 +78,7 @@ func (r *reader) readProto(order binary.ByteOrder, parentSource string) *Proto {
 }
 
 func (r *reader) readCode(order binary.ByteOrder) []bytecode.Instruction {
-	code := make([]bytecode.Instruction, r.readUint32(order))
+	code := make([]bytecode.Instruction, r.readCount(order))
 	for i := range code {
 		code[i] = bytecode.Instruction(r.readUint32(order))
 	}
@@ -85,7 +86,7 @@ func (r *reader) readCode(order binary.ByteOrder) []bytecode.Instruction {
 }
 
 func (r *reader) readConstants(order binary.ByteOrder) []interface{} {
-	constants := make([]interface{}, r.readUint32(order))
+	constants := make([]interface{}, r.readCount(order))
 	for i := range constants {
 		switch r.readByte() {
 		case lua.TNIL:
@@ -106,7 +107,7 @@ func (r *reader) readConstants(order binary.ByteOrder) []interface{} {
 }
 
 func (r *reader) readUpvalues(order binary.ByteOrder) []Upvalue {
-	upvalues := make([]Upvalue, r.readUint32(order))
+	upvalues := make([]Upvalue, r.readCount(order))
 	for i := range upvalues {
 		upvalues[i] = Upvalue{
 			InStack: r.readByte() != 0,
@@ -117,7 +118,7 @@ func (r *reader) readUpvalues(order binary.ByteOrder) []Upvalue {
 }
 
 func (r *reader) readProtos(order binary.ByteOrder, parentSource string) []*Proto {
-	protos := make([]*Proto, r.readUint32(order))
+	protos := make([]*Proto, r.readCount(order))
 	for i := range protos {
 		protos[i] = r.readProto(order, parentSource)
 	}
@@ -125,7 +126,7 @@ func (r *reader) readProtos(order binary.ByteOrder, parentSource string) []*Prot
 }
 
 func (r *reader) readLineInfo(order binary.ByteOrder) []uint32 {
-	lineInfo := make([]uint32, r.readUint32(order))
+	lineInfo := make([]uint32, r.readCount(order))
 	for i := range lineInfo {
 		lineInfo[i] = r.readUint32(order)
 	}
@@ -133,7 +134,7 @@ func (r *reader) readLineInfo(order binary.ByteOrder) []uint32 {
 }
 
 func (r *reader) readLocVars(order binary.ByteOrder) []LocVar {
-	locVars := make([]LocVar, r.readUint32(order))
+	locVars := make([]LocVar, r.readCount(order))
 	for i := range locVars {
 		locVars[i] = LocVar{
 			VarName: r.readString(order),
@@ -145,7 +146,7 @@ func (r *reader) readLocVars(order binary.ByteOrder) []LocVar {
 }
 
 func (r *reader) readUpvalueNames(order binary.ByteOrder) []string {
-	upvalueNames := make([]string, r.readUint32(order))
+	upvalueNames := make([]string, r.readCount(order))
 	for i := range upvalueNames {
 		upvalueNames[i] = r.readString(order)
 	}
@@ -165,14 +166,31 @@ func (r *reader) readUint64(order binary.ByteOrder) uint64 {
 }
 
 func (r *reader) readString(order binary.ByteOrder) string {
-	n := uint(r.readByte())
+	n := uint64(r.readByte())
 	if n == 0 {
 		return ""
 	}
 	if n == 0xff { /* long string */
-		n = uint(r.readUint64(order))
+		n = r.readUint64(order)
 	}
-	return string(r.readBytes(n - 1))
+	if n-1 > uint64(r.in.Len()) {
+		panic(bailoutF("truncated"))
+	}
+	return string(r.readBytes(uint(n - 1)))
+}
+
+/**
+ * Reads the number of elements of a vector. Each element takes at least
+ * one byte, so a count beyond the remaining input can only come from a
+ * truncated (or corrupted) chunk; checking it here keeps bad sizes from
+ * turning into huge allocations.
+ */
+func (r *reader) readCount(order binary.ByteOrder) int {
+	n := r.readUint32(order)
+	if uint64(n) > uint64(r.in.Len()) {
+		panic(bailoutF("truncated"))
+	}
+	return int(n)
 }
 
 func (r *reader) readByte() byte {
@@ -181,8 +199,7 @@ func (r *reader) readByte() byte {
 
 func (r *reader) readBytes(n uint) []byte {
 	b := make([]byte, n)
-	_, err := r.in.Read(b)
-	if err != nil {
+	if _, err := io.ReadFull(r.in, b); err != nil {
 		panic(bailoutF("truncated"))
 	}
 	return b
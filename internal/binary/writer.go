@@ -0,0 +1,180 @@
+package binary
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/uganh16/golua/internal/bytecode"
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+type writer struct {
+	out   io.Writer
+	order binary.ByteOrder
+	strip bool
+	err   error /* first error returned by 'out' */
+}
+
+func (w *writer) writeHeader() {
+	w.writeLiteral(lua.SIGNATURE)
+	w.writeByte(LUAC_VERSION)
+	w.writeByte(LUAC_FORMAT)
+	w.writeLiteral(LUAC_DATA)
+	w.writeByte(INT_SIZE)
+	w.writeByte(SIZE_T_SIZE)
+	w.writeByte(INSTRUCTION_SIZE)
+	w.writeByte(LUA_INTEGER_SIZE)
+	w.writeByte(LUA_NUMBER_SIZE)
+	w.writeUint64(LUAC_INT)
+	w.writeFloat64(LUAC_NUM)
+}
+
+func (w *writer) writeLiteral(s string) {
+	w.writeBytes([]byte(s))
+}
+
+func (w *writer) writeProto(p *Proto, parentSource string) {
+	if w.strip || p.Source == parentSource {
+		w.writeString("") /* no debug info or same source as its parent */
+	} else {
+		w.writeString(p.Source)
+	}
+	w.writeUint32(p.LineDefined)
+	w.writeUint32(p.LastLineDefined)
+	w.writeByte(p.NumParams)
+	if p.IsVararg {
+		w.writeByte(1)
+	} else {
+		w.writeByte(0)
+	}
+	w.writeByte(p.MaxStackSize)
+	w.writeCode(p.Code)
+	w.writeConstants(p.Constants)
+	w.writeUpvalues(p.Upvalues)
+	w.writeProtos(p.Protos, p.Source)
+	w.writeDebug(p)
+}
+
+func (w *writer) writeCode(code []bytecode.Instruction) {
+	w.writeUint32(uint32(len(code)))
+	for _, i := range code {
+		w.writeUint32(uint32(i))
+	}
+}
+
+func (w *writer) writeConstants(constants []interface{}) {
+	w.writeUint32(uint32(len(constants)))
+	for _, k := range constants {
+		switch k := k.(type) {
+		case nil:
+			w.writeByte(lua.TNIL)
+		case bool:
+			w.writeByte(lua.TBOOLEAN)
+			if k {
+				w.writeByte(1)
+			} else {
+				w.writeByte(0)
+			}
+		case lua.Number:
+			w.writeByte(LUA_TNUMFLT)
+			w.writeFloat64(k)
+		case lua.Integer:
+			w.writeByte(LUA_TNUMINT)
+			w.writeUint64(uint64(k))
+		case string:
+			if len(k) <= LUAI_MAXSHORTLEN {
+				w.writeByte(LUA_TSHRSTR)
+			} else {
+				w.writeByte(LUA_TLNGSTR)
+			}
+			w.writeString(k)
+		default:
+			panic("unexpected constant type")
+		}
+	}
+}
+
+func (w *writer) writeUpvalues(upvalues []Upvalue) {
+	w.writeUint32(uint32(len(upvalues)))
+	for _, upvalue := range upvalues {
+		if upvalue.InStack {
+			w.writeByte(1)
+		} else {
+			w.writeByte(0)
+		}
+		w.writeByte(upvalue.Idx)
+	}
+}
+
+func (w *writer) writeProtos(protos []*Proto, source string) {
+	w.writeUint32(uint32(len(protos)))
+	for _, p := range protos {
+		w.writeProto(p, source)
+	}
+}
+
+func (w *writer) writeDebug(p *Proto) {
+	if w.strip {
+		w.writeUint32(0) /* lineinfo */
+		w.writeUint32(0) /* locvars */
+		w.writeUint32(0) /* upvalue names */
+		return
+	}
+	w.writeUint32(uint32(len(p.LineInfo)))
+	for _, line := range p.LineInfo {
+		w.writeUint32(line)
+	}
+	w.writeUint32(uint32(len(p.LocVars)))
+	for _, locVar := range p.LocVars {
+		w.writeString(locVar.VarName)
+		w.writeUint32(locVar.StartPC)
+		w.writeUint32(locVar.EndPC)
+	}
+	w.writeUint32(uint32(len(p.UpvalueNames)))
+	for _, name := range p.UpvalueNames {
+		w.writeString(name)
+	}
+}
+
+func (w *writer) writeFloat64(n float64) {
+	w.writeUint64(math.Float64bits(n))
+}
+
+func (w *writer) writeUint32(n uint32) {
+	b := make([]byte, 4)
+	w.order.PutUint32(b, n)
+	w.writeBytes(b)
+}
+
+func (w *writer) writeUint64(n uint64) {
+	b := make([]byte, 8)
+	w.order.PutUint64(b, n)
+	w.writeBytes(b)
+}
+
+/* the empty string is written as a NULL string ("no value") */
+func (w *writer) writeString(s string) {
+	if s == "" {
+		w.writeByte(0)
+		return
+	}
+	size := uint64(len(s)) + 1 /* include trailing '\0' */
+	if size < 0xff {
+		w.writeByte(byte(size))
+	} else {
+		w.writeByte(0xff)
+		w.writeUint64(size)
+	}
+	w.writeLiteral(s)
+}
+
+func (w *writer) writeByte(b byte) {
+	w.writeBytes([]byte{b})
+}
+
+func (w *writer) writeBytes(b []byte) {
+	if w.err == nil {
+		_, w.err = w.out.Write(b)
+	}
+}
@@ -2,8 +2,69 @@ package bytecode
 
 type Instruction uint32
 
-const MAXARG_Bx = (1 << 18) - 1
-const MAXARG_sBx = MAXARG_Bx >> 1
+/**
+ * size and position of opcode arguments.
+ */
+const (
+	SIZE_C  = 9
+	SIZE_B  = 9
+	SIZE_Bx = SIZE_C + SIZE_B
+	SIZE_A  = 8
+	SIZE_Ax = SIZE_C + SIZE_B + SIZE_A
+	SIZE_OP = 6
+
+	POS_OP = 0
+	POS_A  = POS_OP + SIZE_OP
+	POS_C  = POS_A + SIZE_A
+	POS_B  = POS_C + SIZE_C
+	POS_Bx = POS_C
+	POS_Ax = POS_A
+)
+
+/**
+ * limits for opcode arguments.
+ */
+const (
+	MAXARG_A   = (1 << SIZE_A) - 1
+	MAXARG_B   = (1 << SIZE_B) - 1
+	MAXARG_C   = (1 << SIZE_C) - 1
+	MAXARG_Bx  = (1 << SIZE_Bx) - 1
+	MAXARG_sBx = MAXARG_Bx >> 1 /* 'sBx' is signed */
+	MAXARG_Ax  = (1 << SIZE_Ax) - 1
+)
+
+/* this bit 1 means constant (0 means register) */
+const BITRK = 1 << (SIZE_B - 1)
+
+/* maximum index of a constant usable as an RK operand */
+const MAXINDEXRK = BITRK - 1
+
+/**
+ * invalid register that fits in 8 bits.
+ */
+const NO_REG = MAXARG_A
+
+/* test whether value is a constant */
+func ISK(x int) bool {
+	return x&BITRK != 0
+}
+
+/* code a constant index as a RK value */
+func RKASK(x int) int {
+	return x | BITRK
+}
+
+func CreateABC(op, a, b, c int) Instruction {
+	return Instruction(op<<POS_OP | a<<POS_A | b<<POS_B | c<<POS_C)
+}
+
+func CreateABx(op, a, bx int) Instruction {
+	return Instruction(op<<POS_OP | a<<POS_A | bx<<POS_Bx)
+}
+
+func CreateAx(op, ax int) Instruction {
+	return Instruction(op<<POS_OP | ax<<POS_Ax)
+}
 
 func (i Instruction) Opcode() int {
 	return int(i & 0x3f)
@@ -32,6 +93,31 @@ func (i Instruction) Ax() (ax int) {
 	return int(i >> 6)
 }
 
+func (i *Instruction) setArg(v, pos, size int) {
+	mask := Instruction(((1 << size) - 1) << pos)
+	*i = (*i &^ mask) | (Instruction(v<<pos) & mask)
+}
+
+func (i *Instruction) SetOpcode(op int) {
+	i.setArg(op, POS_OP, SIZE_OP)
+}
+
+func (i *Instruction) SetA(a int) {
+	i.setArg(a, POS_A, SIZE_A)
+}
+
+func (i *Instruction) SetB(b int) {
+	i.setArg(b, POS_B, SIZE_B)
+}
+
+func (i *Instruction) SetC(c int) {
+	i.setArg(c, POS_C, SIZE_C)
+}
+
+func (i *Instruction) SetSBx(sbx int) {
+	i.setArg(sbx+MAXARG_sBx, POS_Bx, SIZE_Bx)
+}
+
 func (i Instruction) OpName() string {
 	return opcodes[i.Opcode()].name
 }
@@ -47,3 +133,11 @@ func (i Instruction) BMode() byte {
 func (i Instruction) CMode() byte {
 	return opcodes[i.Opcode()].argCMode
 }
+
+func (i Instruction) TestTMode() bool {
+	return opcodes[i.Opcode()].testFlag != 0
+}
+
+func (i Instruction) TestAMode() bool {
+	return opcodes[i.Opcode()].setAFlag != 0
+}
@@ -0,0 +1,1172 @@
+package codegen
+
+import (
+	"math"
+
+	"github.com/uganh16/golua/internal/bytecode"
+	"github.com/uganh16/golua/internal/number"
+	"github.com/uganh16/golua/pkg/ast"
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/**
+ * Marks the end of a patch list. It is an invalid value both as an absolute
+ * address, and as a list link (would link an element to itself).
+ */
+const NO_JUMP = -1
+
+/* maximum number of registers in a Lua function (must fit in 8 bits) */
+const MAXREGS = 255
+
+/**
+ * Kinds of variables/expressions
+ */
+type expKind int
+
+const (
+	VVOID      expKind = iota /* when 'expDesc' describes the last expression a list, this kind means an empty list (so, no expression) */
+	VNIL                      /* constant nil */
+	VTRUE                     /* constant true */
+	VFALSE                    /* constant false */
+	VK                        /* constant in 'k'; info = index of constant in 'k' */
+	VKFLT                     /* floating constant; nval = numerical float value */
+	VKINT                     /* integer constant; ival = numerical integer value */
+	VNONRELOC                 /* expression has its value in a fixed register; info = result register */
+	VLOCAL                    /* local variable; info = local register */
+	VUPVAL                    /* upvalue variable; info = index of upvalue in 'upvalues' */
+	VINDEXED                  /* indexed variable; ind.vt = whether 't' is register or upvalue; ind.t = table register or upvalue; ind.idx = key's R/K index */
+	VJMP                      /* expression is a test/comparison; info = pc of corresponding jump instruction */
+	VRELOCABLE                /* expression can put result in any register; info = instruction pc */
+	VCALL                     /* expression is a function call; info = instruction pc */
+	VVARARG                   /* vararg expression; info = instruction pc */
+)
+
+func hasMultRet(k expKind) bool {
+	return k == VCALL || k == VVARARG
+}
+
+type expDesc struct {
+	k    expKind
+	info int         /* for generic use */
+	ival lua.Integer /* for VKINT */
+	nval lua.Number  /* for VKFLT */
+	ind  struct {    /* for indexed variables (VINDEXED) */
+		idx int     /* index (R/K) */
+		t   int     /* table (register or upvalue) */
+		vt  expKind /* whether 't' is register (VLOCAL) or upvalue (VUPVAL) */
+	}
+	t int /* patch list of 'exit when true' */
+	f int /* patch list of 'exit when false' */
+}
+
+func (e *expDesc) init(k expKind, i int) {
+	e.f, e.t = NO_JUMP, NO_JUMP
+	e.k = k
+	e.info = i
+}
+
+func (e *expDesc) hasJumps() bool {
+	return e.t != e.f
+}
+
+/**
+ * If expression is a numeric constant, returns its value and true.
+ */
+func (e *expDesc) toNumeral() (interface{}, bool) {
+	if e.hasJumps() {
+		return nil, false /* not a numeral */
+	}
+	switch e.k {
+	case VKINT:
+		return e.ival, true
+	case VKFLT:
+		return e.nval, true
+	default:
+		return nil, false
+	}
+}
+
+/**
+ * Create a OP_LOADNIL instruction, but try to optimize: if the previous
+ * instruction is also OP_LOADNIL and ranges are compatible, adjust
+ * range of previous instruction instead of emitting a new one. (For
+ * instance, 'local a; local b' will generate a single opcode.)
+ */
+func (fs *funcState) loadNil(from, n int) {
+	l := from + n - 1          /* last register to set nil */
+	if fs.pc > fs.lastTarget { /* no jumps to current position? */
+		previous := &fs.f.Code[fs.pc-1]
+		if previous.Opcode() == bytecode.OP_LOADNIL { /* previous is LOADNIL? */
+			pfrom, pl, _ := previous.ABC() /* get previous range */
+			pl += pfrom
+			if (pfrom <= from && from <= pl+1) ||
+				(from <= pfrom && pfrom <= l+1) { /* can connect both? */
+				if pfrom < from {
+					from = pfrom /* from = min(from, pfrom) */
+				}
+				if pl > l {
+					l = pl /* l = max(l, pl) */
+				}
+				previous.SetA(from)
+				previous.SetB(l - from)
+				return
+			}
+		} /* else go through */
+	}
+	fs.codeABC(bytecode.OP_LOADNIL, from, n-1, 0) /* else no optimization */
+}
+
+/**
+ * Gets the destination address of a jump instruction. Used to traverse
+ * a list of jumps.
+ */
+func (fs *funcState) getJump(pc int) int {
+	_, offset := fs.f.Code[pc].AsBx()
+	if offset == NO_JUMP { /* point to itself represents end of list */
+		return NO_JUMP /* end of list */
+	}
+	return (pc + 1) + offset /* turn offset into absolute position */
+}
+
+/**
+ * Fix jump instruction at position 'pc' to jump to 'dest'.
+ * (Jump addresses are relative in Lua)
+ */
+func (fs *funcState) fixJump(pc, dest int) {
+	offset := dest - (pc + 1)
+	if offset < -bytecode.MAXARG_sBx || offset > bytecode.MAXARG_sBx {
+		fs.gs.syntaxError("control structure too long")
+	}
+	fs.f.Code[pc].SetSBx(offset)
+}
+
+/**
+ * Concatenate jump-list 'l2' into jump-list 'l1'
+ */
+func (fs *funcState) concat(l1 *int, l2 int) {
+	if l2 == NO_JUMP {
+		return /* nothing to concatenate? */
+	} else if *l1 == NO_JUMP { /* no original list? */
+		*l1 = l2 /* 'l1' points to 'l2' */
+	} else {
+		list := *l1
+		for next := fs.getJump(list); next != NO_JUMP; next = fs.getJump(list) { /* find last element */
+			list = next
+		}
+		fs.fixJump(list, l2) /* last element links to 'l2' */
+	}
+}
+
+/**
+ * Create a jump instruction and return its position, so its destination
+ * can be fixed later (with 'fixJump'). If there are jumps to
+ * this position (kept in 'jpc'), link them all together so that
+ * 'patchListAux' will fix all them directly to the final destination.
+ */
+func (fs *funcState) jump() int {
+	jpc := fs.jpc    /* save list of jumps to here */
+	fs.jpc = NO_JUMP /* no more jumps to here */
+	j := fs.codeAsBx(bytecode.OP_JMP, 0, NO_JUMP)
+	fs.concat(&j, jpc) /* keep them on hold */
+	return j
+}
+
+/**
+ * Code a 'return' instruction
+ */
+func (fs *funcState) ret(first, nret int) {
+	fs.codeABC(bytecode.OP_RETURN, first, nret+1, 0)
+}
+
+/**
+ * Code a "conditional jump", that is, a test or comparison opcode
+ * followed by a jump. Return jump position.
+ */
+func (fs *funcState) condJump(op, a, b, c int) int {
+	fs.codeABC(op, a, b, c)
+	return fs.jump()
+}
+
+/**
+ * returns current 'pc' and marks it as a jump target (to avoid wrong
+ * optimizations with consecutive instructions not in the same basic block).
+ */
+func (fs *funcState) getLabel() int {
+	fs.lastTarget = fs.pc
+	return fs.pc
+}
+
+/**
+ * Returns the position of the instruction "controlling" a given
+ * jump (that is, its condition), or the jump itself if it is
+ * unconditional.
+ */
+func (fs *funcState) getJumpControl(pc int) *bytecode.Instruction {
+	if pc >= 1 && fs.f.Code[pc-1].TestTMode() {
+		return &fs.f.Code[pc-1]
+	}
+	return &fs.f.Code[pc]
+}
+
+/**
+ * Patch destination register for a TESTSET instruction.
+ * If instruction in position 'node' is not a TESTSET, return false.
+ * Otherwise, if 'reg' is not 'NO_REG', set it as the destination
+ * register. Otherwise, change instruction to a simple 'TEST' (produces
+ * no register value)
+ */
+func (fs *funcState) patchTestReg(node, reg int) bool {
+	i := fs.getJumpControl(node)
+	if i.Opcode() != bytecode.OP_TESTSET {
+		return false /* cannot patch other instructions */
+	}
+	_, b, c := i.ABC()
+	if reg != bytecode.NO_REG && reg != b {
+		i.SetA(reg)
+	} else {
+		/* no register to put value or register already has the value;
+		   change instruction to simple test */
+		*i = bytecode.CreateABC(bytecode.OP_TEST, b, 0, c)
+	}
+	return true
+}
+
+/**
+ * Traverse a list of tests ensuring no one produces a value
+ */
+func (fs *funcState) removeValues(list int) {
+	for ; list != NO_JUMP; list = fs.getJump(list) {
+		fs.patchTestReg(list, bytecode.NO_REG)
+	}
+}
+
+/**
+ * Traverse a list of tests, patching their destination address and
+ * registers: tests producing values jump to 'vtarget' (and put their
+ * values in 'reg'), other tests jump to 'dtarget'.
+ */
+func (fs *funcState) patchListAux(list, vtarget, reg, dtarget int) {
+	for list != NO_JUMP {
+		next := fs.getJump(list)
+		if fs.patchTestReg(list, reg) {
+			fs.fixJump(list, vtarget)
+		} else {
+			fs.fixJump(list, dtarget) /* jump to default target */
+		}
+		list = next
+	}
+}
+
+/**
+ * Ensure all pending jumps to current position are fixed (jumping
+ * to current position with no values) and reset list of pending
+ * jumps
+ */
+func (fs *funcState) dischargeJpc() {
+	fs.patchListAux(fs.jpc, fs.pc, bytecode.NO_REG, fs.pc)
+	fs.jpc = NO_JUMP
+}
+
+/**
+ * Add elements in 'list' to list of pending jumps to "here"
+ * (current position)
+ */
+func (fs *funcState) patchToHere(list int) {
+	fs.getLabel() /* mark "here" as a jump target */
+	fs.concat(&fs.jpc, list)
+}
+
+/**
+ * Path all jumps in 'list' to jump to 'target'.
+ * (The assert means that we cannot fix a jump to a forward address
+ * because we only know addresses once code is generated.)
+ */
+func (fs *funcState) patchList(list, target int) {
+	if target == fs.pc { /* 'target' is current position? */
+		fs.patchToHere(list) /* add list to pending jumps */
+	} else {
+		fs.patchListAux(list, target, bytecode.NO_REG, target)
+	}
+}
+
+/**
+ * Path all jumps in 'list' to close upvalues up to given 'level'
+ * (The assertion checks that jumps either were closing nothing
+ * or were closing higher levels, from inner blocks.)
+ */
+func (fs *funcState) patchClose(list, level int) {
+	level++ /* argument is +1 to reserve 0 as non-op */
+	for ; list != NO_JUMP; list = fs.getJump(list) {
+		fs.f.Code[list].SetA(level)
+	}
+}
+
+/**
+ * Emit instruction 'i', checking for array sizes and saving also its
+ * line information. Return 'i' position.
+ */
+func (fs *funcState) code(i bytecode.Instruction) int {
+	fs.dischargeJpc() /* 'pc' will change */
+	/* put new instruction in code array */
+	fs.f.Code = append(fs.f.Code, i)
+	/* save corresponding line information */
+	fs.f.LineInfo = append(fs.f.LineInfo, uint32(fs.gs.lastLine))
+	fs.pc++
+	return fs.pc - 1
+}
+
+/**
+ * Format and emit an 'iABC' instruction. (Assertions check consistency
+ * of parameters versus opcode.)
+ */
+func (fs *funcState) codeABC(op, a, b, c int) int {
+	return fs.code(bytecode.CreateABC(op, a, b, c))
+}
+
+/**
+ * Format and emit an 'iABx' instruction.
+ */
+func (fs *funcState) codeABx(op, a, bc int) int {
+	return fs.code(bytecode.CreateABx(op, a, bc))
+}
+
+func (fs *funcState) codeAsBx(op, a, sbc int) int {
+	return fs.codeABx(op, a, sbc+bytecode.MAXARG_sBx)
+}
+
+/**
+ * Emit an "extra argument" instruction (format 'iAx')
+ */
+func (fs *funcState) codeExtraArg(a int) int {
+	return fs.code(bytecode.CreateAx(bytecode.OP_EXTRAARG, a))
+}
+
+/**
+ * Emit a "load constant" instruction, using either 'OP_LOADK'
+ * (if constant index 'k' fits in 18 bits) or an 'OP_LOADKX'
+ * instruction with "extra argument".
+ */
+func (fs *funcState) codeK(reg, k int) int {
+	if k <= bytecode.MAXARG_Bx {
+		return fs.codeABx(bytecode.OP_LOADK, reg, k)
+	}
+	p := fs.codeABx(bytecode.OP_LOADKX, reg, 0)
+	fs.codeExtraArg(k)
+	return p
+}
+
+/**
+ * Check register-stack level, keeping track of its maximum size
+ * in field 'MaxStackSize'
+ */
+func (fs *funcState) checkStack(n int) {
+	newStack := fs.freeReg + n
+	if newStack > int(fs.f.MaxStackSize) {
+		if newStack >= MAXREGS {
+			fs.gs.syntaxError("function or expression needs too many registers")
+		}
+		fs.f.MaxStackSize = byte(newStack)
+	}
+}
+
+/**
+ * Reserve 'n' registers in register stack
+ */
+func (fs *funcState) reserveRegs(n int) {
+	fs.checkStack(n)
+	fs.freeReg += n
+}
+
+/**
+ * Free register 'reg', if it is neither a constant index nor
+ * a local variable.
+ */
+func (fs *funcState) freeRegister(reg int) {
+	if !bytecode.ISK(reg) && reg >= fs.nActVar {
+		fs.freeReg--
+	}
+}
+
+/**
+ * Free register used by expression 'e' (if any)
+ */
+func (fs *funcState) freeExp(e *expDesc) {
+	if e.k == VNONRELOC {
+		fs.freeRegister(e.info)
+	}
+}
+
+/**
+ * Free registers used by expressions 'e1' and 'e2' (if any) in proper
+ * order.
+ */
+func (fs *funcState) freeExps(e1, e2 *expDesc) {
+	r1, r2 := -1, -1
+	if e1.k == VNONRELOC {
+		r1 = e1.info
+	}
+	if e2.k == VNONRELOC {
+		r2 = e2.info
+	}
+	if r1 > r2 {
+		fs.freeRegister(r1)
+		fs.freeRegister(r2)
+	} else {
+		fs.freeRegister(r2)
+		fs.freeRegister(r1)
+	}
+}
+
+/**
+ * Add constant 'v' to prototype's list of constants (field 'Constants').
+ * Use scanner's table to cache position of constants in constant list
+ * and try to reuse constants. Because some values should not be used
+ * as keys (nil cannot be a key, integer keys can collapse with float
+ * keys), the caller must provide a useful 'key' for indexing the cache.
+ */
+func (fs *funcState) addK(key, v interface{}) int {
+	if idx, ok := fs.h[key]; ok { /* is there an index there? */
+		return idx /* reuse index */
+	}
+	/* constant not found; create a new entry */
+	k := len(fs.f.Constants)
+	if k >= bytecode.MAXARG_Ax {
+		fs.errorLimit(bytecode.MAXARG_Ax, "constants")
+	}
+	fs.h[key] = k
+	fs.f.Constants = append(fs.f.Constants, v)
+	return k
+}
+
+/**
+ * Add a string to list of constants and return its index.
+ */
+func (fs *funcState) stringK(s string) int {
+	return fs.addK(s, s) /* use string itself as key */
+}
+
+/**
+ * Add an integer to list of constants and return its index.
+ */
+func (fs *funcState) intK(n lua.Integer) int {
+	return fs.addK(n, n)
+}
+
+/**
+ * Add a float to list of constants and return its index.
+ */
+func (fs *funcState) numberK(r lua.Number) int {
+	return fs.addK(r, r) /* use number itself as key */
+}
+
+/**
+ * Add a boolean to list of constants and return its index.
+ */
+func (fs *funcState) boolK(b bool) int {
+	return fs.addK(b, b) /* use boolean itself as key */
+}
+
+/* key representing nil in the constant cache */
+type nilKey struct{}
+
+/**
+ * Add nil to list of constants and return its index.
+ */
+func (fs *funcState) nilK() int {
+	/* nil is not a useful key; instead use a private value to represent nil */
+	return fs.addK(nilKey{}, nil)
+}
+
+/**
+ * Fix an expression to return the number of results 'nResults'.
+ * Either 'e' is a multi-ret expression (function call or vararg)
+ * or 'nResults' is lua.MULTRET (as any expression can satisfy that).
+ */
+func (fs *funcState) setReturns(e *expDesc, nResults int) {
+	if e.k == VCALL { /* expression is an open function call? */
+		fs.f.Code[e.info].SetC(nResults + 1)
+	} else if e.k == VVARARG {
+		pc := &fs.f.Code[e.info]
+		pc.SetB(nResults + 1)
+		pc.SetA(fs.freeReg)
+		fs.reserveRegs(1)
+	}
+}
+
+func (fs *funcState) setMultRet(e *expDesc) {
+	fs.setReturns(e, lua.MULTRET)
+}
+
+/**
+ * Fix an expression to return one result.
+ * If expression is not a multi-ret expression (function call or
+ * vararg), it already returns one result, so nothing needs to be done.
+ * Function calls become VNONRELOC expressions (as its result comes
+ * fixed in the base register of the call), while vararg expressions
+ * become VRELOCABLE (as OP_VARARG puts its results where it wants).
+ * (Calls are created returning one result, so that does not need
+ * to be fixed.)
+ */
+func (fs *funcState) setOneRet(e *expDesc) {
+	if e.k == VCALL { /* expression is an open function call? */
+		/* already returns 1 value */
+		e.k = VNONRELOC /* result has fixed position */
+		e.info, _, _ = fs.f.Code[e.info].ABC()
+	} else if e.k == VVARARG {
+		fs.f.Code[e.info].SetB(2)
+		e.k = VRELOCABLE /* can relocate its simple result */
+	}
+}
+
+/**
+ * Ensure that expression 'e' is not a variable.
+ */
+func (fs *funcState) dischargeVars(e *expDesc) {
+	switch e.k {
+	case VLOCAL: /* already in a register */
+		e.k = VNONRELOC /* becomes a non-relocatable value */
+	case VUPVAL: /* move value to some (pending) register */
+		e.info = fs.codeABC(bytecode.OP_GETUPVAL, 0, e.info, 0)
+		e.k = VRELOCABLE
+	case VINDEXED:
+		var op int
+		fs.freeRegister(e.ind.idx)
+		if e.ind.vt == VLOCAL { /* is 't' in a register? */
+			fs.freeRegister(e.ind.t)
+			op = bytecode.OP_GETTABLE
+		} else {
+			op = bytecode.OP_GETTABUP /* 't' is in an upvalue */
+		}
+		e.info = fs.codeABC(op, 0, e.ind.t, e.ind.idx)
+		e.k = VRELOCABLE
+	case VVARARG, VCALL:
+		fs.setOneRet(e)
+	default:
+		/* there is one value available (somewhere) */
+	}
+}
+
+/**
+ * Ensures expression value is in register 'reg' (and therefore
+ * 'e' will become a non-relocatable expression).
+ */
+func (fs *funcState) discharge2Reg(e *expDesc, reg int) {
+	fs.dischargeVars(e)
+	switch e.k {
+	case VNIL:
+		fs.loadNil(reg, 1)
+	case VFALSE, VTRUE:
+		b := 0
+		if e.k == VTRUE {
+			b = 1
+		}
+		fs.codeABC(bytecode.OP_LOADBOOL, reg, b, 0)
+	case VK:
+		fs.codeK(reg, e.info)
+	case VKFLT:
+		fs.codeK(reg, fs.numberK(e.nval))
+	case VKINT:
+		fs.codeK(reg, fs.intK(e.ival))
+	case VRELOCABLE:
+		fs.f.Code[e.info].SetA(reg) /* instruction will put result in 'reg' */
+	case VNONRELOC:
+		if reg != e.info {
+			fs.codeABC(bytecode.OP_MOVE, reg, e.info, 0)
+		}
+	default:
+		return /* nothing to do... */
+	}
+	e.info = reg
+	e.k = VNONRELOC
+}
+
+/**
+ * Ensures expression value is in any register.
+ */
+func (fs *funcState) discharge2AnyReg(e *expDesc) {
+	if e.k != VNONRELOC { /* no fixed register yet? */
+		fs.reserveRegs(1)                 /* get a register */
+		fs.discharge2Reg(e, fs.freeReg-1) /* put value there */
+	}
+}
+
+func (fs *funcState) codeLoadBool(a, b, jump int) int {
+	fs.getLabel() /* those instructions may be jump targets */
+	return fs.codeABC(bytecode.OP_LOADBOOL, a, b, jump)
+}
+
+/**
+ * check whether list has any jump that do not produce a value
+ * or produce an inverted value
+ */
+func (fs *funcState) needValue(list int) bool {
+	for ; list != NO_JUMP; list = fs.getJump(list) {
+		if i := fs.getJumpControl(list); i.Opcode() != bytecode.OP_TESTSET {
+			return true
+		}
+	}
+	return false /* not found */
+}
+
+/**
+ * Ensures final expression result (including results from its jump
+ * lists) is in register 'reg'.
+ * If expression has jumps, need to patch these jumps either to
+ * its final position or to "load" instructions (for those tests
+ * that do not produce values).
+ */
+func (fs *funcState) exp2Reg(e *expDesc, reg int) {
+	fs.discharge2Reg(e, reg)
+	if e.k == VJMP { /* expression itself is a test? */
+		fs.concat(&e.t, e.info) /* put this jump in 't' list */
+	}
+	if e.hasJumps() {
+		pf := NO_JUMP /* position of an eventual LOAD false */
+		pt := NO_JUMP /* position of an eventual LOAD true */
+		if fs.needValue(e.t) || fs.needValue(e.f) {
+			fj := NO_JUMP
+			if e.k != VJMP {
+				fj = fs.jump()
+			}
+			pf = fs.codeLoadBool(reg, 0, 1)
+			pt = fs.codeLoadBool(reg, 1, 0)
+			fs.patchToHere(fj)
+		}
+		final := fs.getLabel() /* position after whole expression */
+		fs.patchListAux(e.f, final, reg, pf)
+		fs.patchListAux(e.t, final, reg, pt)
+	}
+	e.f, e.t = NO_JUMP, NO_JUMP
+	e.info = reg
+	e.k = VNONRELOC
+}
+
+/**
+ * Ensures final expression result (including results from its jump
+ * lists) is in next available register.
+ */
+func (fs *funcState) exp2NextReg(e *expDesc) {
+	fs.dischargeVars(e)
+	fs.freeExp(e)
+	fs.reserveRegs(1)
+	fs.exp2Reg(e, fs.freeReg-1)
+}
+
+/**
+ * Ensures final expression result (including results from its jump
+ * lists) is in some (any) register and return that register.
+ */
+func (fs *funcState) exp2AnyReg(e *expDesc) int {
+	fs.dischargeVars(e)
+	if e.k == VNONRELOC { /* expression already has a register? */
+		if !e.hasJumps() { /* no jumps? */
+			return e.info /* result is already in a register */
+		}
+		if e.info >= fs.nActVar { /* reg. is not a local? */
+			fs.exp2Reg(e, e.info) /* put final result in it */
+			return e.info
+		}
+	}
+	fs.exp2NextReg(e) /* otherwise, use next available register */
+	return e.info
+}
+
+/**
+ * Ensures final expression result is either in a register or in an
+ * upvalue.
+ */
+func (fs *funcState) exp2AnyRegUp(e *expDesc) {
+	if e.k != VUPVAL || e.hasJumps() {
+		fs.exp2AnyReg(e)
+	}
+}
+
+/**
+ * Ensures final expression result is either in a register or it is
+ * a constant.
+ */
+func (fs *funcState) exp2Val(e *expDesc) {
+	if e.hasJumps() {
+		fs.exp2AnyReg(e)
+	} else {
+		fs.dischargeVars(e)
+	}
+}
+
+/**
+ * Ensures final expression result is in a valid R/K index
+ * (that is, it is either in a register or in 'k' with an index
+ * in the range of R/K indices).
+ * Returns R/K index.
+ */
+func (fs *funcState) exp2RK(e *expDesc) int {
+	fs.exp2Val(e)
+	switch e.k { /* move constants to 'k' */
+	case VTRUE:
+		e.info = fs.boolK(true)
+	case VFALSE:
+		e.info = fs.boolK(false)
+	case VNIL:
+		e.info = fs.nilK()
+	case VKINT:
+		e.info = fs.intK(e.ival)
+	case VKFLT:
+		e.info = fs.numberK(e.nval)
+	case VK:
+	default:
+		/* not a constant in the right range: put it in a register */
+		return fs.exp2AnyReg(e)
+	}
+	e.k = VK
+	if e.info <= bytecode.MAXINDEXRK { /* constant fits in 'argC'? */
+		return bytecode.RKASK(e.info)
+	}
+	return fs.exp2AnyReg(e)
+}
+
+/**
+ * Generate code to store result of expression 'ex' into variable 'v'.
+ */
+func (fs *funcState) storeVar(v, ex *expDesc) {
+	switch v.k {
+	case VLOCAL:
+		fs.freeExp(ex)
+		fs.exp2Reg(ex, v.info) /* compute 'ex' into proper place */
+		return
+	case VUPVAL:
+		e := fs.exp2AnyReg(ex)
+		fs.codeABC(bytecode.OP_SETUPVAL, e, v.info, 0)
+	case VINDEXED:
+		op := bytecode.OP_SETTABUP
+		if v.ind.vt == VLOCAL {
+			op = bytecode.OP_SETTABLE
+		}
+		e := fs.exp2RK(ex)
+		fs.codeABC(op, v.ind.t, v.ind.idx, e)
+	}
+	fs.freeExp(ex)
+}
+
+/**
+ * Emit SELF instruction (convert expression 'e' into 'e:key(e,').
+ */
+func (fs *funcState) self(e, key *expDesc) {
+	fs.exp2AnyReg(e)
+	ereg := e.info /* register where 'e' was placed */
+	fs.freeExp(e)
+	e.info = fs.freeReg /* base register for op_self */
+	e.k = VNONRELOC     /* self expression has a fixed register */
+	fs.reserveRegs(2)   /* function and 'self' produced by op_self */
+	fs.codeABC(bytecode.OP_SELF, e.info, ereg, fs.exp2RK(key))
+	fs.freeExp(key)
+}
+
+/**
+ * Negate condition 'e' (where 'e' is a comparison).
+ */
+func (fs *funcState) negateCondition(e *expDesc) {
+	pc := fs.getJumpControl(e.info)
+	a, _, _ := pc.ABC()
+	if a != 0 {
+		pc.SetA(0)
+	} else {
+		pc.SetA(1)
+	}
+}
+
+/**
+ * Emit instruction to jump if 'e' is 'cond' (that is, if 'cond'
+ * is true, code will jump if 'e' is true.) Return jump position.
+ * Optimize when 'e' is 'not' something, inverting the condition
+ * and removing the 'not'.
+ */
+func (fs *funcState) jumpOnCond(e *expDesc, cond int) int {
+	if e.k == VRELOCABLE {
+		ie := fs.f.Code[e.info]
+		if ie.Opcode() == bytecode.OP_NOT {
+			fs.pc-- /* remove previous OP_NOT */
+			fs.f.Code = fs.f.Code[:fs.pc]
+			fs.f.LineInfo = fs.f.LineInfo[:fs.pc]
+			_, b, _ := ie.ABC()
+			return fs.condJump(bytecode.OP_TEST, b, 0, cond^1)
+		}
+		/* else go through */
+	}
+	fs.discharge2AnyReg(e)
+	fs.freeExp(e)
+	return fs.condJump(bytecode.OP_TESTSET, bytecode.NO_REG, e.info, cond)
+}
+
+/**
+ * Emit code to go through if 'e' is true, jump otherwise.
+ */
+func (fs *funcState) goIfTrue(e *expDesc) {
+	var pc int /* pc of new jump */
+	fs.dischargeVars(e)
+	switch e.k {
+	case VJMP: /* condition? */
+		fs.negateCondition(e) /* jump when it is false */
+		pc = e.info           /* save jump position */
+	case VK, VKFLT, VKINT, VTRUE:
+		pc = NO_JUMP /* always true; do nothing */
+	default:
+		pc = fs.jumpOnCond(e, 0) /* jump when false */
+	}
+	fs.concat(&e.f, pc) /* insert new jump in false list */
+	fs.patchToHere(e.t) /* true list jumps to here (to go through) */
+	e.t = NO_JUMP
+}
+
+/**
+ * Emit code to go through if 'e' is false, jump otherwise.
+ */
+func (fs *funcState) goIfFalse(e *expDesc) {
+	var pc int /* pc of new jump */
+	fs.dischargeVars(e)
+	switch e.k {
+	case VJMP:
+		pc = e.info /* already jump if true */
+	case VNIL, VFALSE:
+		pc = NO_JUMP /* always false; do nothing */
+	default:
+		pc = fs.jumpOnCond(e, 1) /* jump if true */
+	}
+	fs.concat(&e.t, pc) /* insert new jump in 't' list */
+	fs.patchToHere(e.f) /* false list jumps to here (to go through) */
+	e.f = NO_JUMP
+}
+
+/**
+ * Code 'not e', doing constant folding.
+ */
+func (fs *funcState) codeNot(e *expDesc) {
+	fs.dischargeVars(e)
+	switch e.k {
+	case VNIL, VFALSE:
+		e.k = VTRUE /* true == not nil == not false */
+	case VK, VKFLT, VKINT, VTRUE:
+		e.k = VFALSE /* false == not "x" == not 0.5 == not 1 == not true */
+	case VJMP:
+		fs.negateCondition(e)
+	case VRELOCABLE, VNONRELOC:
+		fs.discharge2AnyReg(e)
+		fs.freeExp(e)
+		e.info = fs.codeABC(bytecode.OP_NOT, 0, e.info, 0)
+		e.k = VRELOCABLE
+	}
+	/* interchange true and false lists */
+	e.f, e.t = e.t, e.f
+	fs.removeValues(e.f) /* values are useless when negated */
+	fs.removeValues(e.t)
+}
+
+/**
+ * Create expression 't[k]'. 't' must have its final result already in a
+ * register or upvalue.
+ */
+func (fs *funcState) indexed(t, k *expDesc) {
+	t.ind.t = t.info         /* register or upvalue index */
+	t.ind.idx = fs.exp2RK(k) /* R/K index for key */
+	if t.k == VUPVAL {
+		t.ind.vt = VUPVAL
+	} else {
+		t.ind.vt = VLOCAL
+	}
+	t.k = VINDEXED
+}
+
+/**
+ * Return false if folding can raise an error.
+ * Bitwise operations need operands convertible to integers; division
+ * operations cannot have 0 as divisor.
+ */
+func validOp(op lua.ArithOp, v1, v2 interface{}) bool {
+	switch op {
+	case lua.OPBAND, lua.OPBOR, lua.OPBXOR, lua.OPSHL, lua.OPSHR, lua.OPBNOT: /* conversion errors */
+		_, ok1 := toInteger(v1)
+		_, ok2 := toInteger(v2)
+		return ok1 && ok2
+	case lua.OPDIV, lua.OPIDIV, lua.OPMOD: /* division by 0 */
+		return toNumber(v2) != 0
+	default:
+		return true /* everything else is valid */
+	}
+}
+
+/**
+ * Try to "constant-fold" an operation; return true iff successful.
+ * (In this case, 'e1' has the final result.)
+ */
+func (fs *funcState) constFolding(op lua.ArithOp, e1, e2 *expDesc) bool {
+	v1, ok1 := e1.toNumeral()
+	v2, ok2 := e2.toNumeral()
+	if !ok1 || !ok2 || !validOp(op, v1, v2) {
+		return false /* non-numeric operands or not safe to fold */
+	}
+	switch res := arith(op, v1, v2).(type) {
+	case lua.Integer:
+		e1.k = VKINT
+		e1.ival = res
+	case lua.Number: /* folds neither NaN nor 0.0 (to avoid problems with -0.0) */
+		if math.IsNaN(res) || res == 0 {
+			return false
+		}
+		e1.k = VKFLT
+		e1.nval = res
+	}
+	return true
+}
+
+/**
+ * Emit code for unary expressions that "produce values"
+ * (everything but 'not').
+ * Expression to produce final result will be encoded in 'e'.
+ */
+func (fs *funcState) codeUnExpVal(op int, e *expDesc, line int) {
+	r := fs.exp2AnyReg(e) /* opcodes operate only on registers */
+	fs.freeExp(e)
+	e.info = fs.codeABC(op, 0, r, 0) /* generate opcode */
+	e.k = VRELOCABLE                 /* all those operations are relocatable */
+	fs.fixLine(line)
+}
+
+/**
+ * Emit code for binary expressions that "produce values"
+ * (everything but logical operators 'and'/'or' and comparison
+ * operators).
+ * Expression to produce final result will be encoded in 'e1'.
+ * Because 'exp2RK' can free registers, its calls must be
+ * in "stack order" (that is, first on 'e2', which may have more
+ * recent registers to be released).
+ */
+func (fs *funcState) codeBinExpVal(op int, e1, e2 *expDesc, line int) {
+	rk2 := fs.exp2RK(e2) /* both operands are "RK" */
+	rk1 := fs.exp2RK(e1)
+	fs.freeExps(e1, e2)
+	e1.info = fs.codeABC(op, 0, rk1, rk2) /* generate opcode */
+	e1.k = VRELOCABLE                     /* all those operations are relocatable */
+	fs.fixLine(line)
+}
+
+/**
+ * Emit code for comparisons.
+ */
+func (fs *funcState) codeComp(opr ast.BinOp, e1, e2 *expDesc) {
+	var rk1 int
+	if e1.k == VK {
+		rk1 = bytecode.RKASK(e1.info)
+	} else {
+		rk1 = e1.info
+	}
+	rk2 := fs.exp2RK(e2)
+	fs.freeExps(e1, e2)
+	switch opr {
+	case ast.OpNe: /* '(a ~= b)' ==> 'not (a == b)' */
+		e1.info = fs.condJump(bytecode.OP_EQ, 0, rk1, rk2)
+	case ast.OpGt, ast.OpGe:
+		/* '(a > b)' ==> '(b < a)';  '(a >= b)' ==> '(b <= a)' */
+		op := int(opr-ast.OpNe) + bytecode.OP_EQ
+		e1.info = fs.condJump(op, 1, rk2, rk1) /* invert operands */
+	default: /* '==', '<', '<=' use their own opcodes */
+		op := int(opr-ast.OpEq) + bytecode.OP_EQ
+		e1.info = fs.condJump(op, 1, rk1, rk2)
+	}
+	e1.k = VJMP
+}
+
+/**
+ * Apply prefix operation 'op' to expression 'e'.
+ */
+func (fs *funcState) prefix(op ast.UnOp, e *expDesc, line int) {
+	ef := expDesc{k: VKINT, t: NO_JUMP, f: NO_JUMP} /* fake 2nd operand */
+	switch op {
+	case ast.OpMinus, ast.OpBNot: /* use 'ef' as fake 2nd operand */
+		if fs.constFolding(lua.ArithOp(op)+lua.OPUNM, e, &ef) {
+			break
+		}
+		fs.codeUnExpVal(int(op)+bytecode.OP_UNM, e, line)
+	case ast.OpLen:
+		fs.codeUnExpVal(int(op)+bytecode.OP_UNM, e, line)
+	case ast.OpNot:
+		fs.codeNot(e)
+	}
+}
+
+/**
+ * Process 1st operand 'v' of binary operation 'op' before reading
+ * 2nd operand.
+ */
+func (fs *funcState) infix(op ast.BinOp, v *expDesc) {
+	switch op {
+	case ast.OpAnd:
+		fs.goIfTrue(v) /* go ahead only if 'v' is true */
+	case ast.OpOr:
+		fs.goIfFalse(v) /* go ahead only if 'v' is false */
+	case ast.OpConcat:
+		fs.exp2NextReg(v) /* operand must be on the 'stack' */
+	case ast.OpAdd, ast.OpSub, ast.OpMul, ast.OpDiv, ast.OpIDiv, ast.OpMod, ast.OpPow,
+		ast.OpBAnd, ast.OpBOr, ast.OpBXor, ast.OpShl, ast.OpShr:
+		if _, ok := v.toNumeral(); !ok {
+			fs.exp2RK(v)
+		}
+		/* else keep numeral, which may be folded with 2nd operand */
+	default:
+		fs.exp2RK(v)
+	}
+}
+
+/**
+ * Finalize code for binary operation, after reading 2nd operand.
+ * For '(a .. b .. c)' (which is '(a .. (b .. c))', because
+ * concatenation is right associative), merge second CONCAT into first
+ * one.
+ */
+func (fs *funcState) posfix(op ast.BinOp, e1, e2 *expDesc, line int) {
+	switch op {
+	case ast.OpAnd:
+		fs.dischargeVars(e2)
+		fs.concat(&e2.f, e1.f)
+		*e1 = *e2
+	case ast.OpOr:
+		fs.dischargeVars(e2)
+		fs.concat(&e2.t, e1.t)
+		*e1 = *e2
+	case ast.OpConcat:
+		fs.exp2Val(e2)
+		if e2.k == VRELOCABLE && fs.f.Code[e2.info].Opcode() == bytecode.OP_CONCAT {
+			fs.freeExp(e1)
+			fs.f.Code[e2.info].SetB(e1.info)
+			e1.k = VRELOCABLE
+			e1.info = e2.info
+		} else {
+			fs.exp2NextReg(e2) /* operand must be on the 'stack' */
+			fs.codeBinExpVal(bytecode.OP_CONCAT, e1, e2, line)
+		}
+	case ast.OpAdd, ast.OpSub, ast.OpMul, ast.OpDiv, ast.OpIDiv, ast.OpMod, ast.OpPow,
+		ast.OpBAnd, ast.OpBOr, ast.OpBXor, ast.OpShl, ast.OpShr:
+		if !fs.constFolding(lua.ArithOp(op)+lua.OPADD, e1, e2) {
+			fs.codeBinExpVal(int(op)+bytecode.OP_ADD, e1, e2, line)
+		}
+	case ast.OpEq, ast.OpLt, ast.OpLe, ast.OpNe, ast.OpGt, ast.OpGe:
+		fs.codeComp(op, e1, e2)
+	}
+}
+
+/**
+ * Change line information associated with current position.
+ */
+func (fs *funcState) fixLine(line int) {
+	fs.f.LineInfo[fs.pc-1] = uint32(line)
+}
+
+/**
+ * Emit a SETLIST instruction.
+ * 'base' is register that keeps table;
+ * 'nElems' is #table plus those to be stored now;
+ * 'toStore' is number of values (in registers 'base + 1',...) to add to
+ * table (or lua.MULTRET to add up to stack top).
+ */
+func (fs *funcState) setList(base, nElems, toStore int) {
+	c := (nElems-1)/bytecode.LFIELDS_PER_FLUSH + 1
+	b := toStore
+	if toStore == lua.MULTRET {
+		b = 0
+	}
+	if c <= bytecode.MAXARG_C {
+		fs.codeABC(bytecode.OP_SETLIST, base, b, c)
+	} else if c <= bytecode.MAXARG_Ax {
+		fs.codeABC(bytecode.OP_SETLIST, base, b, 0)
+		fs.codeExtraArg(c)
+	} else {
+		fs.gs.syntaxError("constructor too long")
+	}
+	fs.freeReg = base + 1 /* free registers with list values */
+}
+
+func toNumber(v interface{}) lua.Number {
+	switch x := v.(type) {
+	case lua.Integer:
+		return lua.Number(x)
+	case lua.Number:
+		return x
+	default:
+		return 0
+	}
+}
+
+func toInteger(v interface{}) (lua.Integer, bool) {
+	switch x := v.(type) {
+	case lua.Integer:
+		return x, true
+	case lua.Number:
+		return number.FloatToInteger(x)
+	default:
+		return 0, false
+	}
+}
+
+/**
+ * Raw arithmetic on numeric constants, with the semantics of the
+ * virtual machine. Both operands must be numbers, and the operation
+ * must be valid for them (see 'validOp').
+ */
+func arith(op lua.ArithOp, v1, v2 interface{}) interface{} {
+	switch op {
+	case lua.OPBAND, lua.OPBOR, lua.OPBXOR, lua.OPSHL, lua.OPSHR, lua.OPBNOT:
+		a, _ := toInteger(v1)
+		b, _ := toInteger(v2)
+		switch op {
+		case lua.OPBAND:
+			return a & b
+		case lua.OPBOR:
+			return a | b
+		case lua.OPBXOR:
+			return a ^ b
+		case lua.OPSHL:
+			return number.ShiftLeft(a, b)
+		case lua.OPSHR:
+			return number.ShiftRight(a, b)
+		default: /* lua.OPBNOT */
+			return ^a
+		}
+	case lua.OPDIV, lua.OPPOW: /* operate only on floats */
+		a, b := toNumber(v1), toNumber(v2)
+		if op == lua.OPDIV {
+			return a / b
+		}
+		return math.Pow(a, b)
+	}
+	a, ok1 := v1.(lua.Integer)
+	b, ok2 := v2.(lua.Integer)
+	if ok1 && ok2 {
+		switch op {
+		case lua.OPADD:
+			return a + b
+		case lua.OPSUB:
+			return a - b
+		case lua.OPMUL:
+			return a * b
+		case lua.OPMOD:
+			return number.IMod(a, b)
+		case lua.OPIDIV:
+			return number.IFloorDiv(a, b)
+		default: /* lua.OPUNM */
+			return -a
+		}
+	}
+	x, y := toNumber(v1), toNumber(v2)
+	switch op {
+	case lua.OPADD:
+		return x + y
+	case lua.OPSUB:
+		return x - y
+	case lua.OPMUL:
+		return x * y
+	case lua.OPMOD:
+		return number.FMod(x, y)
+	case lua.OPIDIV:
+		return number.FFloorDiv(x, y)
+	default: /* lua.OPUNM */
+		return -x
+	}
+}
@@ -0,0 +1,474 @@
+/**
+ * Package codegen lowers the syntax tree of a Lua chunk into the function
+ * prototypes run by the virtual machine. It follows the code generator of
+ * the reference implementation (lcode.c and the code-emitting half of
+ * lparser.c), so the generated bytecode matches what 'luac' produces.
+ */
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/uganh16/golua/internal/binary"
+	"github.com/uganh16/golua/internal/bytecode"
+	"github.com/uganh16/golua/internal/lexer"
+	"github.com/uganh16/golua/pkg/ast"
+	"github.com/uganh16/golua/pkg/parser"
+)
+
+/*
+maximum number of local variables per function (must be smaller
+
+	than 250, due to the bytecode format)
+*/
+const MAXVARS = 200
+
+/* maximum number of upvalues per function */
+const MAXUPVAL = 255
+
+/* description of active local variable */
+type varDesc struct {
+	idx int /* variable index in stack */
+}
+
+/* description of pending goto statements and label statements */
+type labelDesc struct {
+	name    string /* label identifier */
+	pc      int    /* position in code */
+	line    int    /* line where it appeared */
+	nActVar int    /* local level where it appears in current block */
+}
+
+/* nodes for block list (list of active blocks) */
+type blockCnt struct {
+	previous   *blockCnt /* chain */
+	firstLabel int       /* index of first label in this block */
+	firstGoto  int       /* index of first pending goto in this block */
+	nActVar    int       /* # active locals outside the block */
+	upval      bool      /* true if some variable in the block is an upvalue */
+	isLoop     bool      /* true if 'block' is a loop */
+}
+
+/* state needed to generate code for a given function */
+type funcState struct {
+	f          *binary.Proto       /* current function header */
+	prev       *funcState          /* enclosing function */
+	gs         *genState           /* code generator state */
+	bl         *blockCnt           /* chain of current blocks */
+	h          map[interface{}]int /* table to find (and reuse) elements in 'Constants' */
+	pc         int                 /* next position to code (equivalent to 'ncode') */
+	lastTarget int                 /* 'label' of last 'jump label' */
+	jpc        int                 /* list of pending jumps to 'pc' */
+	firstLocal int                 /* index of first local var (in genState.actVar) */
+	nActVar    int                 /* number of active local variables */
+	freeReg    int                 /* first free register */
+}
+
+/* shared state of all functions being generated */
+type genState struct {
+	fs       *funcState  /* current function */
+	source   string      /* current source name */
+	lastLine int         /* line of last node 'consumed' */
+	actVar   []varDesc   /* list of active local variables */
+	gt       []labelDesc /* list of pending gotos */
+	label    []labelDesc /* list of active labels */
+}
+
+/**
+ * GenProto generates the main function of a chunk from its syntax tree.
+ * Errors detected while generating code (such as a 'goto' without a
+ * visible label) are returned as a *parser.SyntaxError, like the ones
+ * reported by the parser.
+ */
+func GenProto(chunk *ast.Block, source string) (proto *binary.Proto, err error) {
+	defer func() {
+		switch x := recover().(type) {
+		case nil:
+			/* no panic */
+		case lexer.SyntaxError:
+			proto, err = nil, &parser.SyntaxError{ChunkName: x.ChunkID, Line: x.Line, Message: x.Msg}
+		default:
+			panic(x)
+		}
+	}()
+
+	gs := &genState{source: source}
+	proto = gs.mainFunc(chunk)
+	return
+}
+
+func (gs *genState) syntaxError(msg string) {
+	panic(lexer.SyntaxError{ChunkID: lexer.ChunkID(gs.source), Line: gs.lastLine, Msg: msg})
+}
+
+/* semantic errors are reported at the current line, without a token */
+func (gs *genState) semError(msg string) {
+	gs.syntaxError(msg)
+}
+
+func (fs *funcState) errorLimit(limit int, what string) {
+	where := "main function"
+	if line := fs.f.LineDefined; line != 0 {
+		where = fmt.Sprintf("function at line %d", line)
+	}
+	fs.gs.syntaxError(fmt.Sprintf("too many %s (limit is %d) in %s", what, limit, where))
+}
+
+func (fs *funcState) checkLimit(v, l int, what string) {
+	if v > l {
+		fs.errorLimit(l, what)
+	}
+}
+
+/*
+** {======================================================================
+** Variables
+** =======================================================================
+ */
+
+func (fs *funcState) registerLocalVar(varName string) int {
+	if len(fs.f.LocVars) >= 1<<15-1 {
+		fs.errorLimit(1<<15-1, "local variables")
+	}
+	fs.f.LocVars = append(fs.f.LocVars, binary.LocVar{VarName: varName})
+	return len(fs.f.LocVars) - 1
+}
+
+func (fs *funcState) newLocalVar(name string) {
+	gs := fs.gs
+	reg := fs.registerLocalVar(name)
+	fs.checkLimit(len(gs.actVar)+1-fs.firstLocal, MAXVARS, "local variables")
+	gs.actVar = append(gs.actVar, varDesc{reg})
+}
+
+func (fs *funcState) getLocVar(i int) *binary.LocVar {
+	idx := fs.gs.actVar[fs.firstLocal+i].idx
+	return &fs.f.LocVars[idx]
+}
+
+func (fs *funcState) adjustLocalVars(nVars int) {
+	fs.nActVar += nVars
+	for ; nVars > 0; nVars-- {
+		fs.getLocVar(fs.nActVar - nVars).StartPC = uint32(fs.pc)
+	}
+}
+
+func (fs *funcState) removeVars(toLevel int) {
+	n := len(fs.gs.actVar) - (fs.nActVar - toLevel)
+	for fs.nActVar > toLevel {
+		fs.nActVar--
+		fs.getLocVar(fs.nActVar).EndPC = uint32(fs.pc)
+	}
+	fs.gs.actVar = fs.gs.actVar[:n]
+}
+
+func (fs *funcState) searchUpvalue(name string) int {
+	for i, upName := range fs.f.UpvalueNames {
+		if upName == name {
+			return i
+		}
+	}
+	return -1 /* not found */
+}
+
+func (fs *funcState) newUpvalue(name string, v *expDesc) int {
+	f := fs.f
+	fs.checkLimit(len(f.Upvalues)+1, MAXUPVAL, "upvalues")
+	f.Upvalues = append(f.Upvalues, binary.Upvalue{
+		InStack: v.k == VLOCAL,
+		Idx:     byte(v.info),
+	})
+	f.UpvalueNames = append(f.UpvalueNames, name)
+	return len(f.Upvalues) - 1
+}
+
+func (fs *funcState) searchVar(n string) int {
+	for i := fs.nActVar - 1; i >= 0; i-- {
+		if n == fs.getLocVar(i).VarName {
+			return i
+		}
+	}
+	return -1 /* not found */
+}
+
+/**
+ * Mark block where variable at given level was defined
+ * (to emit close instructions later).
+ */
+func (fs *funcState) markUpval(level int) {
+	bl := fs.bl
+	for bl.nActVar > level {
+		bl = bl.previous
+	}
+	bl.upval = true
+}
+
+/**
+ * Find variable with given name 'n'. If it is an upvalue, add this
+ * upvalue into all intermediate functions.
+ */
+func singleVarAux(fs *funcState, n string, v *expDesc, base bool) {
+	if fs == nil { /* no more levels? */
+		v.init(VVOID, 0) /* default is global */
+		return
+	}
+	if idx := fs.searchVar(n); idx >= 0 { /* look up locals at current level */
+		v.init(VLOCAL, idx) /* variable is local */
+		if !base {
+			fs.markUpval(idx) /* local will be used as an upval */
+		}
+	} else { /* not found as local at current level; try upvalues */
+		idx := fs.searchUpvalue(n) /* try existing upvalues */
+		if idx < 0 {               /* not found? */
+			singleVarAux(fs.prev, n, v, false) /* try upper levels */
+			if v.k == VVOID {                  /* not found? */
+				return /* it is a global */
+			}
+			/* else was LOCAL or UPVAL */
+			idx = fs.newUpvalue(n, v) /* will be a new upvalue */
+		}
+		v.init(VUPVAL, idx) /* new or old upvalue */
+	}
+}
+
+func (fs *funcState) singleVar(name string, v *expDesc) {
+	singleVarAux(fs, name, v, true)
+	if v.k == VVOID { /* global name? */
+		var key expDesc
+		singleVarAux(fs, "_ENV", v, true) /* get environment variable */
+		key.init(VK, fs.stringK(name))    /* key is variable name */
+		fs.indexed(v, &key)               /* env[varname] */
+	}
+}
+
+func (fs *funcState) adjustAssign(nVars, nExps int, e *expDesc) {
+	extra := nVars - nExps
+	if hasMultRet(e.k) {
+		extra++ /* includes call itself */
+		if extra < 0 {
+			extra = 0
+		}
+		fs.setReturns(e, extra) /* last exp. provides the difference */
+		if extra > 1 {
+			fs.reserveRegs(extra - 1)
+		}
+	} else {
+		if e.k != VVOID { /* at least one expression? */
+			fs.exp2NextReg(e) /* close last expression */
+		}
+		if extra > 0 {
+			reg := fs.freeReg
+			fs.reserveRegs(extra)
+			fs.loadNil(reg, extra)
+		}
+	}
+	if nExps > nVars {
+		fs.freeReg -= nExps - nVars /* remove extra values */
+	}
+}
+
+/* }====================================================================== */
+
+/*
+** {======================================================================
+** Blocks, gotos and labels
+** =======================================================================
+ */
+
+/**
+ * Solves the goto at index 'g' to given 'label' and removes it
+ * from the list of pending gotos.
+ * If it jumps into the scope of some variable, raises an error.
+ */
+func (gs *genState) closeGoto(g int, label *labelDesc) {
+	fs := gs.fs
+	gt := gs.gt[g]
+	if gt.nActVar < label.nActVar {
+		vname := fs.getLocVar(gt.nActVar).VarName
+		gs.semError(fmt.Sprintf("<goto %s> at line %d jumps into the scope of local '%s'",
+			gt.name, gt.line, vname))
+	}
+	fs.patchList(gt.pc, label.pc)
+	/* remove goto from pending list */
+	gs.gt = append(gs.gt[:g], gs.gt[g+1:]...)
+}
+
+/**
+ * try to close a goto with existing labels; this solves backward jumps
+ */
+func (gs *genState) findLabel(g int) bool {
+	bl := gs.fs.bl
+	gt := &gs.gt[g]
+	/* check labels in current block for a match */
+	for i := bl.firstLabel; i < len(gs.label); i++ {
+		lb := &gs.label[i]
+		if lb.name == gt.name { /* correct label? */
+			if gt.nActVar > lb.nActVar &&
+				(bl.upval || len(gs.label) > bl.firstLabel) {
+				gs.fs.patchClose(gt.pc, lb.nActVar)
+			}
+			gs.closeGoto(g, lb) /* close it */
+			return true
+		}
+	}
+	return false /* label not found; cannot close goto */
+}
+
+func (gs *genState) newLabelEntry(l *[]labelDesc, name string, line, pc int) int {
+	*l = append(*l, labelDesc{name: name, pc: pc, line: line, nActVar: gs.fs.nActVar})
+	return len(*l) - 1
+}
+
+/**
+ * check whether new label 'lb' matches any pending gotos in current
+ * block; solves forward jumps
+ */
+func (gs *genState) findGotos(lb *labelDesc) {
+	i := gs.fs.bl.firstGoto
+	for i < len(gs.gt) {
+		if gs.gt[i].name == lb.name {
+			gs.closeGoto(i, lb)
+		} else {
+			i++
+		}
+	}
+}
+
+/**
+ * export pending gotos to outer level, to check them against
+ * outer labels; if the block being exited has upvalues, and
+ * the goto exits the scope of any variable (which can be the
+ * upvalue), close those variables being exited.
+ */
+func (fs *funcState) moveGotosOut(bl *blockCnt) {
+	gs := fs.gs
+	i := bl.firstGoto
+	/* correct pending gotos to current block and try to close it
+	   with visible labels */
+	for i < len(gs.gt) {
+		gt := &gs.gt[i]
+		if gt.nActVar > bl.nActVar {
+			if bl.upval {
+				fs.patchClose(gt.pc, bl.nActVar)
+			}
+			gt.nActVar = bl.nActVar
+		}
+		if !gs.findLabel(i) {
+			i++ /* move to next one */
+		}
+	}
+}
+
+func (fs *funcState) enterBlock(bl *blockCnt, isLoop bool) {
+	bl.isLoop = isLoop
+	bl.nActVar = fs.nActVar
+	bl.firstLabel = len(fs.gs.label)
+	bl.firstGoto = len(fs.gs.gt)
+	bl.upval = false
+	bl.previous = fs.bl
+	fs.bl = bl
+}
+
+/**
+ * create a label named 'break' to resolve break statements
+ */
+func (gs *genState) breakLabel() {
+	l := gs.newLabelEntry(&gs.label, "break", 0, gs.fs.pc)
+	gs.findGotos(&gs.label[l])
+}
+
+/**
+ * generates an error for an undefined 'goto'; choose appropriate
+ * message when label name is a reserved word (which can only be 'break')
+ */
+func (gs *genState) undefGoto(gt *labelDesc) {
+	var msg string
+	if lexer.IsReserved(gt.name) {
+		msg = fmt.Sprintf("<%s> at line %d not inside a loop", gt.name, gt.line)
+	} else {
+		msg = fmt.Sprintf("no visible label '%s' for <goto> at line %d", gt.name, gt.line)
+	}
+	gs.semError(msg)
+}
+
+func (fs *funcState) leaveBlock() {
+	bl := fs.bl
+	gs := fs.gs
+	if bl.previous != nil && bl.upval {
+		/* create a 'jump to here' to close upvalues */
+		j := fs.jump()
+		fs.patchClose(j, bl.nActVar)
+		fs.patchToHere(j)
+	}
+	if bl.isLoop {
+		gs.breakLabel() /* close pending breaks */
+	}
+	fs.bl = bl.previous
+	fs.removeVars(bl.nActVar)
+	fs.freeReg = fs.nActVar             /* free registers */
+	gs.label = gs.label[:bl.firstLabel] /* remove local labels */
+	if bl.previous != nil {             /* inside a nested block? */
+		fs.moveGotosOut(bl) /* update pending gotos to outer block */
+	} else if bl.firstGoto < len(gs.gt) { /* pending gotos in outer block? */
+		gs.undefGoto(&gs.gt[bl.firstGoto]) /* error */
+	}
+}
+
+/* }====================================================================== */
+
+/*
+** {======================================================================
+** Functions
+** =======================================================================
+ */
+
+/**
+ * codes instruction to create new closure in parent function.
+ */
+func (fs *funcState) codeClosure(v *expDesc) {
+	v.init(VRELOCABLE, fs.codeABx(bytecode.OP_CLOSURE, 0, len(fs.f.Protos)-1))
+	fs.exp2NextReg(v) /* fix it at the last register */
+}
+
+func (gs *genState) openFunc(f *binary.Proto, bl *blockCnt) *funcState {
+	fs := &funcState{
+		f:          f,
+		prev:       gs.fs, /* linked list of funcstates */
+		gs:         gs,
+		h:          map[interface{}]int{},
+		jpc:        NO_JUMP,
+		firstLocal: len(gs.actVar),
+	}
+	gs.fs = fs
+	f.Source = gs.source
+	f.MaxStackSize = 2 /* registers 0/1 are always valid */
+	fs.enterBlock(bl, false)
+	return fs
+}
+
+func (gs *genState) closeFunc() {
+	fs := gs.fs
+	fs.ret(0, 0) /* final return */
+	fs.leaveBlock()
+	gs.fs = fs.prev
+}
+
+/**
+ * compiles the main function, which is a regular vararg function with an
+ * upvalue named '_ENV'
+ */
+func (gs *genState) mainFunc(chunk *ast.Block) *binary.Proto {
+	var bl blockCnt
+	var v expDesc
+	f := &binary.Proto{}
+	fs := gs.openFunc(f, &bl)
+	f.IsVararg = true         /* main function is always declared vararg */
+	v.init(VLOCAL, 0)         /* create and... */
+	fs.newUpvalue("_ENV", &v) /* ...set environment upvalue */
+	fs.statList(chunk.Stats, false)
+	gs.lastLine = chunk.LastLine
+	gs.closeFunc()
+	return f
+}
+
+/* }====================================================================== */
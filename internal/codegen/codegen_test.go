@@ -0,0 +1,149 @@
+package codegen
+
+import (
+	"testing"
+
+	"github.com/uganh16/golua/internal/binary"
+	"github.com/uganh16/golua/internal/bytecode"
+	"github.com/uganh16/golua/pkg/parser"
+)
+
+func genProto(t *testing.T, chunk string) *binary.Proto {
+	block, err := parser.Parse(chunk, "=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := GenProto(block, "=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proto
+}
+
+func checkCode(t *testing.T, p *binary.Proto, expected []bytecode.Instruction) {
+	if len(p.Code) != len(expected) {
+		t.Fatalf("expected %d instructions, got %d", len(expected), len(p.Code))
+	}
+	for pc, i := range p.Code {
+		if i != expected[pc] {
+			a, b, c := i.ABC()
+			t.Errorf("pc %d: expected %s, got %s %d %d %d", pc+1, expected[pc].OpName(), i.OpName(), a, b, c)
+		}
+	}
+}
+
+func asBx(op, a, sbx int) bytecode.Instruction {
+	return bytecode.CreateABx(op, a, sbx+bytecode.MAXARG_sBx)
+}
+
+func TestGenProto(t *testing.T) {
+	p := genProto(t, `local sum = 0
+for i = 1, 100 do
+  if i % 2 == 0 then
+    sum = sum + i
+  end
+end
+return sum`)
+	K := bytecode.RKASK
+	checkCode(t, p, []bytecode.Instruction{
+		bytecode.CreateABx(bytecode.OP_LOADK, 0, 0),
+		bytecode.CreateABx(bytecode.OP_LOADK, 1, 1),
+		bytecode.CreateABx(bytecode.OP_LOADK, 2, 2),
+		bytecode.CreateABx(bytecode.OP_LOADK, 3, 1),
+		asBx(bytecode.OP_FORPREP, 1, 4),
+		bytecode.CreateABC(bytecode.OP_MOD, 5, 4, K(3)),
+		bytecode.CreateABC(bytecode.OP_EQ, 0, 5, K(0)),
+		asBx(bytecode.OP_JMP, 0, 1),
+		bytecode.CreateABC(bytecode.OP_ADD, 0, 0, 4),
+		asBx(bytecode.OP_FORLOOP, 1, -5),
+		bytecode.CreateABC(bytecode.OP_RETURN, 0, 2, 0),
+		bytecode.CreateABC(bytecode.OP_RETURN, 0, 1, 0),
+	})
+	if p.MaxStackSize != 6 || !p.IsVararg || len(p.Constants) != 4 {
+		t.Errorf("bad main function header: %+v", p)
+	}
+	lines := []uint32{1, 2, 2, 2, 2, 3, 3, 3, 4, 2, 7, 7}
+	for pc, line := range lines {
+		if p.LineInfo[pc] != line {
+			t.Errorf("pc %d: expected line %d, got %d", pc+1, line, p.LineInfo[pc])
+		}
+	}
+	locVars := []struct {
+		name           string
+		startPC, endPC uint32
+	}{
+		{"sum", 1, 12}, {"(for index)", 4, 10}, {"(for limit)", 4, 10},
+		{"(for step)", 4, 10}, {"i", 5, 9},
+	}
+	for i, locVar := range locVars {
+		if v := p.LocVars[i]; v.VarName != locVar.name || v.StartPC != locVar.startPC || v.EndPC != locVar.endPC {
+			t.Errorf("expected local %+v, got %+v", locVar, v)
+		}
+	}
+}
+
+func TestUpvalues(t *testing.T) {
+	p := genProto(t, `local a, b
+function f()
+  return function() return a, b, print end
+end`)
+	if len(p.UpvalueNames) != 1 || p.UpvalueNames[0] != "_ENV" || !p.Upvalues[0].InStack {
+		t.Errorf("main function must have '_ENV' as its only upvalue: %+v", p.Upvalues)
+	}
+	f := p.Protos[0]
+	g := f.Protos[0]
+	expected := []struct {
+		name    string
+		inStack bool
+		idx     byte
+	}{
+		{"a", false, 0}, {"b", false, 1}, {"_ENV", false, 2},
+	}
+	for i, up := range expected {
+		if g.UpvalueNames[i] != up.name || g.Upvalues[i].InStack != up.inStack || g.Upvalues[i].Idx != up.idx {
+			t.Errorf("upvalue %d: expected %+v, got %s %+v", i, up, g.UpvalueNames[i], g.Upvalues[i])
+		}
+	}
+	/* 'f' captures 'a' and 'b' from the stack and '_ENV' from main */
+	if !f.Upvalues[1].InStack || f.Upvalues[1].Idx != 1 || f.Upvalues[2].InStack || f.Upvalues[2].Idx != 0 {
+		t.Errorf("bad upvalues for 'f': %+v", f.Upvalues)
+	}
+	if f.LineDefined != 2 || f.LastLineDefined != 4 || g.LineDefined != 3 {
+		t.Errorf("bad function lines: %d %d %d", f.LineDefined, f.LastLineDefined, g.LineDefined)
+	}
+}
+
+func TestGenErrors(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{"goto l", "test:1: no visible label 'l' for <goto> at line 1"},
+		{"\nbreak", "test:2: <break> at line 2 not inside a loop"},
+		{"::l:: ::l::", "test:1: label 'l' already defined on line 1"},
+		{"do goto l; local x; ::l:: x = 1 end", "test:1: <goto l> at line 1 jumps into the scope of local 'x'"},
+	}
+	for _, test := range tests {
+		block, err := parser.Parse(test.chunk, "=test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = GenProto(block, "=test")
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%q: expected error %q, got %v", test.chunk, test.expected, err)
+		}
+	}
+}
+
+func TestConstFolding(t *testing.T) {
+	/* a division by an integer zero must be left for the VM to raise */
+	p := genProto(t, `return 7 // 0, 7 % 0, 7 // 2`)
+	K := bytecode.RKASK
+	checkCode(t, p, []bytecode.Instruction{
+		bytecode.CreateABC(bytecode.OP_IDIV, 0, K(1), K(0)),
+		bytecode.CreateABC(bytecode.OP_MOD, 1, K(1), K(0)),
+		bytecode.CreateABx(bytecode.OP_LOADK, 2, 2),
+		bytecode.CreateABC(bytecode.OP_RETURN, 0, 4, 0),
+		bytecode.CreateABC(bytecode.OP_RETURN, 0, 1, 0),
+	})
+}
@@ -0,0 +1,238 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/uganh16/golua/internal/binary"
+	"github.com/uganh16/golua/internal/bytecode"
+	"github.com/uganh16/golua/internal/number"
+	"github.com/uganh16/golua/pkg/ast"
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/*
+** {======================================================================
+** Rules for Constructors
+** =======================================================================
+ */
+
+type consControl struct {
+	v       expDesc  /* last list item read */
+	t       *expDesc /* table descriptor */
+	nh      int      /* total number of 'record' elements */
+	na      int      /* total number of array elements */
+	toStore int      /* number of array elements pending to be stored */
+}
+
+/* recfield -> (NAME | '['exp1']') = exp1 */
+func (fs *funcState) recField(cc *consControl, field *ast.Field) {
+	var key, val expDesc
+	reg := fs.freeReg
+	fs.expr(field.Key, &key)
+	fs.exp2Val(&key)
+	cc.nh++
+	rkKey := fs.exp2RK(&key)
+	fs.expr(field.Value, &val)
+	fs.codeABC(bytecode.OP_SETTABLE, cc.t.info, rkKey, fs.exp2RK(&val))
+	fs.freeReg = reg /* free registers */
+}
+
+func (fs *funcState) closeListField(cc *consControl) {
+	if cc.v.k == VVOID {
+		return /* there is no list item */
+	}
+	fs.exp2NextReg(&cc.v)
+	cc.v.k = VVOID
+	if cc.toStore == bytecode.LFIELDS_PER_FLUSH {
+		fs.setList(cc.t.info, cc.na, cc.toStore) /* flush */
+		cc.toStore = 0                           /* no more items pending */
+	}
+}
+
+func (fs *funcState) lastListField(cc *consControl) {
+	if cc.toStore == 0 {
+		return
+	}
+	if hasMultRet(cc.v.k) {
+		fs.setMultRet(&cc.v)
+		fs.setList(cc.t.info, cc.na, lua.MULTRET)
+		cc.na-- /* do not count last expression (unknown number of elements) */
+	} else {
+		if cc.v.k != VVOID {
+			fs.exp2NextReg(&cc.v)
+		}
+		fs.setList(cc.t.info, cc.na, cc.toStore)
+	}
+}
+
+/* listfield -> exp */
+func (fs *funcState) listField(cc *consControl, field *ast.Field) {
+	fs.expr(field.Value, &cc.v)
+	cc.na++
+	cc.toStore++
+}
+
+/**
+ * constructor -> '{' [ field { sep field } [sep] ] '}'
+ * sep -> ',' | ';'
+ */
+func (fs *funcState) constructor(te *ast.TableExp, t *expDesc) {
+	var cc consControl
+	fs.gs.lastLine = te.Line
+	pc := fs.codeABC(bytecode.OP_NEWTABLE, 0, 0, 0)
+	cc.t = t
+	t.init(VRELOCABLE, pc)
+	cc.v.init(VVOID, 0) /* no value (yet) */
+	fs.exp2NextReg(t)   /* fix it at stack top */
+	for _, field := range te.Fields {
+		fs.closeListField(&cc)
+		if field.Key == nil { /* field -> listfield | recfield */
+			fs.listField(&cc, field)
+		} else {
+			fs.recField(&cc, field)
+		}
+	}
+	fs.gs.lastLine = te.LastLine
+	fs.lastListField(&cc)
+	fs.f.Code[pc].SetB(number.Int2fb(uint(cc.na))) /* set initial array size */
+	fs.f.Code[pc].SetC(number.Int2fb(uint(cc.nh))) /* set initial table size */
+}
+
+/* }====================================================================== */
+
+/* body ->  '(' parlist ')' block END */
+func (fs *funcState) body(fd *ast.FuncDefExp, e *expDesc) {
+	var bl blockCnt
+	gs := fs.gs
+	f := &binary.Proto{LineDefined: uint32(fd.Line)}
+	fs.f.Protos = append(fs.f.Protos, f)
+	gs.lastLine = fd.Line
+	newFs := gs.openFunc(f, &bl)
+	if fd.IsMethod {
+		newFs.newLocalVar("self") /* create 'self' parameter */
+		newFs.adjustLocalVars(1)
+	}
+	/* parlist -> [ param { ',' param } ] */
+	for _, name := range fd.ParList {
+		newFs.newLocalVar(name)
+	}
+	f.IsVararg = fd.IsVararg
+	newFs.adjustLocalVars(len(fd.ParList))
+	f.NumParams = byte(newFs.nActVar)
+	newFs.reserveRegs(newFs.nActVar) /* reserve register for parameters */
+	newFs.statList(fd.Block.Stats, false)
+	f.LastLineDefined = uint32(fd.LastLine)
+	gs.lastLine = fd.LastLine
+	fs.codeClosure(e)
+	gs.closeFunc()
+}
+
+/* explist -> expr { ',' expr } */
+func (fs *funcState) expList(exps []ast.Exp, v *expDesc) int {
+	fs.expr(exps[0], v)
+	for _, e := range exps[1:] {
+		fs.exp2NextReg(v)
+		fs.expr(e, v)
+	}
+	return len(exps) /* at least one expression */
+}
+
+func (fs *funcState) funcArgs(call *ast.CallExp, f *expDesc) {
+	var args expDesc
+	var nParams int
+	if len(call.Args) == 0 { /* arg list is empty? */
+		args.k = VVOID
+	} else {
+		fs.expList(call.Args, &args)
+		fs.setMultRet(&args)
+	}
+	base := f.info /* base register for call */
+	if hasMultRet(args.k) {
+		nParams = lua.MULTRET /* open call */
+	} else {
+		if args.k != VVOID {
+			fs.exp2NextReg(&args) /* close last argument */
+		}
+		nParams = fs.freeReg - (base + 1)
+	}
+	fs.gs.lastLine = call.LastLine
+	f.init(VCALL, fs.codeABC(bytecode.OP_CALL, base, nParams+1, 2))
+	fs.fixLine(call.Line)
+	/* call remove function and arguments and leaves (unless changed)
+	   one result */
+	fs.freeReg = base + 1
+}
+
+/**
+ * expr -> simpleexp | unop subexpr | subexpr binop subexpr
+ * Generates code for expression 'e', leaving its description in 'v'.
+ */
+func (fs *funcState) expr(node ast.Exp, v *expDesc) {
+	gs := fs.gs
+	switch e := node.(type) {
+	case *ast.NilExp:
+		gs.lastLine = e.Line
+		v.init(VNIL, 0)
+	case *ast.TrueExp:
+		gs.lastLine = e.Line
+		v.init(VTRUE, 0)
+	case *ast.FalseExp:
+		gs.lastLine = e.Line
+		v.init(VFALSE, 0)
+	case *ast.VarargExp: /* vararg */
+		gs.lastLine = e.Line
+		v.init(VVARARG, fs.codeABC(bytecode.OP_VARARG, 0, 1, 0))
+	case *ast.IntegerExp:
+		gs.lastLine = e.Line
+		v.init(VKINT, 0)
+		v.ival = e.Val
+	case *ast.FloatExp:
+		gs.lastLine = e.Line
+		v.init(VKFLT, 0)
+		v.nval = e.Val
+	case *ast.StringExp:
+		gs.lastLine = e.Line
+		v.init(VK, fs.stringK(e.Str))
+	case *ast.TableExp: /* constructor */
+		fs.constructor(e, v)
+	case *ast.FuncDefExp:
+		fs.body(e, v)
+	case *ast.NameExp:
+		gs.lastLine = e.Line
+		fs.singleVar(e.Name, v)
+	case *ast.ParensExp:
+		fs.expr(e.Exp, v)
+		fs.dischargeVars(v)
+	case *ast.IndexExp: /* fieldsel | '[' exp1 ']' */
+		var key expDesc
+		fs.expr(e.Prefix, v)
+		fs.exp2AnyRegUp(v)
+		fs.expr(e.Key, &key)
+		fs.exp2Val(&key)
+		fs.indexed(v, &key)
+	case *ast.CallExp:
+		fs.expr(e.Prefix, v)
+		if e.Method != "" { /* ':' NAME funcargs */
+			var key expDesc
+			key.init(VK, fs.stringK(e.Method))
+			fs.self(v, &key)
+		} else {
+			fs.exp2NextReg(v)
+		}
+		fs.funcArgs(e, v)
+	case *ast.UnopExp:
+		fs.expr(e.Exp, v)
+		gs.lastLine = e.Line
+		fs.prefix(e.Op, v, e.Line)
+	case *ast.BinopExp:
+		var v2 expDesc
+		fs.expr(e.Exp1, v)
+		gs.lastLine = e.Line
+		fs.infix(e.Op, v)
+		/* read sub-expression with higher priority */
+		fs.expr(e.Exp2, &v2)
+		fs.posfix(e.Op, v, &v2, e.Line)
+	default:
+		panic(fmt.Sprintf("unexpected expression: %T", node))
+	}
+}
@@ -0,0 +1,447 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/uganh16/golua/internal/bytecode"
+	"github.com/uganh16/golua/pkg/ast"
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/*
+** {======================================================================
+** Rules for Statements
+** =======================================================================
+ */
+
+/**
+ * statlist -> { stat [';'] }
+ * 'withUntil' tells whether the list is closed by an 'until' (as in the
+ * body of a 'repeat'), which keeps its locals alive after the last
+ * statement.
+ */
+func (fs *funcState) statList(stats []ast.Stat, withUntil bool) {
+	for i := 0; i < len(stats); {
+		if _, ok := stats[i].(*ast.LabelStat); ok {
+			i = fs.labelStat(stats, i, withUntil)
+		} else {
+			fs.statement(stats[i])
+			i++
+		}
+	}
+}
+
+/* block -> statlist */
+func (fs *funcState) block(b *ast.Block) {
+	var bl blockCnt
+	fs.enterBlock(&bl, false)
+	fs.statList(b.Stats, false)
+	fs.gs.lastLine = b.LastLine
+	fs.leaveBlock()
+}
+
+func (fs *funcState) statement(stat ast.Stat) {
+	switch s := stat.(type) {
+	case *ast.EmptyStat: /* stat -> ';' (empty statement) */
+	case *ast.IfStat: /* stat -> ifstat */
+		fs.ifStat(s)
+	case *ast.WhileStat: /* stat -> whilestat */
+		fs.whileStat(s)
+	case *ast.DoStat: /* stat -> DO block END */
+		fs.gs.lastLine = s.Line
+		fs.block(s.Block)
+	case *ast.NumericForStat: /* stat -> forstat */
+		fs.forNum(s)
+	case *ast.GenericForStat:
+		fs.forList(s)
+	case *ast.RepeatStat: /* stat -> repeatstat */
+		fs.repeatStat(s)
+	case *ast.FuncStat: /* stat -> funcstat */
+		fs.funcStat(s)
+	case *ast.LocalFuncStat: /* stat -> LOCAL FUNCTION NAME body */
+		fs.localFunc(s)
+	case *ast.LocalStat: /* stat -> LOCAL NAME {',' NAME} ['=' explist] */
+		fs.localStat(s)
+	case *ast.ReturnStat: /* stat -> retstat */
+		fs.retStat(s)
+	case *ast.BreakStat: /* stat -> breakstat */
+		fs.gs.lastLine = s.Line
+		fs.gotoStat("break", s.Line, fs.jump())
+	case *ast.GotoStat: /* stat -> 'goto' NAME */
+		fs.gs.lastLine = s.Line
+		fs.gotoStat(s.Name, s.Line, fs.jump())
+	case *ast.CallStat: /* stat -> func */
+		fs.exprStat(s)
+	case *ast.AssignStat: /* stat -> assignment */
+		fs.assignment(s)
+	default:
+		panic(fmt.Sprintf("unexpected statement: %T", stat))
+	}
+	fs.freeReg = fs.nActVar /* free registers */
+}
+
+func (fs *funcState) gotoStat(name string, line, pc int) {
+	gs := fs.gs
+	g := gs.newLabelEntry(&gs.gt, name, line, pc)
+	gs.findLabel(g) /* close it if label already defined */
+}
+
+/* check for repeated labels on the same block */
+func (fs *funcState) checkRepeated(label string) {
+	ll := fs.gs.label
+	for i := fs.bl.firstLabel; i < len(ll); i++ {
+		if label == ll[i].name {
+			fs.gs.semError(fmt.Sprintf("label '%s' already defined on line %d",
+				label, ll[i].line))
+		}
+	}
+}
+
+/**
+ * label -> '::' NAME '::'
+ * Returns the index of the first statement after the label and the
+ * no-op statements that follow it.
+ */
+func (fs *funcState) labelStat(stats []ast.Stat, i int, withUntil bool) int {
+	gs := fs.gs
+	stat := stats[i].(*ast.LabelStat)
+	gs.lastLine = stat.Line
+	fs.checkRepeated(stat.Name) /* check for repeated labels */
+	l := gs.newLabelEntry(&gs.label, stat.Name, stat.Line, fs.getLabel())
+	/* skip other no-op statements */
+	for i++; i < len(stats); {
+		if _, ok := stats[i].(*ast.LabelStat); ok {
+			i = fs.labelStat(stats, i, withUntil)
+		} else if _, ok := stats[i].(*ast.EmptyStat); ok {
+			i++
+		} else {
+			break
+		}
+	}
+	if i == len(stats) && !withUntil { /* label is last no-op statement in the block? */
+		/* assume that locals are already out of scope */
+		gs.label[l].nActVar = fs.bl.nActVar
+	}
+	gs.findGotos(&gs.label[l])
+	return i
+}
+
+/* whilestat -> WHILE cond DO block END */
+func (fs *funcState) whileStat(s *ast.WhileStat) {
+	var bl blockCnt
+	fs.gs.lastLine = s.Line
+	whileInit := fs.getLabel()
+	condExit := fs.cond(s.Cond)
+	fs.enterBlock(&bl, true)
+	fs.block(s.Block)
+	fs.patchList(fs.jump(), whileInit)
+	fs.leaveBlock()
+	fs.patchToHere(condExit) /* false conditions finish the loop */
+}
+
+/* repeatstat -> REPEAT block UNTIL cond */
+func (fs *funcState) repeatStat(s *ast.RepeatStat) {
+	var bl1, bl2 blockCnt
+	fs.gs.lastLine = s.Line
+	repeatInit := fs.getLabel()
+	fs.enterBlock(&bl1, true)  /* loop block */
+	fs.enterBlock(&bl2, false) /* scope block */
+	fs.statList(s.Block.Stats, true)
+	condExit := fs.cond(s.Cond) /* read condition (inside scope block) */
+	if bl2.upval {              /* upvalues? */
+		fs.patchClose(condExit, bl2.nActVar)
+	}
+	fs.leaveBlock()                    /* finish scope */
+	fs.patchList(condExit, repeatInit) /* close the loop */
+	fs.leaveBlock()                    /* finish loop */
+}
+
+/* cond -> exp */
+func (fs *funcState) cond(e ast.Exp) int {
+	var v expDesc
+	fs.expr(e, &v) /* read condition */
+	if v.k == VNIL {
+		v.k = VFALSE /* 'falses' are all equal here */
+	}
+	fs.goIfTrue(&v)
+	return v.f
+}
+
+func (fs *funcState) exp1(e ast.Exp) {
+	var v expDesc
+	fs.expr(e, &v)
+	fs.exp2NextReg(&v)
+}
+
+/* forbody -> DO block */
+func (fs *funcState) forBody(b *ast.Block, base, line, nVars int, isNum bool) {
+	var bl blockCnt
+	var prep, endFor int
+	fs.adjustLocalVars(3) /* control variables */
+	if isNum {
+		prep = fs.codeAsBx(bytecode.OP_FORPREP, base, NO_JUMP)
+	} else {
+		prep = fs.jump()
+	}
+	fs.enterBlock(&bl, false) /* scope for declared variables */
+	fs.adjustLocalVars(nVars)
+	fs.reserveRegs(nVars)
+	fs.block(b)
+	fs.leaveBlock() /* end of scope for declared variables */
+	fs.patchToHere(prep)
+	if isNum { /* numeric for? */
+		endFor = fs.codeAsBx(bytecode.OP_FORLOOP, base, NO_JUMP)
+	} else { /* generic for */
+		fs.codeABC(bytecode.OP_TFORCALL, base, 0, nVars)
+		fs.fixLine(line)
+		endFor = fs.codeAsBx(bytecode.OP_TFORLOOP, base+2, NO_JUMP)
+	}
+	fs.patchList(endFor, prep+1)
+	fs.fixLine(line)
+}
+
+/* fornum -> NAME = exp1,exp1[,exp1] forbody */
+func (fs *funcState) forNum(s *ast.NumericForStat) {
+	var bl blockCnt
+	fs.gs.lastLine = s.Line
+	fs.enterBlock(&bl, true) /* scope for loop and control variables */
+	base := fs.freeReg
+	fs.newLocalVar("(for index)")
+	fs.newLocalVar("(for limit)")
+	fs.newLocalVar("(for step)")
+	fs.newLocalVar(s.VarName)
+	fs.exp1(s.Init) /* initial value */
+	fs.exp1(s.Limit)
+	if s.Step != nil {
+		fs.exp1(s.Step) /* optional step */
+	} else { /* default step = 1 */
+		fs.codeK(fs.freeReg, fs.intK(1))
+		fs.reserveRegs(1)
+	}
+	fs.forBody(s.Block, base, s.Line, 1, true)
+	fs.leaveBlock() /* loop scope ('break' jumps to this point) */
+}
+
+/* forlist -> NAME {,NAME} IN explist forbody */
+func (fs *funcState) forList(s *ast.GenericForStat) {
+	var bl blockCnt
+	var e expDesc
+	fs.gs.lastLine = s.Line
+	fs.enterBlock(&bl, true) /* scope for loop and control variables */
+	base := fs.freeReg
+	/* create control variables */
+	fs.newLocalVar("(for generator)")
+	fs.newLocalVar("(for state)")
+	fs.newLocalVar("(for control)")
+	/* create declared variables */
+	for _, name := range s.NameList {
+		fs.newLocalVar(name)
+	}
+	fs.adjustAssign(3, fs.expList(s.ExpList, &e), &e)
+	fs.checkStack(3) /* extra space to call generator */
+	fs.forBody(s.Block, base, s.Line, len(s.NameList), false)
+	fs.leaveBlock() /* loop scope ('break' jumps to this point) */
+}
+
+/* test_then_block -> [IF | ELSEIF] cond THEN block */
+func (fs *funcState) testThenBlock(cond ast.Exp, b *ast.Block, escapeList *int, hasElse bool) {
+	var bl blockCnt
+	var v expDesc
+	var jf int /* instruction to skip 'then' code (if condition is false) */
+	stats := b.Stats
+	fs.expr(cond, &v) /* read condition */
+	if len(stats) > 0 && isGoto(stats[0]) {
+		fs.goIfFalse(&v)          /* will jump to label if condition is true */
+		fs.enterBlock(&bl, false) /* must enter block before 'goto' */
+		switch s := stats[0].(type) {
+		case *ast.GotoStat:
+			fs.gotoStat(s.Name, s.Line, v.t)
+		case *ast.BreakStat:
+			fs.gotoStat("break", s.Line, v.t)
+		}
+		stats = stats[1:]
+		for len(stats) > 0 { /* skip colons */
+			if _, ok := stats[0].(*ast.EmptyStat); !ok {
+				break
+			}
+			stats = stats[1:]
+		}
+		if len(stats) == 0 { /* 'goto' is the entire block? */
+			fs.gs.lastLine = b.LastLine
+			fs.leaveBlock()
+			return /* and that is it */
+		}
+		/* must skip over 'then' part if condition is false */
+		jf = fs.jump()
+	} else { /* regular case (not goto/break) */
+		fs.goIfTrue(&v) /* skip over block if condition is false */
+		fs.enterBlock(&bl, false)
+		jf = v.f
+	}
+	fs.statList(stats, false) /* 'then' part */
+	fs.gs.lastLine = b.LastLine
+	fs.leaveBlock()
+	if hasElse { /* followed by 'else'/'elseif'? */
+		fs.concat(escapeList, fs.jump()) /* must jump over it */
+	}
+	fs.patchToHere(jf)
+}
+
+func isGoto(stat ast.Stat) bool {
+	switch stat.(type) {
+	case *ast.GotoStat, *ast.BreakStat:
+		return true
+	default:
+		return false
+	}
+}
+
+/* ifstat -> IF cond THEN block {ELSEIF cond THEN block} [ELSE block] END */
+func (fs *funcState) ifStat(s *ast.IfStat) {
+	escapeList := NO_JUMP /* exit list for finished parts */
+	fs.gs.lastLine = s.Line
+	for i, cond := range s.Conds {
+		hasElse := i < len(s.Conds)-1 || s.Else != nil
+		fs.testThenBlock(cond, s.Blocks[i], &escapeList, hasElse)
+	}
+	if s.Else != nil {
+		fs.block(s.Else) /* 'else' part */
+	}
+	fs.patchToHere(escapeList) /* patch escape list to 'if' end */
+}
+
+func (fs *funcState) localFunc(s *ast.LocalFuncStat) {
+	var b expDesc
+	fs.newLocalVar(s.Name) /* new local variable */
+	fs.adjustLocalVars(1)  /* enter its scope */
+	fs.body(s.Func, &b)    /* function created in next register */
+	/* debug information will only see the variable after this point! */
+	fs.getLocVar(b.info).StartPC = uint32(fs.pc)
+}
+
+/* stat -> LOCAL NAME {',' NAME} ['=' explist] */
+func (fs *funcState) localStat(s *ast.LocalStat) {
+	var e expDesc
+	var nExps int
+	fs.gs.lastLine = s.Line
+	for _, name := range s.NameList {
+		fs.newLocalVar(name)
+	}
+	if len(s.ExpList) > 0 {
+		nExps = fs.expList(s.ExpList, &e)
+	} else {
+		e.k = VVOID
+		nExps = 0
+	}
+	fs.adjustAssign(len(s.NameList), nExps, &e)
+	fs.adjustLocalVars(len(s.NameList))
+}
+
+/* funcstat -> FUNCTION funcname body */
+func (fs *funcState) funcStat(s *ast.FuncStat) {
+	var v, b expDesc
+	fs.gs.lastLine = s.Line
+	fs.expr(s.Var, &v)
+	fs.body(s.Func, &b)
+	fs.storeVar(&v, &b)
+	fs.fixLine(s.Line) /* definition "happens" in the first line */
+}
+
+/* stat -> func */
+func (fs *funcState) exprStat(s *ast.CallStat) {
+	var v expDesc
+	fs.expr(s.Call, &v)
+	fs.f.Code[v.info].SetC(1) /* call statement uses no results */
+}
+
+/**
+ * check whether, in an assignment to an upvalue/local variable, the
+ * upvalue/local variable is begin used in a previous assignment to a
+ * table. If so, save original upvalue/local value in a safe place and
+ * use this safe copy in the previous assignment.
+ */
+func (fs *funcState) checkConflict(lh []expDesc, v *expDesc) {
+	extra := fs.freeReg /* eventual position to save local variable */
+	conflict := false
+	for i := range lh { /* check all previous assignments */
+		if lh[i].k == VINDEXED { /* assigning to a table? */
+			/* table is the upvalue/local being assigned now? */
+			if lh[i].ind.vt == v.k && lh[i].ind.t == v.info {
+				conflict = true
+				lh[i].ind.vt = VLOCAL
+				lh[i].ind.t = extra /* previous assignment will use safe copy */
+			}
+			/* index is the local being assigned? (index cannot be upvalue) */
+			if v.k == VLOCAL && lh[i].ind.idx == v.info {
+				conflict = true
+				lh[i].ind.idx = extra /* previous assignment will use safe copy */
+			}
+		}
+	}
+	if conflict {
+		/* copy upvalue/local value to a temporary (in position 'extra') */
+		op := bytecode.OP_GETUPVAL
+		if v.k == VLOCAL {
+			op = bytecode.OP_MOVE
+		}
+		fs.codeABC(op, extra, v.info, 0)
+		fs.reserveRegs(1)
+	}
+}
+
+/* assignment -> suffixedexp {',' suffixedexp} '=' explist */
+func (fs *funcState) assignment(s *ast.AssignStat) {
+	var e expDesc
+	nVars := len(s.VarList)
+	lh := make([]expDesc, nVars)
+	fs.gs.lastLine = s.Line
+	for i, v := range s.VarList {
+		fs.expr(v, &lh[i])
+		if i > 0 && lh[i].k != VINDEXED {
+			fs.checkConflict(lh[:i], &lh[i])
+		}
+	}
+	nExps := fs.expList(s.ExpList, &e)
+	last := nVars - 1
+	if nExps != nVars {
+		fs.adjustAssign(nVars, nExps, &e)
+	} else {
+		fs.setOneRet(&e) /* close last expression */
+		fs.storeVar(&lh[last], &e)
+		last-- /* avoid default */
+	}
+	for i := last; i >= 0; i-- {
+		e.init(VNONRELOC, fs.freeReg-1) /* default assignment */
+		fs.storeVar(&lh[i], &e)
+	}
+}
+
+/* retstat -> RETURN [explist] [';'] */
+func (fs *funcState) retStat(s *ast.ReturnStat) {
+	var e expDesc
+	var first, nRet int /* registers with returned values */
+	fs.gs.lastLine = s.Line
+	if len(s.ExpList) == 0 {
+		first, nRet = 0, 0 /* return no values */
+	} else {
+		nRet = fs.expList(s.ExpList, &e) /* optional return values */
+		if hasMultRet(e.k) {
+			fs.setMultRet(&e)
+			if e.k == VCALL && nRet == 1 { /* tail call? */
+				fs.f.Code[e.info].SetOpcode(bytecode.OP_TAILCALL)
+			}
+			first = fs.nActVar
+			nRet = lua.MULTRET /* return all values */
+		} else {
+			if nRet == 1 { /* only one single value? */
+				first = fs.exp2AnyReg(&e)
+			} else {
+				fs.exp2NextReg(&e) /* values must go to the stack */
+				first = fs.nActVar /* return all active values */
+			}
+		}
+	}
+	fs.ret(first, nRet)
+}
+
+/* }====================================================================== */
@@ -4,3 +4,41 @@ package conf
  * LUAI_MAXSTACK limits the size of the Lua stack.
  */
 const LUAI_MAXSTACK = 1000000
+
+/**
+ * LUA_IDSIZE gives the maximum size for the description of the source
+ * of a function in debug information.
+ */
+const LUA_IDSIZE = 60
+
+/**
+ * LUAI_MAXCCALLS defines a hard limit for the number of nested calls
+ * involving Go functions (and of nested syntactical levels).
+ */
+const LUAI_MAXCCALLS = 200
+
+/**
+ * LUAI_NUMFFORMAT is the format for writing floats: enough significant
+ * digits that most values read back exactly.
+ */
+const LUAI_NUMFFORMAT = "%.14g"
+
+/**
+ * LUA_PATH_DEFAULT is the default path that Lua uses to look for Lua
+ * libraries.
+ */
+const (
+	LUA_VDIR = "5.3"
+	LUA_ROOT = "/usr/local/"
+	LUA_LDIR = LUA_ROOT + "share/lua/" + LUA_VDIR + "/"
+	LUA_CDIR = LUA_ROOT + "lib/lua/" + LUA_VDIR + "/"
+
+	LUA_PATH_DEFAULT = LUA_LDIR + "?.lua;" + LUA_LDIR + "?/init.lua;" +
+		LUA_CDIR + "?.lua;" + LUA_CDIR + "?/init.lua;" +
+		"./?.lua;" + "./?/init.lua"
+)
+
+/**
+ * LUA_DIRSEP is the directory separator (for submodules).
+ */
+const LUA_DIRSEP = "/"
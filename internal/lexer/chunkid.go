@@ -0,0 +1,49 @@
+package lexer
+
+import (
+	"strings"
+
+	"github.com/uganh16/golua/internal/conf"
+)
+
+const (
+	RETS = "..."
+	PRE  = "[string \""
+	POS  = "\"]"
+)
+
+/**
+ * ChunkID formats a source name for use in messages, following the
+ * conventions of 'luaO_chunkid': '=' marks a literal source, '@' marks a
+ * file name, and anything else is the source text itself.
+ */
+func ChunkID(source string) string {
+	bufflen := conf.LUA_IDSIZE
+	l := len(source)
+	if strings.HasPrefix(source, "=") { /* 'literal' source */
+		if l <= bufflen { /* small enough? */
+			return source[1:]
+		}
+		return source[1:bufflen] /* truncate it */
+	} else if strings.HasPrefix(source, "@") { /* file name */
+		if l <= bufflen { /* small enough? */
+			return source[1:]
+		}
+		/* add '...' before rest of name */
+		bufflen -= len(RETS)
+		return RETS + source[l-bufflen+1:]
+	} else { /* string; format as [string "source"] */
+		nl := strings.IndexByte(source, '\n') /* find first new line (if any) */
+		bufflen -= len(PRE+RETS+POS) + 1      /* save space for prefix+suffix+'\0' */
+		if l < bufflen && nl < 0 {            /* small one-line source? */
+			return PRE + source + POS /* keep it */
+		}
+		if nl >= 0 {
+			l = nl /* stop at first newline */
+		}
+		if l > bufflen {
+			l = bufflen
+		}
+		return PRE + source[:l] + RETS + POS
+	}
+}
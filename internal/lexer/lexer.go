@@ -0,0 +1,574 @@
+package lexer
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/uganh16/golua/internal/number"
+)
+
+/* end of stream */
+const EOZ = -1
+
+/* maximum value for a Unicode code point accepted by '\u{XXX}' */
+const MAXUTF = 0x10ffff
+
+/* error raised while reading a chunk, located at a line of its source */
+type SyntaxError struct {
+	ChunkID string /* source name, as formatted by 'ChunkID' */
+	Line    int
+	Msg     string
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.ChunkID, e.Line, e.Msg)
+}
+
+type Lexer struct {
+	chunk      string /* input text */
+	pos        int    /* position of the next character in 'chunk' */
+	current    int    /* current character (charint) */
+	lineNumber int    /* input line counter */
+	lastLine   int    /* line of last token 'consumed' */
+	t          Token  /* current token */
+	lookahead  Token  /* look ahead token */
+	buff       []byte /* buffer for tokens */
+	source     string /* current source name */
+}
+
+func New(chunk, source string) *Lexer {
+	ls := &Lexer{
+		chunk:      chunk,
+		lineNumber: 1,
+		lastLine:   1,
+		lookahead:  Token{Kind: TK_EOS}, /* no look-ahead token */
+		source:     source,
+	}
+	ls.next() /* read first char */
+	return ls
+}
+
+/* Source returns the source name given to New */
+func (ls *Lexer) Source() string {
+	return ls.source
+}
+
+/* Token returns the current token */
+func (ls *Lexer) Token() Token {
+	return ls.t
+}
+
+/* LineNumber returns the current input line */
+func (ls *Lexer) LineNumber() int {
+	return ls.lineNumber
+}
+
+/* LastLine returns the line of the last token consumed by Next */
+func (ls *Lexer) LastLine() int {
+	return ls.lastLine
+}
+
+/* Next advances to the next token */
+func (ls *Lexer) Next() {
+	ls.lastLine = ls.lineNumber
+	if ls.lookahead.Kind != TK_EOS { /* is there a look-ahead token? */
+		ls.t = ls.lookahead                /* use this one */
+		ls.lookahead = Token{Kind: TK_EOS} /* and discharge it */
+	} else {
+		ls.t = ls.llex() /* read next token */
+	}
+}
+
+/* Lookahead peeks at the token after the current one */
+func (ls *Lexer) Lookahead() Token {
+	if ls.lookahead.Kind == TK_EOS {
+		ls.lookahead = ls.llex()
+	}
+	return ls.lookahead
+}
+
+/* SyntaxError raises an error pointing at the current token */
+func (ls *Lexer) SyntaxError(msg string) {
+	ls.lexError(msg, ls.t.Kind)
+}
+
+/* SemError raises an error without the "near <token>" part */
+func (ls *Lexer) SemError(msg string) {
+	ls.lexError(msg, 0)
+}
+
+func (ls *Lexer) txtToken(token int) string {
+	switch token {
+	case TK_NAME, TK_STRING, TK_FLT, TK_INT:
+		return fmt.Sprintf("'%s'", ls.buff)
+	default:
+		return Token2Str(token)
+	}
+}
+
+func (ls *Lexer) lexError(msg string, token int) {
+	if token != 0 {
+		msg = fmt.Sprintf("%s near %s", msg, ls.txtToken(token))
+	}
+	panic(SyntaxError{ChunkID(ls.source), ls.lineNumber, msg})
+}
+
+func (ls *Lexer) next() {
+	if ls.pos < len(ls.chunk) {
+		ls.current = int(ls.chunk[ls.pos])
+		ls.pos++
+	} else {
+		ls.current = EOZ
+	}
+}
+
+func (ls *Lexer) save(c int) {
+	ls.buff = append(ls.buff, byte(c))
+}
+
+func (ls *Lexer) saveAndNext() {
+	ls.save(ls.current)
+	ls.next()
+}
+
+/* remove the last 'n' characters saved in the buffer */
+func (ls *Lexer) buffRemove(n int) {
+	ls.buff = ls.buff[:len(ls.buff)-n]
+}
+
+func (ls *Lexer) currIsNewline() bool {
+	return ls.current == '\n' || ls.current == '\r'
+}
+
+/**
+ * increment line number and skips newline sequence (any of
+ * \n, \r, \n\r, or \r\n)
+ */
+func (ls *Lexer) incLineNumber() {
+	old := ls.current
+	ls.next() /* skip '\n' or '\r' */
+	if ls.currIsNewline() && ls.current != old {
+		ls.next() /* skip '\n\r' or '\r\n' */
+	}
+	ls.lineNumber++
+	if ls.lineNumber >= math.MaxInt32 {
+		ls.lexError("chunk has too many lines", 0)
+	}
+}
+
+/**
+ * Check whether current char is 'c'; if so, skip it.
+ */
+func (ls *Lexer) checkNext1(c int) bool {
+	if ls.current == c {
+		ls.next()
+		return true
+	}
+	return false
+}
+
+/**
+ * Check whether current char is in set 'set' (with two chars) and
+ * saves it
+ */
+func (ls *Lexer) checkNext2(set string) bool {
+	if ls.current == int(set[0]) || ls.current == int(set[1]) {
+		ls.saveAndNext()
+		return true
+	}
+	return false
+}
+
+/**
+ * this function is quite liberal in what it accepts, as the parsers
+ * in package number will reject ill-formed numerals.
+ */
+func (ls *Lexer) readNumeral() Token {
+	expo := "Ee"
+	first := ls.current
+	ls.saveAndNext()
+	if first == '0' && ls.checkNext2("xX") { /* hexadecimal? */
+		expo = "Pp"
+	}
+	for {
+		if ls.checkNext2(expo) { /* exponent part? */
+			ls.checkNext2("-+") /* optional exponent sign */
+		}
+		if isXDigit(ls.current) || ls.current == '.' {
+			ls.saveAndNext()
+		} else {
+			break
+		}
+	}
+	if i, ok := number.ParseInteger(string(ls.buff)); ok {
+		return Token{Kind: TK_INT, Int: i}
+	}
+	if f, ok := number.ParseFloat(string(ls.buff)); ok {
+		return Token{Kind: TK_FLT, Num: f}
+	}
+	ls.lexError("malformed number", TK_FLT) /* format error */
+	panic("unreachable")
+}
+
+/**
+ * skip a sequence '[=*[' or ']=*]'; if sequence is well formed, return
+ * its number of '='s; otherwise, return a negative number (-1 iff there
+ * are no '='s after initial bracket)
+ */
+func (ls *Lexer) skipSep() int {
+	count := 0
+	s := ls.current
+	ls.saveAndNext()
+	for ls.current == '=' {
+		ls.saveAndNext()
+		count++
+	}
+	if ls.current == s {
+		return count
+	}
+	return (-count) - 1
+}
+
+func (ls *Lexer) readLongString(isString bool, sep int) string {
+	line := ls.lineNumber   /* initial line (for error message) */
+	ls.saveAndNext()        /* skip 2nd '[' */
+	if ls.currIsNewline() { /* string starts with a newline? */
+		ls.incLineNumber() /* skip it */
+	}
+	for {
+		switch ls.current {
+		case EOZ: /* error */
+			what := "comment"
+			if isString {
+				what = "string"
+			}
+			ls.lexError(fmt.Sprintf("unfinished long %s (starting at line %d)", what, line), TK_EOS)
+		case ']':
+			if ls.skipSep() == sep {
+				ls.saveAndNext() /* skip 2nd ']' */
+				if isString {
+					return string(ls.buff[2+sep : len(ls.buff)-(2+sep)])
+				}
+				return ""
+			}
+		case '\n', '\r':
+			ls.save('\n')
+			ls.incLineNumber()
+			if !isString {
+				ls.buff = ls.buff[:0] /* avoid wasting space */
+			}
+		default:
+			if isString {
+				ls.saveAndNext()
+			} else {
+				ls.next()
+			}
+		}
+	}
+}
+
+func (ls *Lexer) escCheck(c bool, msg string) {
+	if !c {
+		if ls.current != EOZ {
+			ls.saveAndNext() /* add current to buffer for error message */
+		}
+		ls.lexError(msg, TK_STRING)
+	}
+}
+
+func (ls *Lexer) getHexa() int {
+	ls.saveAndNext()
+	ls.escCheck(isXDigit(ls.current), "hexadecimal digit expected")
+	return hexaValue(ls.current)
+}
+
+func (ls *Lexer) readHexaEsc() int {
+	r := ls.getHexa()
+	r = (r << 4) + ls.getHexa()
+	ls.buffRemove(2) /* remove saved chars from buffer */
+	return r
+}
+
+func (ls *Lexer) readUTF8Esc() uint32 {
+	i := 4           /* chars to be removed: '\', 'u', '{', and first digit */
+	ls.saveAndNext() /* skip 'u' */
+	ls.escCheck(ls.current == '{', "missing '{'")
+	r := uint32(ls.getHexa()) /* must have at least one digit */
+	for {
+		ls.saveAndNext()
+		if !isXDigit(ls.current) {
+			break
+		}
+		i++
+		r = (r << 4) + uint32(hexaValue(ls.current))
+		ls.escCheck(r <= MAXUTF, "UTF-8 value too large")
+	}
+	ls.escCheck(ls.current == '}', "missing '}'")
+	ls.next()        /* skip '}' */
+	ls.buffRemove(i) /* remove saved chars from buffer */
+	return r
+}
+
+func (ls *Lexer) utf8Esc() {
+	ls.buff = append(ls.buff, utf8Esc(ls.readUTF8Esc())...)
+}
+
+func (ls *Lexer) readDecEsc() int {
+	r := 0 /* result accumulator */
+	i := 0
+	for ; i < 3 && isDigit(ls.current); i++ { /* read up to 3 digits */
+		r = 10*r + ls.current - '0'
+		ls.saveAndNext()
+	}
+	ls.escCheck(r <= 0xff, "decimal escape too large")
+	ls.buffRemove(i) /* remove read digits from buffer */
+	return r
+}
+
+func (ls *Lexer) readString(del int) string {
+	ls.saveAndNext() /* keep delimiters (for error messages) */
+	for ls.current != del {
+		switch ls.current {
+		case EOZ:
+			ls.lexError("unfinished string", TK_EOS)
+		case '\n', '\r':
+			ls.lexError("unfinished string", TK_STRING)
+		case '\\': /* escape sequences */
+			var c int        /* final character to be saved */
+			ls.saveAndNext() /* keep '\\' for error messages */
+			switch ls.current {
+			case 'a':
+				c = '\a'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'v':
+				c = '\v'
+			case 'x':
+				c = ls.readHexaEsc()
+			case 'u':
+				ls.utf8Esc()
+				continue
+			case '\n', '\r':
+				ls.incLineNumber()
+				ls.buffRemove(1) /* remove '\\' */
+				ls.save('\n')
+				continue
+			case '\\', '"', '\'':
+				c = ls.current
+			case EOZ:
+				continue /* will raise an error next loop */
+			case 'z': /* zap following span of spaces */
+				ls.buffRemove(1) /* remove '\\' */
+				ls.next()        /* skip the 'z' */
+				for isSpace(ls.current) {
+					if ls.currIsNewline() {
+						ls.incLineNumber()
+					} else {
+						ls.next()
+					}
+				}
+				continue
+			default:
+				ls.escCheck(isDigit(ls.current), "invalid escape sequence")
+				c = ls.readDecEsc() /* digital escape '\ddd' */
+				ls.buffRemove(1)    /* remove '\\' */
+				ls.save(c)
+				continue
+			}
+			ls.next()
+			ls.buffRemove(1) /* remove '\\' */
+			ls.save(c)
+		default:
+			ls.saveAndNext()
+		}
+	}
+	ls.saveAndNext() /* skip delimiter */
+	return string(ls.buff[1 : len(ls.buff)-1])
+}
+
+func (ls *Lexer) llex() Token {
+	ls.buff = ls.buff[:0]
+	for {
+		switch ls.current {
+		case '\n', '\r': /* line breaks */
+			ls.incLineNumber()
+		case ' ', '\f', '\t', '\v': /* spaces */
+			ls.next()
+		case '-': /* '-' or '--' (comment) */
+			ls.next()
+			if ls.current != '-' {
+				return ls.token('-')
+			}
+			/* else is a comment */
+			ls.next()
+			if ls.current == '[' { /* long comment? */
+				sep := ls.skipSep()
+				ls.buff = ls.buff[:0] /* 'skipSep' may dirty the buffer */
+				if sep >= 0 {
+					ls.readLongString(false, sep) /* skip long comment */
+					ls.buff = ls.buff[:0]         /* previous call may dirty the buff. */
+					break
+				}
+			}
+			/* else short comment */
+			for !ls.currIsNewline() && ls.current != EOZ {
+				ls.next() /* skip until end of line (or end of file) */
+			}
+		case '[': /* long string or simply '[' */
+			sep := ls.skipSep()
+			if sep >= 0 {
+				return ls.strToken(ls.readLongString(true, sep))
+			} else if sep != -1 { /* '[=...' missing second bracket */
+				ls.lexError("invalid long string delimiter", TK_STRING)
+			}
+			return ls.token('[')
+		case '=':
+			ls.next()
+			if ls.checkNext1('=') {
+				return ls.token(TK_EQ)
+			}
+			return ls.token('=')
+		case '<':
+			ls.next()
+			if ls.checkNext1('=') {
+				return ls.token(TK_LE)
+			} else if ls.checkNext1('<') {
+				return ls.token(TK_SHL)
+			}
+			return ls.token('<')
+		case '>':
+			ls.next()
+			if ls.checkNext1('=') {
+				return ls.token(TK_GE)
+			} else if ls.checkNext1('>') {
+				return ls.token(TK_SHR)
+			}
+			return ls.token('>')
+		case '/':
+			ls.next()
+			if ls.checkNext1('/') {
+				return ls.token(TK_IDIV)
+			}
+			return ls.token('/')
+		case '~':
+			ls.next()
+			if ls.checkNext1('=') {
+				return ls.token(TK_NE)
+			}
+			return ls.token('~')
+		case ':':
+			ls.next()
+			if ls.checkNext1(':') {
+				return ls.token(TK_DBCOLON)
+			}
+			return ls.token(':')
+		case '"', '\'': /* short literal strings */
+			return ls.strToken(ls.readString(ls.current))
+		case '.': /* '.', '..', '...', or number */
+			ls.saveAndNext()
+			if ls.checkNext1('.') {
+				if ls.checkNext1('.') {
+					return ls.token(TK_DOTS) /* '...' */
+				}
+				return ls.token(TK_CONCAT) /* '..' */
+			} else if !isDigit(ls.current) {
+				return ls.token('.')
+			}
+			return ls.lined(ls.readNumeral())
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			return ls.lined(ls.readNumeral())
+		case EOZ:
+			return ls.token(TK_EOS)
+		default:
+			if isAlpha(ls.current) { /* identifier or reserved word? */
+				for {
+					ls.saveAndNext()
+					if !isAlNum(ls.current) {
+						break
+					}
+				}
+				s := string(ls.buff)
+				if kind, ok := reserved[s]; ok { /* reserved word? */
+					return ls.token(kind)
+				}
+				return Token{Kind: TK_NAME, Line: ls.lineNumber, Str: s}
+			}
+			/* single-char tokens (+ - / ...) */
+			c := ls.current
+			ls.next()
+			return ls.token(c)
+		}
+	}
+}
+
+func (ls *Lexer) token(kind int) Token {
+	return Token{Kind: kind, Line: ls.lineNumber}
+}
+
+func (ls *Lexer) strToken(s string) Token {
+	return Token{Kind: TK_STRING, Line: ls.lineNumber, Str: s}
+}
+
+func (ls *Lexer) lined(t Token) Token {
+	t.Line = ls.lineNumber
+	return t
+}
+
+/**
+ * encodes 'x' the way Lua does, which also accepts surrogates and
+ * values up to 0x10FFFF
+ */
+func utf8Esc(x uint32) []byte {
+	if x < 0x80 { /* ascii? */
+		return []byte{byte(x)}
+	}
+	var buff [8]byte
+	n := 1              /* number of bytes put in buffer (backwards) */
+	mfb := uint32(0x3f) /* maximum that fits in first byte */
+	for {               /* add continuation bytes */
+		buff[len(buff)-n] = byte(0x80 | (x & 0x3f))
+		n++
+		x >>= 6   /* remove added bits */
+		mfb >>= 1 /* now there is one less bit available in first byte */
+		if x <= mfb {
+			break
+		}
+	}
+	buff[len(buff)-n] = byte((^mfb << 1) | x) /* add first byte */
+	return buff[len(buff)-n:]
+}
+
+func isDigit(c int) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isXDigit(c int) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func isAlpha(c int) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
+
+func isAlNum(c int) bool {
+	return isAlpha(c) || isDigit(c)
+}
+
+func isSpace(c int) bool {
+	return c == ' ' || '\t' <= c && c <= '\r'
+}
+
+func hexaValue(c int) int {
+	if isDigit(c) {
+		return c - '0'
+	}
+	return (c | ('a' ^ 'A')) - 'a' + 10
+}
@@ -0,0 +1,163 @@
+package lexer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+func lexAll(t *testing.T, chunk string) (toks []Token, err error) {
+	defer func() {
+		if x, ok := recover().(SyntaxError); ok {
+			err = x
+		}
+	}()
+	ls := New(chunk, "=test")
+	for {
+		ls.Next()
+		tok := ls.Token()
+		if tok.Kind == TK_EOS {
+			return
+		}
+		toks = append(toks, tok)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	chunk := "+ - * / // % ^ # & ~ | << >> == ~= <= >= < > = ( ) { } [ ] :: ; : , . .. ..."
+	expected := []int{'+', '-', '*', '/', TK_IDIV, '%', '^', '#', '&', '~', '|', TK_SHL, TK_SHR,
+		TK_EQ, TK_NE, TK_LE, TK_GE, '<', '>', '=', '(', ')', '{', '}', '[', ']', TK_DBCOLON,
+		';', ':', ',', '.', TK_CONCAT, TK_DOTS}
+	toks, err := lexAll(t, chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toks) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(toks))
+	}
+	for i, tok := range toks {
+		if tok.Kind != expected[i] {
+			t.Errorf("token %d: expected %s, got %s", i, Token2Str(expected[i]), Token2Str(tok.Kind))
+		}
+	}
+}
+
+func TestNumerals(t *testing.T) {
+	tests := []struct {
+		chunk string
+		kind  int
+		i     lua.Integer
+		f     lua.Number
+	}{
+		{"3", TK_INT, 3, 0},
+		{"0xff", TK_INT, 255, 0},
+		{"0xffffffffffffffff", TK_INT, -1, 0},
+		{"9223372036854775807", TK_INT, math.MaxInt64, 0},
+		{"9223372036854775808", TK_FLT, 0, 9223372036854775808.0},
+		{"3.0", TK_FLT, 0, 3.0},
+		{".5", TK_FLT, 0, 0.5},
+		{"3.", TK_FLT, 0, 3.0},
+		{"314.16e-2", TK_FLT, 0, 3.1416},
+		{"1E2", TK_FLT, 0, 100.0},
+		{"0x0.1E", TK_FLT, 0, 0.1171875},
+		{"0xA23p-4", TK_FLT, 0, 162.1875},
+		{"0x1p4", TK_FLT, 0, 16.0},
+		{"0x.8", TK_FLT, 0, 0.5},
+	}
+	for _, test := range tests {
+		toks, err := lexAll(t, test.chunk)
+		if err != nil {
+			t.Errorf("%s: %v", test.chunk, err)
+			continue
+		}
+		if len(toks) != 1 || toks[0].Kind != test.kind || toks[0].Int != test.i || toks[0].Num != test.f {
+			t.Errorf("%s: unexpected tokens %v", test.chunk, toks)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`'hello'`, "hello"},
+		{`"a\tb\\c\"d\'"`, "a\tb\\c\"d'"},
+		{`"\65\066\0677"`, "ABC7"},
+		{`"\x41\x62"`, "Ab"},
+		{`"\u{48}\u{20AC}\u{10FFFF}"`, "H€\U0010ffff"},
+		{"\"a\\z  \n\t  b\"", "ab"},
+		{"\"a\\\nb\"", "a\nb"},
+		{"[[\nfirst\nsecond]]", "first\nsecond"},
+		{"[==[a]]b]=]c]==]", "a]]b]=]c"},
+	}
+	for _, test := range tests {
+		toks, err := lexAll(t, test.chunk)
+		if err != nil {
+			t.Errorf("%s: %v", test.chunk, err)
+			continue
+		}
+		if len(toks) != 1 || toks[0].Kind != TK_STRING || toks[0].Str != test.expected {
+			t.Errorf("%s: unexpected tokens %v", test.chunk, toks)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	toks, err := lexAll(t, "a -- comment\n--[[ long\ncomment ]] b --[==[\n]==] c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []int{1, 3, 4}
+	if len(toks) != len(lines) {
+		t.Fatalf("expected %d tokens, got %d", len(lines), len(toks))
+	}
+	for i, tok := range toks {
+		if tok.Kind != TK_NAME || tok.Line != lines[i] {
+			t.Errorf("token %d: unexpected %v", i, tok)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`"abc`, "test:1: unfinished string near <eof>"},
+		{"'abc\n'", "test:1: unfinished string near ''abc'"},
+		{`"\q"`, `test:1: invalid escape sequence near '"\q'`},
+		{`"\300"`, `test:1: decimal escape too large near '"\300"'`},
+		{`"\xg"`, `test:1: hexadecimal digit expected near '"\xg'`},
+		{`"\u{110000}"`, `test:1: UTF-8 value too large near '"\u{110000'`},
+		{"[==[\nabc", "test:2: unfinished long string (starting at line 1) near <eof>"},
+		{"[=a", "test:1: invalid long string delimiter near '[='"},
+		{"3e", "test:1: malformed number near '3e'"},
+		{"0x", "test:1: malformed number near '0x'"},
+	}
+	for _, test := range tests {
+		_, err := lexAll(t, test.chunk)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: expected error %q, got %v", test.chunk, test.expected, err)
+		}
+	}
+}
+
+func TestChunkID(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"=stdin", "stdin"},
+		{"@test.lua", "test.lua"},
+		{"print(1)", `[string "print(1)"]`},
+		{"x = 1\nprint(x)", `[string "x = 1..."]`},
+		{"@" + string(make([]byte, 100)), "..." + string(make([]byte, 56))},
+	}
+	for _, test := range tests {
+		if id := ChunkID(test.source); id != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.source, test.expected, id)
+		}
+	}
+}
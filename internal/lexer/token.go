@@ -0,0 +1,106 @@
+package lexer
+
+import (
+	"fmt"
+
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+const FIRST_RESERVED = 257
+
+/**
+ * Single-char tokens are represented by their own character code; the
+ * remaining tokens start at FIRST_RESERVED.
+ */
+const (
+	/* terminal symbols denoted by reserved words */
+	TK_AND = iota + FIRST_RESERVED
+	TK_BREAK
+	TK_DO
+	TK_ELSE
+	TK_ELSEIF
+	TK_END
+	TK_FALSE
+	TK_FOR
+	TK_FUNCTION
+	TK_GOTO
+	TK_IF
+	TK_IN
+	TK_LOCAL
+	TK_NIL
+	TK_NOT
+	TK_OR
+	TK_REPEAT
+	TK_RETURN
+	TK_THEN
+	TK_TRUE
+	TK_UNTIL
+	TK_WHILE
+	/* other terminal symbols */
+	TK_IDIV
+	TK_CONCAT
+	TK_DOTS
+	TK_EQ
+	TK_GE
+	TK_LE
+	TK_NE
+	TK_SHL
+	TK_SHR
+	TK_DBCOLON
+	TK_EOS
+	TK_FLT
+	TK_INT
+	TK_NAME
+	TK_STRING
+)
+
+/* number of reserved words */
+const NUM_RESERVED = TK_WHILE - FIRST_RESERVED + 1
+
+/* ORDER RESERVED */
+var tokens = [...]string{
+	"and", "break", "do", "else", "elseif",
+	"end", "false", "for", "function", "goto", "if",
+	"in", "local", "nil", "not", "or", "repeat",
+	"return", "then", "true", "until", "while",
+	"//", "..", "...", "==", ">=", "<=", "~=",
+	"<<", ">>", "::", "<eof>",
+	"<number>", "<integer>", "<name>", "<string>",
+}
+
+var reserved = func() map[string]int {
+	m := make(map[string]int, NUM_RESERVED)
+	for i := 0; i < NUM_RESERVED; i++ {
+		m[tokens[i]] = FIRST_RESERVED + i
+	}
+	return m
+}()
+
+type Token struct {
+	Kind int         /* token kind: a single char or a TK_* constant */
+	Line int         /* line where the token ends */
+	Str  string      /* semantic value for TK_NAME and TK_STRING */
+	Int  lua.Integer /* semantic value for TK_INT */
+	Num  lua.Number  /* semantic value for TK_FLT */
+}
+
+/* IsReserved reports whether 's' is a reserved word */
+func IsReserved(s string) bool {
+	_, ok := reserved[s]
+	return ok
+}
+
+/* Token2Str returns a printable representation of token kind 'token' */
+func Token2Str(token int) string {
+	if token < FIRST_RESERVED { /* single-byte symbols? */
+		if token >= ' ' && token < 0x7f {
+			return fmt.Sprintf("'%c'", token)
+		}
+		return fmt.Sprintf("'<\\%d>'", token) /* control character */
+	}
+	s := tokens[token-FIRST_RESERVED]
+	if token < TK_EOS { /* fixed format (symbols and reserved words)? */
+		return fmt.Sprintf("'%s'", s)
+	}
+	return s /* names, strings, and numerals */
+}
@@ -1,9 +1,12 @@
 package number
 
 import (
+	"fmt"
 	"math"
 	"strconv"
+	"strings"
 
+	"github.com/uganh16/golua/internal/conf"
 	"github.com/uganh16/golua/pkg/lua"
 )
 
@@ -48,16 +51,236 @@ func FloatToInteger(f lua.Number) (lua.Integer, bool) {
 	return i, lua.Number(i) == f
 }
 
+/**
+ * FloatToString formats a float as Lua does: with 'LUAI_NUMFFORMAT',
+ * plus a ".0" suffix when the result looks like an integer, so that
+ * floats and integers print differently. Infinities and NaNs print as
+ * "inf", "-inf", "nan" and "-nan", as with the C library.
+ */
+func FloatToString(f lua.Number) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+	s := fmt.Sprintf(conf.LUAI_NUMFFORMAT, f)
+	if strings.TrimLeft(s, "-0123456789") == "" { /* looks like an int? */
+		s += ".0" /* adds '.0' to result */
+	}
+	return s
+}
+
+/*
+** {======================================================
+** Conversion from strings
+** =======================================================
+ */
+
+/* characters accepted as whitespace around a numeral (as 'isspace') */
+const spaceChars = " \t\n\v\f\r"
+
+/* maximum number of significant digits to read (to avoid overflows) */
+const MAXSIGDIG = 30
+
+/**
+ * ParseInteger converts a numeral to an integer, as the Lua lexer does,
+ * accepting surrounding whitespace and a sign. Hexadecimal numerals wrap
+ * around; decimal ones that overflow are rejected (and should be read
+ * as floats).
+ */
 func ParseInteger(s string) (lua.Integer, bool) {
-	i, err := strconv.ParseInt(s, 10, 64)
-	return i, err == nil
+	var a uint64
+	empty := true
+	s = strings.Trim(s, spaceChars) /* skip initial and trailing spaces */
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") { /* hex? */
+		for s = s[2:]; s != "" && isXDigit(s[0]); s = s[1:] {
+			a = a*16 + uint64(hexaValue(s[0]))
+			empty = false
+		}
+	} else { /* decimal */
+		const maxBy10 = uint64(math.MaxInt64 / 10)
+		const maxLastD = uint64(math.MaxInt64 % 10)
+		for ; s != "" && isDigit(s[0]); s = s[1:] {
+			d := uint64(s[0] - '0')
+			if a >= maxBy10 && (a > maxBy10 || d > maxLastD+b2u(neg)) { /* overflow? */
+				return 0, false /* do not accept it (as integer) */
+			}
+			a = a*10 + d
+			empty = false
+		}
+	}
+	if empty || s != "" { /* something wrong in the numeral */
+		return 0, false
+	}
+	if neg {
+		a = 0 - a
+	}
+	return lua.Integer(a), true
 }
 
+/**
+ * ParseFloat converts a numeral to a float, as the Lua lexer does,
+ * accepting surrounding whitespace and a sign. 'inf' and 'nan' are not
+ * numerals, and neither is Go-only syntax such as digit separators.
+ */
 func ParseFloat(s string) (lua.Number, bool) {
+	s = strings.Trim(s, spaceChars) /* skip initial and trailing spaces */
+	body := s
+	if body != "" && (body[0] == '-' || body[0] == '+') {
+		body = body[1:]
+	}
+	if strings.HasPrefix(body, "0x") || strings.HasPrefix(body, "0X") { /* hex? */
+		f, ok := strx2number(body[2:])
+		if ok && s[0] == '-' {
+			f = -f
+		}
+		return f, ok
+	}
+	/* validate the numeral ourselves: 'strconv' accepts more than Lua */
+	i, digits := 0, 0
+	for ; i < len(body) && isDigit(body[i]); i++ {
+		digits++
+	}
+	if i < len(body) && body[i] == '.' {
+		for i++; i < len(body) && isDigit(body[i]); i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	if i < len(body) && (body[i] == 'e' || body[i] == 'E') {
+		i++
+		if i < len(body) && (body[i] == '+' || body[i] == '-') {
+			i++
+		}
+		if i == len(body) || !isDigit(body[i]) {
+			return 0, false
+		}
+		for i < len(body) && isDigit(body[i]) {
+			i++
+		}
+	}
+	if i != len(body) {
+		return 0, false
+	}
 	f, err := strconv.ParseFloat(s, 64)
-	return f, err == nil
+	if err != nil && err.(*strconv.NumError).Err != strconv.ErrRange {
+		return 0, false
+	}
+	return f, true /* overflows become +-HUGE_VAL, as with 'strtod' */
 }
 
+/* converts the digits of a hexadecimal numeral (after '0x') to a float */
+func strx2number(s string) (lua.Number, bool) {
+	r := 0.0      /* result (accumulator) */
+	sigDig := 0   /* number of significant digits */
+	noSigDig := 0 /* number of non-significant digits */
+	e := 0        /* exponent correction */
+	hasDot := false
+	i := 0
+	for ; i < len(s); i++ {
+		if c := s[i]; c == '.' {
+			if hasDot {
+				break /* second dot? stop loop */
+			}
+			hasDot = true
+		} else if isXDigit(c) {
+			if sigDig == 0 && c == '0' { /* non-significant digit (zero)? */
+				noSigDig++
+			} else if sigDig++; sigDig <= MAXSIGDIG { /* can read it without overflow? */
+				r = r*16.0 + lua.Number(hexaValue(c))
+			} else {
+				e++ /* too many digits; ignore, but still count for exponent */
+			}
+			if hasDot {
+				e-- /* decimal digit? correct exponent */
+			}
+		} else {
+			break /* neither a dot nor a digit */
+		}
+	}
+	if noSigDig+sigDig == 0 { /* no digits? */
+		return 0, false
+	}
+	e *= 4 /* each digit multiplies/divides value by 2^4 */
+
+	if i < len(s) && (s[i] == 'p' || s[i] == 'P') { /* exponent part? */
+		exp1 := 0
+		neg1 := false
+		i++ /* skip 'p' */
+		if i < len(s) && (s[i] == '-' || s[i] == '+') {
+			neg1 = s[i] == '-'
+			i++
+		}
+		if i == len(s) || !isDigit(s[i]) {
+			return 0, false /* invalid; must have at least one digit */
+		}
+		for ; i < len(s) && isDigit(s[i]); i++ {
+			if exp1 < math.MaxInt32/10 {
+				exp1 = exp1*10 + int(s[i]-'0')
+			}
+		}
+		if neg1 {
+			exp1 = -exp1
+		}
+		e += exp1
+	}
+	if i != len(s) {
+		return 0, false
+	}
+	return math.Ldexp(r, e), true
+}
+
+/**
+ * StringToNumber converts a numeral to an integer or, failing that, to
+ * a float, returning the result as a lua.Integer or a lua.Number.
+ */
+func StringToNumber(s string) (interface{}, bool) {
+	if i, ok := ParseInteger(s); ok {
+		return i, true
+	}
+	if f, ok := ParseFloat(s); ok {
+		return f, true
+	}
+	return nil, false /* conversion failed */
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isXDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func hexaValue(c byte) int {
+	if isDigit(c) {
+		return int(c - '0')
+	}
+	return int(c|('a'^'A')) - 'a' + 10
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/* }====================================================== */
+
 /**
  * converts an integer to a "floating point byte", represented as
  * (eeeeexxx), where the real value is (1xxx) * 2^(eeeee - 1) if
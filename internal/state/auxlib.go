@@ -0,0 +1,681 @@
+package state
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/*
+** {======================================================
+** Traceback
+** =======================================================
+ */
+
+const LEVELS1 = 10 /* size of the first part of the stack */
+const LEVELS2 = 11 /* size of the second part of the stack */
+
+/**
+ * Search for 'objidx' in table at index -1. ('level' avoids looping.)
+ * If found, leaves its name ("field" or "module.field") on the top of
+ * the stack and returns true.
+ */
+func (L *luaState) findField(objIdx, level int) bool {
+	if level == 0 || L.Type(-1) != lua.TTABLE {
+		return false /* not found */
+	}
+	L.PushNil() /* start 'next' loop */
+	for L.Next(-2) {
+		if L.Type(-2) == lua.TSTRING { /* ignore non-string keys */
+			if L.RawEqual(objIdx, -1) { /* found object? */
+				L.Pop(1) /* remove value (but keep name) */
+				return true
+			} else if L.findField(objIdx, level-1) { /* try recursively */
+				L.Remove(-2) /* remove table (but keep name) */
+				L.PushString(".")
+				L.Insert(-2) /* place '.' between the two names */
+				L.Concat(3)
+				return true
+			}
+		}
+		L.Pop(1) /* remove value */
+	}
+	return false /* not found */
+}
+
+/**
+ * Search for a global name for the function in 'ar', looking in
+ * 'package.loaded'.
+ */
+func (L *luaState) globalFuncName(ar *lua.Debug) (string, bool) {
+	top := L.GetTop()
+	defer L.SetTop(top)
+	L.GetInfo("f", ar) /* push function */
+	L.GetField(lua.REGISTRYINDEX, lua.LOADED_TABLE)
+	if L.findField(top+1, 2) {
+		/* name of a global function is found as "_G.name" */
+		return strings.TrimPrefix(L.ToString(-1), "_G."), true
+	}
+	return "", false
+}
+
+func (L *luaState) funcName(ar *lua.Debug) string {
+	if name, ok := L.globalFuncName(ar); ok { /* try first a global name */
+		return fmt.Sprintf("function '%s'", name)
+	} else if ar.NameWhat != "" { /* is there a name from code? */
+		return fmt.Sprintf("%s '%s'", ar.NameWhat, ar.Name) /* use it */
+	} else if ar.What == "main" { /* main? */
+		return "main chunk"
+	} else if ar.What != "Go" { /* for Lua functions, use <file:line> */
+		return fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined)
+	} else { /* nothing left... */
+		return "?"
+	}
+}
+
+func lastLevel(L1 lua.State) int {
+	var ar lua.Debug
+	li, le := 1, 1
+	/* find an upper bound */
+	for L1.GetStack(le, &ar) {
+		li = le
+		le *= 2
+	}
+	/* do a binary search */
+	for li < le {
+		m := (li + le) / 2
+		if L1.GetStack(m, &ar) {
+			li = m + 1
+		} else {
+			le = m
+		}
+	}
+	return le - 1
+}
+
+/**
+ * Push a traceback of the stack of 'L1', starting at 'level', preceded
+ * by 'msg' (if not empty).
+ */
+func (L *luaState) Traceback(L1 lua.State, msg string, level int) {
+	var b strings.Builder
+	var ar lua.Debug
+	last := lastLevel(L1)
+	n1 := -1
+	if last-level > LEVELS1+LEVELS2 {
+		n1 = LEVELS1
+	}
+	if msg != "" {
+		b.WriteString(msg)
+		b.WriteString("\n")
+	}
+	b.WriteString("stack traceback:")
+	for L1.GetStack(level, &ar) {
+		level++
+		if n1 == 0 { /* too many levels? */
+			b.WriteString("\n\t...")   /* add a '...' */
+			level = last - LEVELS2 + 1 /* and skip to last ones */
+		} else {
+			L1.GetInfo("Slnt", &ar)
+			fmt.Fprintf(&b, "\n\t%s:", ar.ShortSrc)
+			if ar.CurrentLine > 0 {
+				fmt.Fprintf(&b, "%d:", ar.CurrentLine)
+			}
+			b.WriteString(" in ")
+			b.WriteString(L.funcName(&ar))
+			if ar.IsTailCall {
+				b.WriteString("\n\t(...tail calls...)")
+			}
+		}
+		n1--
+	}
+	L.PushString(b.String())
+}
+
+/* }====================================================== */
+
+/*
+** {======================================================
+** Error-report functions
+** =======================================================
+ */
+
+/**
+ * Raise an error reporting a problem with argument 'arg' of the Go
+ * function that called it, using a standard message that includes
+ * 'extraMsg' as a comment:
+ *	bad argument #arg to 'funcname' (extramsg)
+ */
+func (L *luaState) ArgError(arg int, extraMsg string) int {
+	var ar lua.Debug
+	if !L.GetStack(0, &ar) { /* no stack frame? */
+		return L.ErrorF("bad argument #%d (%s)", arg, extraMsg)
+	}
+	L.GetInfo("n", &ar)
+	if ar.NameWhat == "method" {
+		arg--         /* do not count 'self' */
+		if arg == 0 { /* error is in the self argument itself? */
+			return L.ErrorF("calling '%s' on bad self (%s)", ar.Name, extraMsg)
+		}
+	}
+	if ar.Name == "" {
+		if name, ok := L.globalFuncName(&ar); ok {
+			ar.Name = name
+		} else {
+			ar.Name = "?"
+		}
+	}
+	return L.ErrorF("bad argument #%d to '%s' (%s)", arg, ar.Name, extraMsg)
+}
+
+/**
+ * Raise an error for argument 'arg' not being of the expected type
+ * 'tname', as in "number expected, got nil".
+ */
+func (L *luaState) TypeError(arg int, tname string) int {
+	var typeArg string /* name for the type of the actual argument */
+	if L.GetMetafield(arg, "__name") == lua.TSTRING {
+		typeArg = L.ToString(-1) /* use the given type name */
+	} else if L.Type(arg) == lua.TLIGHTUSERDATA {
+		typeArg = "light userdata" /* special name for messages */
+	} else {
+		typeArg = L.TypeName(L.Type(arg)) /* standard name */
+	}
+	return L.ArgError(arg, fmt.Sprintf("%s expected, got %s", tname, typeArg))
+}
+
+func (L *luaState) tagError(arg int, tag lua.Type) {
+	L.TypeError(arg, L.TypeName(tag))
+}
+
+/**
+ * Push a string "chunkname:currentline: " identifying the position of
+ * the function at 'level' in the call stack, or an empty string if that
+ * is not known. Typically used to prefix error messages.
+ */
+func (L *luaState) Where(level int) {
+	var ar lua.Debug
+	if L.GetStack(level, &ar) { /* check function at level */
+		L.GetInfo("Sl", &ar)    /* get info about it */
+		if ar.CurrentLine > 0 { /* is there info? */
+			L.PushString(fmt.Sprintf("%s:%d: ", ar.ShortSrc, ar.CurrentLine))
+			return
+		}
+	}
+	L.PushString("") /* else, no information available... */
+}
+
+/**
+ * Raise an error with a formatted message, prefixed with the position
+ * of the function that called the running Go function (if known).
+ */
+func (L *luaState) ErrorF(format string, a ...interface{}) int {
+	L.Where(1)
+	L.PushString(fmt.Sprintf(format, a...))
+	L.Concat(2)
+	return L.Error()
+}
+
+/* }====================================================== */
+
+/*
+** {======================================================
+** Userdata's metatable manipulation
+** =======================================================
+ */
+
+/**
+ * Create a metatable for userdata of type 'tname' in the registry, with
+ * a '__name' field, and push it. If the registry already has a value
+ * for 'tname', push that value instead and return false.
+ */
+func (L *luaState) NewMetatable(tname string) bool {
+	if L.GetField(lua.REGISTRYINDEX, tname) != lua.TNIL { /* name already in use? */
+		return false /* leave previous value on top, but return false */
+	}
+	L.Pop(1)
+	L.CreateTable(0, 2) /* create metatable */
+	L.PushString(tname)
+	L.SetField(-2, "__name") /* metatable.__name = tname */
+	L.PushValue(-1)
+	L.SetField(lua.REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+/**
+ * Returns the full userdata at index 'ud' if its metatable is the one
+ * registered as 'tname', or nil otherwise.
+ */
+func (L *luaState) testUdata(ud int, tname string) *userdata {
+	val, _ := L.stackGet(ud)
+	if u, ok := val.(*userdata); ok {
+		if L.GetMetatable(ud) { /* does it have a metatable? */
+			L.GetField(lua.REGISTRYINDEX, tname) /* get correct metatable */
+			same := L.RawEqual(-1, -2)           /* the same? */
+			L.Pop(2)                             /* remove both metatables */
+			if same {
+				return u
+			}
+		}
+	}
+	return nil /* value is not a userdata with a metatable */
+}
+
+/**
+ * TestUdata returns the Go value of the userdata at index 'ud' if it has
+ * type 'tname', or nil otherwise.
+ */
+func (L *luaState) TestUdata(ud int, tname string) interface{} {
+	if u := L.testUdata(ud, tname); u != nil {
+		return u.value
+	}
+	return nil
+}
+
+/* Like 'TestUdata', but raise an error if the value does not match. */
+func (L *luaState) CheckUdata(ud int, tname string) interface{} {
+	u := L.testUdata(ud, tname)
+	if u == nil {
+		L.TypeError(ud, tname)
+	}
+	return u.value
+}
+
+/* }====================================================== */
+
+/*
+** {======================================================
+** Reference system
+** =======================================================
+ */
+
+/* index of free-list header */
+const freeList = 0
+
+/**
+ * Ref creates and returns a reference, in the table at index 't', for
+ * the value on the top of the stack (and pops the value). A reference
+ * is a unique integer key; as long as it is not released with 'Unref',
+ * 'RawGetI(t, ref)' pushes the referenced value. A nil value gets the
+ * fixed reference lua.REFNIL.
+ */
+func (L *luaState) Ref(t int) int {
+	if L.IsNil(-1) {
+		L.Pop(1)          /* remove it from stack */
+		return lua.REFNIL /* 'nil' has a unique fixed reference */
+	}
+	t = L.AbsIndex(t)
+	L.RawGetI(t, freeList)      /* get first free element */
+	ref := int(L.ToInteger(-1)) /* ref = t[freeList] */
+	L.Pop(1)                    /* remove it from stack */
+	if ref != 0 {               /* any free element? */
+		L.RawGetI(t, lua.Integer(ref)) /* remove it from list */
+		L.RawSetI(t, freeList)         /* (t[freeList] = t[ref]) */
+	} else { /* no free elements */
+		ref = L.RawLen(t) + 1 /* get a new reference */
+	}
+	L.RawSetI(t, lua.Integer(ref))
+	return ref
+}
+
+/**
+ * Unref releases reference 'ref' from the table at index 't', so that
+ * the referenced value can be collected and the reference reused.
+ */
+func (L *luaState) Unref(t, ref int) {
+	if ref >= 0 {
+		t = L.AbsIndex(t)
+		L.RawGetI(t, freeList)
+		L.RawSetI(t, lua.Integer(ref)) /* t[ref] = t[freeList] */
+		L.PushInteger(lua.Integer(ref))
+		L.RawSetI(t, freeList) /* t[freeList] = ref */
+	}
+}
+
+/* }====================================================== */
+
+/*
+** {======================================================
+** Argument check functions
+** =======================================================
+ */
+
+/* Raise an error for argument 'arg' with message 'extraMsg' unless 'cond' holds */
+func (L *luaState) ArgCheck(cond bool, arg int, extraMsg string) {
+	if !cond {
+		L.ArgError(arg, extraMsg)
+	}
+}
+
+/**
+ * Check that argument 'arg' is a string and search for it in 'lst'.
+ * Returns the index where the string was found, raising an error if the
+ * argument is not a string or the string cannot be found. If 'def' is
+ * not empty, it is used as the default value when there is no argument
+ * 'arg' or when it is nil.
+ */
+func (L *luaState) CheckOption(arg int, def string, lst []string) int {
+	var name string
+	if def != "" {
+		name = L.OptString(arg, def)
+	} else {
+		name = L.CheckString(arg)
+	}
+	for i, option := range lst {
+		if option == name {
+			return i
+		}
+	}
+	return L.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
+func (L *luaState) CheckType(arg int, t lua.Type) {
+	if L.Type(arg) != t {
+		L.tagError(arg, t)
+	}
+}
+
+func (L *luaState) CheckAny(arg int) {
+	if L.Type(arg) == lua.TNONE {
+		L.ArgError(arg, "value expected")
+	}
+}
+
+func (L *luaState) CheckString(arg int) string {
+	s, ok := L.ToStringX(arg)
+	if !ok {
+		L.tagError(arg, lua.TSTRING)
+	}
+	return s
+}
+
+func (L *luaState) OptString(arg int, def string) string {
+	if L.IsNoneOrNil(arg) {
+		return def
+	}
+	return L.CheckString(arg)
+}
+
+func (L *luaState) CheckNumber(arg int) lua.Number {
+	n, ok := L.ToNumberX(arg)
+	if !ok {
+		L.tagError(arg, lua.TNUMBER)
+	}
+	return n
+}
+
+func (L *luaState) OptNumber(arg int, def lua.Number) lua.Number {
+	if L.IsNoneOrNil(arg) {
+		return def
+	}
+	return L.CheckNumber(arg)
+}
+
+func (L *luaState) intError(arg int) {
+	if L.IsNumber(arg) {
+		L.ArgError(arg, "number has no integer representation")
+	} else {
+		L.tagError(arg, lua.TNUMBER)
+	}
+}
+
+func (L *luaState) CheckInteger(arg int) lua.Integer {
+	n, ok := L.ToIntegerX(arg)
+	if !ok {
+		L.intError(arg)
+	}
+	return n
+}
+
+func (L *luaState) OptInteger(arg int, def lua.Integer) lua.Integer {
+	if L.IsNoneOrNil(arg) {
+		return def
+	}
+	return L.CheckInteger(arg)
+}
+
+/* }====================================================== */
+
+/*
+** {======================================================
+** Load functions
+** =======================================================
+ */
+
+type loadF struct {
+	z   *bufio.Reader
+	err error /* first read error, if any */
+}
+
+func (lf *loadF) Read(p []byte) (int, error) {
+	n, err := lf.z.Read(p)
+	if err != nil && err != io.EOF && lf.err == nil {
+		lf.err = err
+	}
+	return n, err
+}
+
+func (L *luaState) errFile(what, fileName string, err error) int {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	L.stackPush(fmt.Sprintf("cannot %s %s: %v", what, fileName, err))
+	return lua.ERRFILE
+}
+
+/* skip an optional BOM at the start of a stream */
+func skipBOM(z *bufio.Reader) {
+	if b, _ := z.Peek(3); string(b) == "\xEF\xBB\xBF" {
+		z.Discard(3)
+	}
+}
+
+/**
+ * skip first line of a chunk if it starts with '#' (Unix exec. file),
+ * returning whether there was a comment
+ */
+func skipComment(z *bufio.Reader) bool {
+	skipBOM(z)
+	if b, _ := z.Peek(1); len(b) == 0 || b[0] != '#' {
+		return false /* no comment */
+	}
+	for { /* skip first line */
+		c, err := z.ReadByte()
+		if err != nil || c == '\n' {
+			return true
+		}
+	}
+}
+
+/**
+ * Load a file as a Lua chunk. An empty 'fileName' loads from the
+ * standard input. Returns the status of 'Load', or ERRFILE for errors
+ * opening or reading the file.
+ */
+func (L *luaState) LoadFileX(fileName, mode string) int {
+	status, _ := L.loadFileX(fileName, mode)
+	return status
+}
+
+/**
+ * ProtectedLoadFile is like 'LoadFile', but reports failures as a
+ * *lua.LuaError, as 'ProtectedLoad' does.
+ */
+func (L *luaState) ProtectedLoadFile(fileName string) error {
+	return L.loadError(L.loadFileX(fileName, ""))
+}
+
+func (L *luaState) loadFileX(fileName, mode string) (int, error) {
+	var chunkName string
+	var f *os.File
+	if fileName == "" {
+		chunkName = "=stdin"
+		fileName = "stdin"
+		f = os.Stdin
+	} else {
+		chunkName = "@" + fileName
+		var err error
+		if f, err = os.Open(fileName); err != nil {
+			return L.errFile("open", fileName, err), err
+		}
+		defer f.Close()
+	}
+	lf := &loadF{z: bufio.NewReader(f)}
+	var r io.Reader = lf
+	if skipComment(lf.z) {
+		/* add line to correct line numbers (not for binary files) */
+		if b, _ := lf.z.Peek(1); len(b) == 0 || b[0] != lua.SIGNATURE[0] {
+			r = io.MultiReader(strings.NewReader("\n"), lf)
+		}
+	}
+	status, err := L.load(r, chunkName, mode)
+	if lf.err != nil {
+		L.Pop(1) /* remove the result of 'Load' */
+		return L.errFile("read", fileName, lf.err), lf.err
+	}
+	return status, err
+}
+
+func (L *luaState) LoadFile(fileName string) int {
+	return L.LoadFileX(fileName, "")
+}
+
+func (L *luaState) LoadString(s string) int {
+	return L.Load(strings.NewReader(s), s, "")
+}
+
+/* }====================================================== */
+
+func (L *luaState) GetMetafield(obj int, e string) lua.Type {
+	if !L.GetMetatable(obj) { /* no metatable? */
+		return lua.TNIL
+	}
+	L.PushString(e)
+	tt := L.RawGet(-2)
+	if tt == lua.TNIL { /* is metafield nil? */
+		L.Pop(2) /* remove metatable and metafield */
+	} else {
+		L.Remove(-2) /* remove only metatable */
+	}
+	return tt /* return metafield type */
+}
+
+func (L *luaState) CallMeta(obj int, e string) bool {
+	obj = L.AbsIndex(obj)
+	if L.GetMetafield(obj, e) == lua.TNIL { /* no metafield? */
+		return false
+	}
+	L.PushValue(obj)
+	L.Call(1, 1)
+	return true
+}
+
+/**
+ * Convert any value to a string in a reasonable format, honoring the
+ * '__tostring' and '__name' metafields, and push it on the stack.
+ */
+func (L *luaState) ToStringMeta(idx int) string {
+	if L.CallMeta(idx, "__tostring") { /* metafield? */
+		if !L.IsString(-1) {
+			L.ErrorF("'__tostring' must return a string")
+		}
+	} else {
+		switch L.Type(idx) {
+		case lua.TNUMBER, lua.TSTRING:
+			L.PushValue(idx)
+		case lua.TBOOLEAN:
+			L.PushString(fmt.Sprintf("%t", L.ToBoolean(idx)))
+		case lua.TNIL:
+			L.PushString("nil")
+		default:
+			tt := L.GetMetafield(idx, "__name") /* try name */
+			kind := L.TypeName(L.Type(idx))
+			if tt == lua.TSTRING {
+				kind = L.ToString(-1)
+			}
+			val, _ := L.stackGet(idx)
+			L.PushString(fmt.Sprintf("%s: %p", kind, val))
+			if tt != lua.TNIL {
+				L.Remove(-2) /* remove '__name' */
+			}
+		}
+	}
+	return L.ToString(-1)
+}
+
+/*
+** {======================================================
+** Compatibility with 5.1 module functions
+** =======================================================
+ */
+
+/**
+ * Ensure that stack[idx][fname] has a table and push that table into
+ * the stack. Returns true if the table already existed.
+ */
+func (L *luaState) GetSubTable(idx int, fname string) bool {
+	if L.GetField(idx, fname) == lua.TTABLE {
+		return true /* table already there */
+	}
+	L.Pop(1) /* remove previous result */
+	idx = L.AbsIndex(idx)
+	L.NewTable()
+	L.PushValue(-1)        /* copy to be left at top */
+	L.SetField(idx, fname) /* assign new table to field */
+	return false           /* false, because did not find table there */
+}
+
+/**
+ * Stripped-down 'require': After checking "loaded" table, calls 'openF'
+ * to open a module, registers the result in 'package.loaded' table and,
+ * if 'glb' is true, also registers the result in the global table.
+ * Leaves resulting module on the top.
+ */
+func (L *luaState) RequireF(modName string, openF lua.GoFunction, glb bool) {
+	L.GetSubTable(lua.REGISTRYINDEX, lua.LOADED_TABLE)
+	L.GetField(-1, modName) /* LOADED[modName] */
+	if !L.ToBoolean(-1) {   /* package not already loaded? */
+		L.Pop(1) /* remove field */
+		L.PushGoFunction(openF)
+		L.PushString(modName)   /* argument to open function */
+		L.Call(1, 1)            /* call 'openF' to open module */
+		L.PushValue(-1)         /* make copy of module (call result) */
+		L.SetField(-3, modName) /* LOADED[modName] = module */
+	}
+	L.Remove(-2) /* remove LOADED table */
+	if glb {
+		L.PushValue(-1)      /* copy of module */
+		L.SetGlobal(modName) /* _G[modName] = module */
+	}
+}
+
+func (L *luaState) NewLib(l lua.FuncReg) {
+	L.CreateTable(0, len(l))
+	L.SetFuncs(l, 0)
+}
+
+/**
+ * Set functions from list 'l' into table at top - 'nUp'; each function
+ * gets the 'nUp' elements at the top as upvalues.
+ */
+func (L *luaState) SetFuncs(l lua.FuncReg, nUp int) {
+	if !L.CheckStack(nUp) {
+		L.ErrorF("stack overflow (%s)", "too many upvalues")
+	}
+	for name, f := range l { /* fill the table with given functions */
+		for i := 0; i < nUp; i++ { /* copy upvalues to the top */
+			L.PushValue(-nUp)
+		}
+		L.PushGoClosure(f, nUp) /* closure with those upvalues */
+		L.SetField(-(nUp + 2), name)
+	}
+	L.Pop(nUp) /* remove upvalues */
+}
+
+/* }====================================================== */
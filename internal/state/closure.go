@@ -11,24 +11,25 @@ import (
 const MAXUPVAL = 255
 
 type upvalue struct {
-	level int
-	next  *upvalue /* linked list (when open) */
-	value luaValue /* the value (when closed) */
+	level  int
+	thread *luaState /* thread whose stack holds the value (when open) */
+	next   *upvalue  /* linked list (when open) */
+	value  luaValue  /* the value (when closed) */
 }
 
-func (uv *upvalue) get(L *luaState) luaValue {
+func (uv *upvalue) get() luaValue {
 	if uv.level < 0 {
 		return uv.value
 	} else {
-		return L.stack[uv.level]
+		return uv.thread.stack[uv.level]
 	}
 }
 
-func (uv *upvalue) set(L *luaState, val luaValue) {
+func (uv *upvalue) set(val luaValue) {
 	if uv.level < 0 {
 		uv.value = val
 	} else {
-		L.stack[uv.level] = val
+		uv.thread.stack[uv.level] = val
 	}
 }
 
@@ -68,8 +69,9 @@ func (L *luaState) findUpvalue(level int) *upvalue {
 	}
 	/* not found: create a new upvalue */
 	uv := &upvalue{
-		level: level, /* current value lives in the stack */
-		next:  *pp,   /* link it to list of open upvalues */
+		level:  level, /* current value lives in the stack */
+		thread: L,
+		next:   *pp, /* link it to list of open upvalues */
 	}
 	*pp = uv
 	return uv
@@ -78,9 +80,10 @@ func (L *luaState) findUpvalue(level int) *upvalue {
 func (L *luaState) closeUpvalues(level int) {
 	for L.openUpval != nil && L.openUpval.level >= level {
 		uv := L.openUpval
+		L.openUpval = uv.next /* remove from 'open' list */
 		uv.value = L.stack[uv.level]
 		uv.level = -1
+		uv.thread = nil
 		uv.next = nil
-		L.openUpval = uv.next
 	}
 }
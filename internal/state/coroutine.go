@@ -0,0 +1,236 @@
+package state
+
+import (
+	"github.com/uganh16/golua/internal/conf"
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/*
+** {======================================================
+** Resume and yield
+** =======================================================
+ */
+
+/**
+ * Signal an error in the call to 'Resume', not in the execution of the
+ * coroutine itself: the arguments are replaced by the error message.
+ */
+func (L *luaState) resumeError(msg string, nArgs int) int {
+	top := len(L.stack) - nArgs
+	for i := top; i < len(L.stack); i++ {
+		L.stack[i] = nil
+	}
+	L.stack = append(L.stack[:top], msg)
+	return lua.ERRRUN
+}
+
+/**
+ * Complete the execution of a Go function interrupted by a yield or by
+ * an error recovered by 'PCallK', by calling its continuation.
+ */
+func (L *luaState) finishCcall(status int) {
+	ci := L.ci
+	/* must have a continuation and must be able to call it */
+	if ci.k == nil || L.nny != 0 {
+		panic("cannot resume a Go function without a continuation")
+	}
+	if ci.callStatus&CIST_YPCALL != 0 { /* was inside a pcall? */
+		ci.callStatus &^= CIST_YPCALL /* continuation is also inside it */
+		L.errFunc = ci.oldErrFunc     /* with the same error function */
+	}
+	/* finish 'CallK'/'PCallK'; CIST_YPCALL and 'errFunc' already handled */
+	if ci.nResults == lua.MULTRET && ci.top < len(L.stack) {
+		ci.top = len(L.stack)
+	}
+	n := ci.k(L, status, ci.ctx) /* call continuation function */
+	L.stackCheck(n)
+	L.postCall(len(L.stack)-n, n) /* finish 'preCall' */
+}
+
+/**
+ * Do the work for 'Resume': start the coroutine body or continue it
+ * after a yield. The 'nArgs' values on the top of the stack are the
+ * arguments to the body or the results of the yield.
+ */
+func (L *luaState) resume(nArgs int) {
+	firstArg := len(L.stack) - nArgs /* first argument */
+	ci := L.ci
+	if L.status == lua.OK { /* starting a coroutine? */
+		if !L.preCall(L.stack[firstArg-1], nArgs, lua.MULTRET) { /* Lua function? */
+			L.execute() /* call it */
+		}
+	} else { /* resuming from previous yield */
+		L.status = lua.OK /* mark that it is running (again) */
+		ci.cl = ci.extra
+		if ci.callStatus&CIST_LUA != 0 { /* yielded inside a hook? */
+			L.execute() /* just continue running Lua code */
+		} else { /* 'common' yield */
+			if ci.k != nil { /* does it have a continuation function? */
+				nArgs = ci.k(L, lua.YIELD, ci.ctx) /* call continuation */
+				L.stackCheck(nArgs)
+				firstArg = len(L.stack) - nArgs /* yield results come from continuation */
+			}
+			L.postCall(firstArg, nArgs) /* finish 'preCall' */
+		}
+		L.unroll()
+	}
+}
+
+/**
+ * Execute the rest of the call chain of a suspended coroutine, from the
+ * innermost function down to the coroutine body.
+ */
+func (L *luaState) unroll() {
+	for L.ci != &L.baseCI { /* something in the stack */
+		if L.ci.callStatus&CIST_LUA == 0 { /* Go function? */
+			L.finishCcall(lua.YIELD) /* complete its execution */
+		} else { /* Lua function */
+			L.finishOp() /* finish interrupted instruction */
+			L.execute()  /* execute down to higher Go 'boundary' */
+		}
+	}
+}
+
+/* Find the innermost 'PCallK' that can recover from an error in a coroutine. */
+func (L *luaState) findPCall() *callInfo {
+	for ci := L.ci; ci != nil; ci = ci.prev { /* search for a pcall */
+		if ci.callStatus&CIST_YPCALL != 0 {
+			return ci
+		}
+	}
+	return nil /* no pending pcall */
+}
+
+/**
+ * Recover from an error in a coroutine: "finish" the pending 'PCallK',
+ * as 'pCall' would, so that the coroutine can go on running. Returns
+ * false if there is no recovery point.
+ */
+func (L *luaState) recover(status int) bool {
+	ci := L.findPCall()
+	if ci == nil {
+		return false /* no recovery point */
+	}
+	/* "finish" pCall */
+	oldTop := ci.extra
+	L.closeUpvalues(oldTop)
+	L.setErrorObj(status, oldTop)
+	L.ci = ci
+	L.allowHook = ci.callStatus&CIST_OAH != 0 /* restore original 'allowHook' */
+	L.nny = 0                                 /* should be zero to be yieldable */
+	L.shrinkStack()
+	L.errFunc = ci.oldErrFunc
+	return true /* continue running the coroutine */
+}
+
+/**
+ * Resume starts or continues the coroutine 'L'. To start it, push the
+ * main function and its arguments onto the stack of 'L'; to continue
+ * it, push the values to be returned by the yield. Returns lua.YIELD if
+ * the coroutine yields, lua.OK if it finishes without errors, or an
+ * error status, in which case the coroutine is dead and the error
+ * object is on the top of its stack. 'from' is the thread doing the
+ * resume, or nil.
+ */
+func (L *luaState) Resume(from lua.State, nArgs int) int {
+	if L.status == lua.OK { /* may be starting a coroutine */
+		if L.ci != &L.baseCI { /* not in base level? */
+			return L.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+	} else if L.status != lua.YIELD {
+		return L.resumeError("cannot resume dead coroutine", nArgs)
+	}
+	if from, ok := from.(*luaState); ok {
+		L.nCcalls = from.nCcalls + 1
+	} else {
+		L.nCcalls = 1
+	}
+	if L.nCcalls >= conf.LUAI_MAXCCALLS {
+		return L.resumeError("C stack overflow", nArgs)
+	}
+	oldNny := L.nny /* save "number of non-yieldable" calls */
+	L.nny = 0       /* allow yields */
+	if L.status == lua.OK {
+		L.stackCheck(nArgs + 1)
+	} else {
+		L.stackCheck(nArgs)
+	}
+	status := L.rawRunProtected(func() { L.resume(nArgs) })
+	/* continue running after recoverable errors */
+	for status != lua.OK && status != lua.YIELD && L.recover(status) {
+		/* unroll continuation */
+		errStatus := status
+		status = L.rawRunProtected(func() {
+			L.finishCcall(errStatus) /* finish 'PCallK' callee */
+			L.unroll()
+		})
+	}
+	if status != lua.OK && status != lua.YIELD { /* unrecoverable error? */
+		L.status = uint8(status) /* mark thread as 'dead' */
+		L.setErrorObj(status, len(L.stack)-1)
+		L.ci.top = len(L.stack)
+	}
+	L.nny = oldNny /* restore 'nny' */
+	L.nCcalls--
+	return status
+}
+
+/**
+ * YieldK suspends the running coroutine, which is resumed with the
+ * 'nResults' values on the top of the stack as the results of
+ * 'Resume'. It must be used as the return expression of a Go function:
+ *
+ *	return L.YieldK(n, ctx, k)
+ *
+ * When the coroutine is resumed again, it calls the continuation 'k'
+ * (with status lua.YIELD and context 'ctx') to go on with the execution
+ * of the Go function that yielded. Without a continuation, the results
+ * of the resume are returned to the caller of that function.
+ */
+func (L *luaState) YieldK(nResults int, ctx lua.KContext, k lua.KFunction) int {
+	ci := L.ci
+	L.stackCheck(nResults)
+	if L.nny > 0 {
+		if L != L.lG.mainThread {
+			panic(runtimeError("attempt to yield across a C-call boundary"))
+		} else {
+			panic(runtimeError("attempt to yield from outside a coroutine"))
+		}
+	}
+	L.status = lua.YIELD
+	ci.extra = ci.cl /* save current 'func' */
+
+	if ci.callStatus&CIST_LUA != 0 { /* inside a hook? */
+		if k != nil {
+			panic("hooks cannot continue after yielding")
+		}
+	} else {
+		ci.k = k /* is there a continuation? */
+		if k != nil {
+			ci.ctx = ctx /* save context */
+		}
+		ci.cl = len(L.stack) - nResults - 1 /* protect stack below results */
+		L.throw(lua.YIELD)
+	}
+	return 0 /* return to the hook */
+}
+
+func (L *luaState) Yield(nResults int) int {
+	return L.YieldK(nResults, nil, nil)
+}
+
+/* IsYieldable reports whether the running coroutine can yield. */
+func (L *luaState) IsYieldable() bool {
+	return L.nny == 0
+}
+
+/**
+ * Status returns the status of the thread: lua.OK for a normal thread,
+ * lua.YIELD for a suspended coroutine, or the error status that killed
+ * it.
+ */
+func (L *luaState) Status() int {
+	return int(L.status)
+}
+
+/* }====================================================== */
@@ -2,33 +2,114 @@ package state
 
 import (
 	"fmt"
+	"strings"
+
+	"github.com/uganh16/golua/internal/binary"
+	"github.com/uganh16/golua/internal/bytecode"
+	"github.com/uganh16/golua/internal/lexer"
+	"github.com/uganh16/golua/pkg/lua"
 )
 
+/* Returns the line of the instruction at 'pc' in 'p', or -1 if unknown */
+func getFuncLine(p *binary.Proto, pc int) int {
+	if pc < 0 || pc >= len(p.LineInfo) { /* no debug information? */
+		return -1
+	}
+	return int(p.LineInfo[pc])
+}
+
+/* Returns the line being run by the Lua function of 'ci', or -1 if unknown */
+func (L *luaState) currentLine(ci *callInfo) int {
+	return getFuncLine(L.stack[ci.cl].(*lClosure).proto, ci.pc-1)
+}
+
+/* Returns the printable name of the chunk that defines 'p' */
+func shortSrc(p *binary.Proto) string {
+	if p.Source == "" { /* stripped debug information? */
+		return "?"
+	}
+	return lexer.ChunkID(p.Source)
+}
+
 type runtimeError string
 
-func typeError(val luaValue, op string) runtimeError {
-	return runtimeError(fmt.Sprintf("attempt to %s a %s value%s", op, typeName(val), "")) // @todo varinfo
+/**
+ * Build a string with a "description" for the value 'val', such as
+ * "local 'x'" or "upvalue 'y'". Values are not addressable here, so
+ * the operands of the instruction being run are searched for 'val'
+ * instead.
+ */
+func (L *luaState) varInfo(val luaValue) string {
+	ci := L.ci
+	if ci.callStatus&CIST_LUA == 0 {
+		return ""
+	}
+	cl := L.stack[ci.cl].(*lClosure)
+	p := cl.proto
+	pc := ci.pc - 1 /* current pc */
+	i := p.Code[pc]
+	a, b, c := i.ABC()
+	var regs []int
+	switch op := i.Opcode(); op {
+	case bytecode.OP_GETTABUP, bytecode.OP_SETTABUP: /* check whether 'val' is an upvalue */
+		uv := b
+		if op == bytecode.OP_SETTABUP {
+			uv = a
+		}
+		if _eq(nil, cl.upvals[uv].get(), val) {
+			return fmt.Sprintf(" (upvalue '%s')", upvalName(p, uv))
+		}
+	case bytecode.OP_GETTABLE, bytecode.OP_SELF, bytecode.OP_UNM, bytecode.OP_BNOT, bytecode.OP_LEN:
+		regs = []int{b}
+	case bytecode.OP_SETTABLE, bytecode.OP_CALL, bytecode.OP_TAILCALL:
+		regs = []int{a}
+	case bytecode.OP_ADD, bytecode.OP_SUB, bytecode.OP_MUL, bytecode.OP_MOD,
+		bytecode.OP_POW, bytecode.OP_DIV, bytecode.OP_IDIV, bytecode.OP_BAND,
+		bytecode.OP_BOR, bytecode.OP_BXOR, bytecode.OP_SHL, bytecode.OP_SHR:
+		for _, rk := range []int{b, c} {
+			if !bytecode.ISK(rk) { /* constants are not in the stack */
+				regs = append(regs, rk)
+			}
+		}
+	case bytecode.OP_CONCAT:
+		for reg := b; reg <= c; reg++ {
+			regs = append(regs, reg)
+		}
+	}
+	for _, reg := range regs { /* try a register */
+		if _eq(nil, L.stack[ci.base+reg], val) {
+			if name, kind := getObjName(p, pc, reg); kind != "" {
+				return fmt.Sprintf(" (%s '%s')", kind, name)
+			}
+			break
+		}
+	}
+	return ""
 }
 
-func concatError(val1, val2 luaValue) runtimeError {
+func (L *luaState) typeError(val luaValue, op string) runtimeError {
+	return runtimeError(fmt.Sprintf("attempt to %s a %s value%s", op, typeName(val), L.varInfo(val)))
+}
+
+func (L *luaState) concatError(val1, val2 luaValue) runtimeError {
 	if _, ok := toString(val1); ok {
 		val1 = val2
 	}
-	return typeError(val1, "concatenate")
+	return L.typeError(val1, "concatenate")
 }
 
-func opIntError(val1, val2 luaValue, msg string) runtimeError {
+func (L *luaState) opIntError(val1, val2 luaValue, msg string) runtimeError {
 	if _, ok := toNumber(val1); !ok {
 		val2 = val1
 	}
-	return typeError(val2, msg)
+	return L.typeError(val2, msg)
 }
 
-func toIntError(val1, val2 luaValue) runtimeError {
+func (L *luaState) toIntError(val1, val2 luaValue) runtimeError {
 	if _, ok := toInteger(val1); !ok {
 		val2 = val1
 	}
-	return runtimeError(fmt.Sprintf("number%s has no integer representation", "")) // @todo varinfo
+	return runtimeError(fmt.Sprintf("number%s has no integer representation", L.varInfo(val2)))
 }
 
 func orderError(val1, val2 luaValue) runtimeError {
@@ -40,3 +121,470 @@ func orderError(val1, val2 luaValue) runtimeError {
 		return runtimeError(fmt.Sprintf("attempt to compare %s with %s", t1, t2))
 	}
 }
+
+/* add src:line information to 'msg' */
+func (L *luaState) addInfo(msg string, ci *callInfo) string {
+	p := L.stack[ci.cl].(*lClosure).proto
+	if line := L.currentLine(ci); line >= 0 {
+		return fmt.Sprintf("%s:%d: %s", shortSrc(p), line, msg)
+	}
+	return fmt.Sprintf("%s:?: %s", shortSrc(p), msg) /* no debug information */
+}
+
+/*
+** {======================================================================
+** Debug API
+** =======================================================================
+ */
+
+/* active function of a thread, as kept in 'lua.Debug.CallInfo' */
+type activeFunc struct {
+	L  *luaState /* thread whose stack holds the function */
+	ci *callInfo
+}
+
+/**
+ * SetHook sets the debug hook 'f', called on the events selected by
+ * 'mask' (a combination of lua.MASKCALL, lua.MASKRET, lua.MASKLINE and
+ * lua.MASKCOUNT); with lua.MASKCOUNT, the hook is called every 'count'
+ * instructions. A nil 'f' or a zero 'mask' turns hooks off.
+ */
+func (L *luaState) SetHook(f lua.Hook, mask, count int) {
+	if f == nil || mask == 0 { /* turn off hooks? */
+		mask = 0
+		f = nil
+	}
+	if L.ci.callStatus&CIST_LUA != 0 {
+		L.oldPC = L.ci.pc
+	}
+	L.hook = f
+	L.baseHookCount = count
+	L.hookCount = L.baseHookCount
+	L.hookMask = uint8(mask)
+}
+
+func (L *luaState) GetHook() lua.Hook {
+	return L.hook
+}
+
+func (L *luaState) GetHookMask() int {
+	return int(L.hookMask)
+}
+
+func (L *luaState) GetHookCount() int {
+	return L.baseHookCount
+}
+
+func (L *luaState) GetStack(level int, ar *lua.Debug) bool {
+	if level < 0 {
+		return false /* invalid (negative) level */
+	}
+	ci := L.ci
+	for ; level > 0 && ci != &L.baseCI; ci = ci.prev {
+		level--
+	}
+	if level == 0 && ci != &L.baseCI { /* level found? */
+		ar.CallInfo = &activeFunc{L, ci}
+		return true
+	}
+	return false /* no such level */
+}
+
+func (L *luaState) GetInfo(what string, ar *lua.Debug) bool {
+	L1 := L /* thread running the function */
+	var ci *callInfo
+	var f luaValue
+	if strings.HasPrefix(what, ">") {
+		f = L.stackPop()
+		if typeOf(f) != lua.TFUNCTION {
+			panic("function expected")
+		}
+		what = what[1:] /* skip the '>' */
+	} else {
+		act := ar.CallInfo.(*activeFunc)
+		L1, ci = act.L, act.ci
+		f = L1.stack[ci.cl]
+	}
+	status := L1.auxGetInfo(what, ar, f, ci)
+	if strings.ContainsRune(what, 'f') {
+		L.stackPush(f)
+	}
+	if strings.ContainsRune(what, 'L') {
+		L.collectValidLines(f)
+	}
+	return status
+}
+
+func (L *luaState) auxGetInfo(what string, ar *lua.Debug, f luaValue, ci *callInfo) bool {
+	status := true
+	cl, _ := f.(*lClosure) /* nil for Go functions */
+	for _, c := range what {
+		switch c {
+		case 'S':
+			funcInfo(ar, cl)
+		case 'l':
+			if ci != nil && ci.callStatus&CIST_LUA != 0 {
+				ar.CurrentLine = L.currentLine(ci)
+			} else {
+				ar.CurrentLine = -1
+			}
+		case 'u':
+			if cl == nil {
+				ar.NUps = 0
+				if gcl, ok := f.(*gClosure); ok {
+					ar.NUps = len(gcl.upvalue)
+				}
+				ar.IsVararg = true
+				ar.NParams = 0
+			} else {
+				ar.NUps = len(cl.upvals)
+				ar.IsVararg = cl.proto.IsVararg
+				ar.NParams = int(cl.proto.NumParams)
+			}
+		case 't':
+			ar.IsTailCall = ci != nil && ci.callStatus&CIST_TAIL != 0
+		case 'n':
+			ar.Name, ar.NameWhat = L.getFuncName(ci)
+		case 'L', 'f': /* handled by GetInfo */
+		default:
+			status = false /* invalid option */
+		}
+	}
+	return status
+}
+
+func funcInfo(ar *lua.Debug, cl *lClosure) {
+	if cl == nil {
+		ar.Source = "=[Go]"
+		ar.LineDefined = -1
+		ar.LastLineDefined = -1
+		ar.What = "Go"
+	} else {
+		p := cl.proto
+		if p.Source != "" {
+			ar.Source = p.Source
+		} else {
+			ar.Source = "=?"
+		}
+		ar.LineDefined = int(p.LineDefined)
+		ar.LastLineDefined = int(p.LastLineDefined)
+		if ar.LineDefined == 0 {
+			ar.What = "main"
+		} else {
+			ar.What = "Lua"
+		}
+	}
+	ar.ShortSrc = lexer.ChunkID(ar.Source)
+}
+
+/* push a table whose keys are the lines with code of function 'f' */
+func (L *luaState) collectValidLines(f luaValue) {
+	cl, ok := f.(*lClosure)
+	if !ok {
+		L.stackPush(nil)
+		return
+	}
+	t := newLuaTable(0, 0)
+	for _, line := range cl.proto.LineInfo {
+		t.set(lua.Integer(line), true)
+	}
+	L.stackPush(t)
+}
+
+/* Returns the name and kind of the function running in 'ci', if known */
+func (L *luaState) getFuncName(ci *callInfo) (name, nameWhat string) {
+	if ci != nil && ci.callStatus&CIST_TAIL == 0 && /* not a tail call? */
+		ci.prev.callStatus&CIST_LUA != 0 { /* calling function is a known Lua function? */
+		return L.funcNameFromCode(ci.prev)
+	}
+	return "", "" /* no way to determine the name */
+}
+
+/**
+ * Try to find a name for a function based on the code that called it.
+ * (Only works when function was called by a Lua function.)
+ */
+func (L *luaState) funcNameFromCode(ci *callInfo) (name, nameWhat string) {
+	p := L.stack[ci.cl].(*lClosure).proto
+	pc := ci.pc - 1 /* current pc */
+	i := p.Code[pc]
+	if ci.callStatus&CIST_HOOKED != 0 { /* was it called inside a hook? */
+		return "?", "hook"
+	}
+	switch op := i.Opcode(); op {
+	case bytecode.OP_CALL, bytecode.OP_TAILCALL:
+		a, _, _ := i.ABC()
+		return getObjName(p, pc, a) /* get function name */
+	case bytecode.OP_TFORCALL: /* for iterator */
+		return "for iterator", "for iterator"
+	/* other instructions can do calls through metamethods */
+	case bytecode.OP_SELF, bytecode.OP_GETTABUP, bytecode.OP_GETTABLE:
+		name = "index"
+	case bytecode.OP_SETTABUP, bytecode.OP_SETTABLE:
+		name = "newindex"
+	case bytecode.OP_ADD, bytecode.OP_SUB, bytecode.OP_MUL, bytecode.OP_MOD,
+		bytecode.OP_POW, bytecode.OP_DIV, bytecode.OP_IDIV, bytecode.OP_BAND,
+		bytecode.OP_BOR, bytecode.OP_BXOR, bytecode.OP_SHL, bytecode.OP_SHR:
+		name = arithEvents[op-bytecode.OP_ADD][2:] /* skip the '__' */
+	case bytecode.OP_UNM:
+		name = "unm"
+	case bytecode.OP_BNOT:
+		name = "bnot"
+	case bytecode.OP_LEN:
+		name = "len"
+	case bytecode.OP_CONCAT:
+		name = "concat"
+	case bytecode.OP_EQ:
+		name = "eq"
+	case bytecode.OP_LT:
+		name = "lt"
+	case bytecode.OP_LE:
+		name = "le"
+	default:
+		return "", "" /* cannot find a reasonable name */
+	}
+	return name, "metamethod"
+}
+
+/* events of the arithmetic opcodes, from OP_ADD to OP_SHR */
+var arithEvents = [...]string{
+	"__add", "__sub", "__mul", "__mod", "__pow", "__div", "__idiv",
+	"__band", "__bor", "__bxor", "__shl", "__shr",
+}
+
+/* }====================================================================== */
+
+/*
+** {======================================================================
+** Symbolic Execution
+** =======================================================================
+ */
+
+func upvalName(p *binary.Proto, uv int) string {
+	if uv >= len(p.UpvalueNames) || p.UpvalueNames[uv] == "" {
+		return "?"
+	}
+	return p.UpvalueNames[uv]
+}
+
+/**
+ * Look for n-th local variable at line 'line' in function 'p'.
+ * Returns "" if not found.
+ */
+func localName(p *binary.Proto, n, pc int) string {
+	for _, locVar := range p.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { /* is variable active? */
+			n--
+			if n == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return "" /* not found */
+}
+
+/* Find a "name" for the RK value 'c' */
+func kName(p *binary.Proto, pc, c int) string {
+	if bytecode.ISK(c) { /* is 'c' a constant? */
+		if name, ok := p.Constants[c&^bytecode.BITRK].(string); ok { /* literal constant? */
+			return name /* it is its own name */
+		}
+		/* else no reasonable name found */
+	} else { /* 'c' is a register */
+		if name, kind := getObjName(p, pc, c); kind == "constant" { /* found a constant name? */
+			return name /* 'name' already filled */
+		}
+		/* else no reasonable name found */
+	}
+	return "?" /* no reasonable name found */
+}
+
+func filterPC(pc, jmpTarget int) int {
+	if pc < jmpTarget { /* is code conditional (inside a jump)? */
+		return -1 /* cannot know who sets that register */
+	}
+	return pc /* current position sets that register */
+}
+
+/* Try to find last instruction before 'lastpc' that modified register 'reg' */
+func findSetReg(p *binary.Proto, lastpc, reg int) int {
+	setReg := -1   /* keep last instruction that changed 'reg' */
+	jmpTarget := 0 /* any code before this address is conditional */
+	for pc := 0; pc < lastpc; pc++ {
+		i := p.Code[pc]
+		a, b, _ := i.ABC()
+		switch i.Opcode() {
+		case bytecode.OP_LOADNIL:
+			if a <= reg && reg <= a+b { /* set registers from 'a' to 'a+b' */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case bytecode.OP_TFORCALL:
+			if reg >= a+2 { /* affect all regs above its base */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case bytecode.OP_CALL, bytecode.OP_TAILCALL:
+			if reg >= a { /* affect all registers above base */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case bytecode.OP_JMP:
+			_, sbx := i.AsBx()
+			dest := pc + 1 + sbx
+			/* jump is forward and do not skip 'lastpc'? */
+			if pc < dest && dest <= lastpc && dest > jmpTarget {
+				jmpTarget = dest /* update 'jmpTarget' */
+			}
+		default:
+			if i.TestAMode() && reg == a { /* any instruction that set A */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		}
+	}
+	return setReg
+}
+
+/**
+ * Find a "name" for the register 'reg' at instruction 'lastpc' of 'p'
+ * and its kind ('global', 'local', 'method', ...).
+ */
+func getObjName(p *binary.Proto, lastpc, reg int) (name, kind string) {
+	if name = localName(p, reg+1, lastpc); name != "" { /* is a local? */
+		return name, "local"
+	}
+	/* else try symbolic execution */
+	pc := findSetReg(p, lastpc, reg)
+	if pc == -1 { /* could not find instruction? */
+		return "", ""
+	}
+	i := p.Code[pc]
+	a, b, c := i.ABC()
+	switch op := i.Opcode(); op {
+	case bytecode.OP_MOVE:
+		if b < a { /* move from 'b' to 'a' */
+			return getObjName(p, pc, b) /* get name for 'b' */
+		}
+	case bytecode.OP_GETTABUP, bytecode.OP_GETTABLE:
+		var vn string /* name of indexed variable */
+		if op == bytecode.OP_GETTABLE {
+			vn = localName(p, b+1, pc)
+		} else {
+			vn = upvalName(p, b)
+		}
+		if vn == "_ENV" {
+			return kName(p, pc, c), "global"
+		}
+		return kName(p, pc, c), "field"
+	case bytecode.OP_GETUPVAL:
+		return upvalName(p, b), "upvalue"
+	case bytecode.OP_LOADK, bytecode.OP_LOADKX:
+		_, bx := i.ABx()
+		if op == bytecode.OP_LOADKX {
+			bx = p.Code[pc+1].Ax()
+		}
+		if s, ok := p.Constants[bx].(string); ok {
+			return s, "constant"
+		}
+	case bytecode.OP_SELF:
+		return kName(p, pc, c), "method"
+	}
+	return "", "" /* could not find reasonable name */
+}
+
+/* }====================================================================== */
+
+/*
+** {======================================================================
+** Hooks
+** =======================================================================
+ */
+
+/**
+ * Call the hook for 'event' ('line' is the new line for line events).
+ * Hooks are not called while running a hook, and the stack is restored
+ * afterwards, so the hook cannot disturb the function being run.
+ */
+func (L *luaState) runHook(event, line int) {
+	hook := L.hook
+	if hook != nil && L.allowHook { /* make sure there is a hook */
+		ci := L.ci
+		top := len(L.stack)
+		ciTop := ci.top
+		ar := &lua.Debug{Event: event, CurrentLine: line, CallInfo: &activeFunc{L, ci}}
+		if L.stackLast-top < lua.MINSTACK { /* ensure minimum stack size */
+			L.stackGrow(lua.MINSTACK)
+		}
+		ci.top = top + lua.MINSTACK
+		L.allowHook = false /* cannot call hooks inside a hook */
+		ci.callStatus |= CIST_HOOKED
+		hook(L, ar)
+		L.allowHook = true
+		ci.top = ciTop
+		for i := top; i < len(L.stack); i++ {
+			L.stack[i] = nil
+		}
+		L.stack = L.stack[:top]
+		ci.callStatus &^= CIST_HOOKED
+	}
+}
+
+/* Call the call hook for the Lua function just started in 'ci' */
+func (L *luaState) callHook(ci *callInfo) {
+	event := lua.HOOKCALL
+	ci.pc++ /* hooks assume 'pc' is already incremented */
+	if prev := ci.prev; prev.callStatus&CIST_LUA != 0 &&
+		L.stack[prev.cl].(*lClosure).proto.Code[prev.pc-1].Opcode() == bytecode.OP_TAILCALL {
+		ci.callStatus |= CIST_TAIL
+		event = lua.HOOKTAILCALL
+	}
+	L.runHook(event, -1)
+	ci.pc-- /* correct 'pc' */
+}
+
+/**
+ * Call the count and line hooks, as needed, before the instruction just
+ * fetched by 'execute'. A line event happens when entering a function,
+ * when jumping back (in a loop) and when entering a new line.
+ */
+func (L *luaState) traceExec() {
+	ci := L.ci
+	mask := L.hookMask
+	L.hookCount--
+	countHook := L.hookCount == 0 && mask&lua.MASKCOUNT != 0
+	if countHook {
+		L.hookCount = L.baseHookCount /* reset count */
+	} else if mask&lua.MASKLINE == 0 {
+		return /* no line hook and count != 0; nothing to be done */
+	}
+	if ci.callStatus&CIST_HOOKYIELD != 0 { /* called hook last time? */
+		/* do not call hook again (VM yielded, so it did not move) */
+		ci.callStatus &^= CIST_HOOKYIELD /* erase mark */
+		return
+	}
+	if countHook {
+		L.runHook(lua.HOOKCOUNT, -1) /* call count hook */
+	}
+	if mask&lua.MASKLINE != 0 {
+		p := L.stack[ci.cl].(*lClosure).proto
+		npc := ci.pc - 1
+		newLine := getFuncLine(p, npc)
+		if npc == 0 || /* call line hook when enter a new function, */
+			ci.pc <= L.oldPC || /* when jump back (loop), or when */
+			newLine != getFuncLine(p, L.oldPC-1) { /* enter a new line */
+			L.runHook(lua.HOOKLINE, newLine) /* call line hook */
+		}
+	}
+	L.oldPC = ci.pc
+	if L.status == lua.YIELD { /* did hook yield? */
+		if countHook {
+			L.hookCount = 1 /* undo decrement to zero */
+		}
+		ci.pc--                         /* undo increment (resume will increment it again) */
+		ci.callStatus |= CIST_HOOKYIELD /* mark that it yielded */
+		ci.cl = len(L.stack) - 1        /* protect stack below results */
+		L.throw(lua.YIELD)
+	}
+}
+
+/* }====================================================================== */
@@ -39,11 +39,7 @@ func (L *luaState) stackGet(idx int) (luaValue, bool) {
 		if idx > MAXUPVAL+1 {
 			panic("upvalue index too large")
 		}
-		f := L.stack[ci.cl]
-		if _, ok := f.(*lua.GoFunction); ok {
-			return nil, false
-		}
-		cl := f.(*gClosure)
+		cl := L.stack[ci.cl].(*gClosure)
 		if idx <= len(cl.upvalue) {
 			return cl.upvalue[idx-1], true
 		} else {
@@ -93,7 +89,7 @@ func (L *luaState) stackSet(idx int, val luaValue) {
 func (L *luaState) stackGrow(n int) {
 	size := cap(L.stack)
 	if size > conf.LUAI_MAXSTACK { /* error after extra size? */
-		// @todo luaD_throw(L, LUA_ERRERR);
+		L.throw(lua.ERRERR)
 	}
 	needed := len(L.stack) + n + EXTRA_STACK
 	newSize := 2 * size
@@ -105,13 +101,41 @@ func (L *luaState) stackGrow(n int) {
 	}
 	if newSize > conf.LUAI_MAXSTACK { /* stack overflow? */
 		/* some space for error handling */
-		L.stackRealloc(conf.LUAI_MAXSTACK + 200)
+		L.stackRealloc(ERRORSTACKSIZE)
 		panic(runtimeError("stack overflow"))
 	} else {
 		L.stackRealloc(newSize)
 	}
 }
 
+/* part of the stack in use, up to the highest 'top' of any active call */
+func (L *luaState) stackInUse() int {
+	lim := len(L.stack)
+	for ci := L.ci; ci != nil; ci = ci.prev {
+		if lim < ci.top {
+			lim = ci.top
+		}
+	}
+	return lim + 1
+}
+
+/**
+ * Shrink the stack after an error, returning the extra space used to
+ * handle a stack overflow, so that a later overflow is reported again
+ * as a regular error.
+ */
+func (L *luaState) shrinkStack() {
+	inUse := L.stackInUse()
+	goodSize := inUse + inUse/8 + 2*EXTRA_STACK
+	if goodSize > conf.LUAI_MAXSTACK {
+		goodSize = conf.LUAI_MAXSTACK /* respect stack limit */
+	}
+	/* shrink, unless still handling a stack overflow */
+	if inUse <= conf.LUAI_MAXSTACK-EXTRA_STACK && goodSize < cap(L.stack) {
+		L.stackRealloc(goodSize)
+	}
+}
+
 func (L *luaState) stackRealloc(newSize int) {
 	newStack := make([]luaValue, len(L.stack), newSize)
 	copy(newStack, L.stack)
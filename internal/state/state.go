@@ -1,14 +1,24 @@
 package state
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"unsafe"
 
 	"github.com/uganh16/golua/internal/binary"
+	"github.com/uganh16/golua/internal/codegen"
 	"github.com/uganh16/golua/internal/conf"
+	"github.com/uganh16/golua/internal/number"
 	"github.com/uganh16/golua/pkg/lua"
+	"github.com/uganh16/golua/pkg/parser"
 )
 
+/* message for memory allocation errors */
+const MEMERRMSG = "not enough memory"
+
 /* limit for table tag-method chains (to avoid loops) */
 const MAXTAGLOOP = 2000
 
@@ -22,6 +32,9 @@ const EXTRA_STACK = 5
 
 const BASIC_STACK_SIZE = 2 * lua.MINSTACK
 
+/* size of the stack while handling a stack overflow */
+const ERRORSTACKSIZE = conf.LUAI_MAXSTACK + 200
+
 type callInfo struct {
 	cl   int       /* function index in the stack */
 	top  int       /* top for this function */
@@ -31,6 +44,12 @@ type callInfo struct {
 	base int /* base for this function */
 	pc   int
 
+	/* only for Go functions */
+	k          lua.KFunction /* continuation in case of yields */
+	ctx        lua.KContext  /* context info. in case of yields */
+	oldErrFunc int
+
+	extra      int   /* saved function index while suspended or in a 'PCallK' */
 	nResults   int16 /* expected number of results from this function */
 	callStatus uint16
 }
@@ -51,22 +70,36 @@ const (
 )
 
 type global_State struct {
-	lRegistry luaValue
-	mt        [lua.NUMTAGS]*luaTable
+	lRegistry  luaValue
+	mainThread *luaState
+	mt         [lua.NUMTAGS]*luaTable
 }
 
 type luaState struct {
+	status    uint8
 	stack     []luaValue
 	stackLast int      /* last free slot in the stack */
 	openUpval *upvalue /* list of open upvalues in this stack */
+	errFunc   int      /* current error handling function (stack index) */
 	baseCI    callInfo
 	ci        *callInfo
 	lG        *global_State
+	nny       uint16 /* number of non-yieldable calls in stack */
+	nCcalls   uint16 /* number of nested Go calls */
+
+	hook          lua.Hook
+	oldPC         int /* last pc traced */
+	baseHookCount int
+	hookCount     int
+	hookMask      uint8
+	allowHook     bool
 }
 
-func New() *luaState {
+/* Create a thread with an empty stack, sharing the global state 'g'. */
+func newThread(g *global_State) *luaState {
 	L := &luaState{
-		stack:     make([]luaValue, 1, BASIC_STACK_SIZE),
+		status:    lua.OK,
+		stack:     make([]luaValue, 1, BASIC_STACK_SIZE), /* entry for the function of 'baseCI' */
 		stackLast: BASIC_STACK_SIZE - EXTRA_STACK,
 		baseCI: callInfo{
 			cl:         0,
@@ -74,9 +107,17 @@ func New() *luaState {
 			prev:       nil,
 			callStatus: 0,
 		},
-		lG: &global_State{},
+		lG:        g,
+		nny:       1, /* threads are non-yieldable unless resumed */
+		allowHook: true,
 	}
 	L.ci = &L.baseCI
+	return L
+}
+
+func New() *luaState {
+	L := newThread(&global_State{})
+	L.lG.mainThread = L
 
 	/* init_registry: Create registry table and its predefined values */
 	registry := newLuaTable(lua.RIDX_LAST, 0)
@@ -89,6 +130,21 @@ func New() *luaState {
 	return L
 }
 
+/**
+ * NewThread creates a new thread, pushes it on the stack and returns it.
+ * The new thread shares the global environment of 'L', but has an
+ * independent execution stack.
+ */
+func (L *luaState) NewThread() lua.State {
+	L1 := newThread(L.lG)
+	L.stackPush(L1)
+	L1.hookMask = L.hookMask
+	L1.baseHookCount = L.baseHookCount
+	L1.hook = L.hook
+	L1.hookCount = L1.baseHookCount
+	return L1
+}
+
 func (L *luaState) AbsIndex(idx int) int {
 	if idx > 0 || isPseudo(idx) {
 		return idx
@@ -140,15 +196,15 @@ func (L *luaState) Rotate(idx, n int) {
 	} else {
 		panic("index not in the stack")
 	}
+	if (n >= 0 && n > t-p+1) || (n < 0 && -n > t-p+1) {
+		panic("invalid 'n'")
+	}
 	var m int // end of prefix
 	if n >= 0 {
 		m = t - n
 	} else {
 		m = p - n - 1
 	}
-	if m < p || m > t {
-		panic("invalid 'n'")
-	}
 	L.stackReverse(p, m)   // reverse the prefix with length 'n'
 	L.stackReverse(m+1, t) // reverse the suffix
 	L.stackReverse(p, t)   // reverse the entire segment
@@ -180,6 +236,27 @@ func (L *luaState) CheckStack(n int) bool {
 	return res
 }
 
+/* XMove pops 'n' values from the stack of 'L' and pushes them onto 'to'. */
+func (L *luaState) XMove(to lua.State, n int) {
+	L1 := to.(*luaState)
+	if L == L1 {
+		return
+	}
+	L.stackCheck(n)
+	if L.lG != L1.lG {
+		panic("moving among independent states")
+	}
+	if L1.ci.top-len(L1.stack) < n {
+		panic("stack overflow")
+	}
+	top := len(L.stack) - n
+	L1.stack = append(L1.stack, L.stack[top:]...)
+	for i := top; i < len(L.stack); i++ {
+		L.stack[i] = nil
+	}
+	L.stack = L.stack[:top]
+}
+
 func (L *luaState) IsNumber(idx int) bool {
 	_, ok := L.ToNumberX(idx)
 	return ok
@@ -193,7 +270,17 @@ func (L *luaState) IsString(idx int) bool {
 func (L *luaState) IsGoFunction(idx int) bool {
 	val, _ := L.stackGet(idx)
 	switch val.(type) {
-	case lua.GoFunction, *gClosure:
+	case *gClosure:
+		return true
+	default:
+		return false
+	}
+}
+
+func (L *luaState) IsUserdata(idx int) bool {
+	val, _ := L.stackGet(idx)
+	switch val.(type) {
+	case *userdata, lightUserdata:
 		return true
 	default:
 		return false
@@ -258,17 +345,40 @@ func (L *luaState) RawLen(idx int) int {
 	}
 }
 
+/* ToThread returns the thread at the given index, or nil if it is not a thread. */
+func (L *luaState) ToThread(idx int) lua.State {
+	val, _ := L.stackGet(idx)
+	if L1, ok := val.(*luaState); ok {
+		return L1
+	}
+	return nil
+}
+
 func (L *luaState) ToGoFunction(idx int) lua.GoFunction {
 	val, _ := L.stackGet(idx)
-	if f, ok := val.(lua.GoFunction); ok {
-		return f
-	} else if cl, ok := val.(*gClosure); ok {
+	if cl, ok := val.(*gClosure); ok {
 		return cl.f
 	} else {
 		return nil
 	}
 }
 
+/**
+ * ToUserdata returns the Go value of a full userdata, or the pointer of a
+ * light userdata. Otherwise, returns nil.
+ */
+func (L *luaState) ToUserdata(idx int) interface{} {
+	val, _ := L.stackGet(idx)
+	switch u := val.(type) {
+	case *userdata:
+		return u.value
+	case lightUserdata:
+		return unsafe.Pointer(u)
+	default:
+		return nil
+	}
+}
+
 func (L *luaState) Arith(op lua.ArithOp) {
 	var a, b luaValue
 	b = L.stackPop()
@@ -320,30 +430,41 @@ func (L *luaState) PushString(s string) {
 	L.stackPush(s)
 }
 
+/**
+ * Go functions are always pushed as closures, even without upvalues:
+ * Go function values cannot be compared, so each pushed function gets
+ * its own identity instead.
+ */
 func (L *luaState) PushGoClosure(f lua.GoFunction, n int) {
-	if n == 0 {
-		L.stackPush(f)
-	} else {
-		L.stackCheck(n)
-		if n > MAXUPVAL {
-			panic("upvalue index too large")
-		}
-		cl := newGoClosure(f, n)
-		newTop := len(L.stack) - n
-		for n > 0 {
-			n--
-			cl.upvalue[n] = L.stack[newTop+n]
-			L.stack[newTop+n] = nil
-		}
-		L.stack = L.stack[:newTop]
-		L.stackPush(cl)
+	L.stackCheck(n)
+	if n > MAXUPVAL {
+		panic("upvalue index too large")
 	}
+	cl := newGoClosure(f, n)
+	newTop := len(L.stack) - n
+	for n > 0 {
+		n--
+		cl.upvalue[n] = L.stack[newTop+n]
+		L.stack[newTop+n] = nil
+	}
+	L.stack = L.stack[:newTop]
+	L.stackPush(cl)
 }
 
 func (L *luaState) PushBoolean(b bool) {
 	L.stackPush(b)
 }
 
+func (L *luaState) PushLightUserdata(p unsafe.Pointer) {
+	L.stackPush(lightUserdata(p))
+}
+
+/* PushThread pushes 'L' onto its own stack and reports whether it is the main thread. */
+func (L *luaState) PushThread() bool {
+	L.stackPush(L)
+	return L == L.lG.mainThread
+}
+
 func (L *luaState) GetGlobal(name string) lua.Type {
 	reg := L.lG.lRegistry.(*luaTable)
 	return L.getTableAux(reg.get(lua.Integer(lua.RIDX_GLOBALS)), name, false)
@@ -380,6 +501,14 @@ func (L *luaState) CreateTable(nArr, nRec int) {
 	L.stackPush(newLuaTable(nArr, nRec))
 }
 
+/**
+ * NewUserdata pushes onto the stack a new full userdata holding 'v', with
+ * no metatable and a nil user value.
+ */
+func (L *luaState) NewUserdata(v interface{}) {
+	L.stackPush(&userdata{value: v})
+}
+
 func (L *luaState) GetMetatable(idx int) bool {
 	val, _ := L.stackGet(idx)
 	if mt := L.getMetatable(val); mt != nil {
@@ -389,6 +518,16 @@ func (L *luaState) GetMetatable(idx int) bool {
 	return false
 }
 
+func (L *luaState) GetUservalue(idx int) lua.Type {
+	val, _ := L.stackGet(idx)
+	u, ok := val.(*userdata)
+	if !ok {
+		panic("full userdata expected")
+	}
+	L.stackPush(u.user)
+	return typeOf(u.user)
+}
+
 func (L *luaState) SetGlobal(name string) {
 	reg := L.lG.lRegistry.(*luaTable)
 	v := L.stackPop()
@@ -442,32 +581,171 @@ func (L *luaState) SetMetatable(idx int) bool {
 	return true
 }
 
+func (L *luaState) SetUservalue(idx int) {
+	val, _ := L.stackGet(idx)
+	u, ok := val.(*userdata)
+	if !ok {
+		panic("full userdata expected")
+	}
+	u.user = L.stackPop()
+}
+
+func (L *luaState) CallK(nArgs, nResults int, ctx lua.KContext, k lua.KFunction) {
+	if k != nil && L.ci.callStatus&CIST_LUA != 0 {
+		panic("cannot use continuations inside hooks")
+	}
+	L.stackCheck(nArgs + 1)
+	if L.status != lua.OK {
+		panic("cannot do calls on non-normal thread")
+	}
+	if nResults != lua.MULTRET && L.ci.top-len(L.stack) < nResults-nArgs-1 {
+		panic("results from function overflow current stack size")
+	}
+	f, _ := L.stackGet(-(nArgs + 1))
+	if k != nil && L.nny == 0 { /* need to prepare continuation? */
+		L.ci.k = k                 /* save continuation */
+		L.ci.ctx = ctx             /* save context */
+		L.call(f, nArgs, nResults) /* do the call */
+	} else { /* no continuation or no yieldable */
+		L.doCall(f, nArgs, nResults) /* just do the call */
+	}
+	if nResults == lua.MULTRET && L.ci.top < len(L.stack) {
+		L.ci.top = len(L.stack)
+	}
+}
+
 func (L *luaState) Call(nArgs, nResults int) {
-	// @todo "cannot use continuations inside hooks"
+	L.CallK(nArgs, nResults, nil, nil)
+}
+
+func (L *luaState) PCallK(nArgs, nResults, msgh int, ctx lua.KContext, k lua.KFunction) int {
+	if k != nil && L.ci.callStatus&CIST_LUA != 0 {
+		panic("cannot use continuations inside hooks")
+	}
 	L.stackCheck(nArgs + 1)
-	// @todo check L.status == LUA_OK
+	if L.status != lua.OK {
+		panic("cannot do calls on non-normal thread")
+	}
 	if nResults != lua.MULTRET && L.ci.top-len(L.stack) < nResults-nArgs-1 {
 		panic("results from function overflow current stack size")
 	}
+	errFunc := 0
+	if msgh != 0 {
+		if isPseudo(msgh) {
+			panic("invalid index")
+		}
+		errFunc = L.ci.cl + L.AbsIndex(msgh)
+	}
 	f, _ := L.stackGet(-(nArgs + 1))
-	// @todo need to prepare continuation?
-	L.doCall(f, nArgs, nResults)
+	fn := len(L.stack) - (nArgs + 1) /* function to be called */
+	var status int
+	if k == nil || L.nny > 0 { /* no continuation or no yieldable? */
+		status = L.pCall(func() { /* do a 'conventional' protected call */
+			L.doCall(f, nArgs, nResults)
+		}, fn, errFunc)
+	} else { /* prepare continuation (call is already protected by 'Resume') */
+		ci := L.ci
+		ci.k = k     /* save continuation */
+		ci.ctx = ctx /* save context */
+		/* save information for error recovery */
+		ci.extra = fn
+		if L.allowHook { /* save value of 'allowHook' */
+			ci.callStatus |= CIST_OAH
+		} else {
+			ci.callStatus &^= CIST_OAH
+		}
+		ci.oldErrFunc = L.errFunc
+		L.errFunc = errFunc
+		ci.callStatus |= CIST_YPCALL /* function can do error recovery */
+		L.call(f, nArgs, nResults)   /* do the call */
+		ci.callStatus &^= CIST_YPCALL
+		L.errFunc = ci.oldErrFunc
+		status = lua.OK /* if it is here, there were no errors */
+	}
 	if nResults == lua.MULTRET && L.ci.top < len(L.stack) {
 		L.ci.top = len(L.stack)
 	}
+	return status
 }
 
-func (L *luaState) Load(reader io.Reader, chunkName, mode string) int {
-	if proto, err := binary.Undump(reader); err == nil {
-		cl := newLuaClosure(proto)
-		L.stackPush(cl)
-		/* fill a closure with new closed upvalues */
-		for i := range cl.upvals {
-			cl.upvals[i] = &upvalue{
-				level: -1, /* make it closed */
-				value: nil,
+func (L *luaState) PCall(nArgs, nResults, msgh int) int {
+	return L.PCallK(nArgs, nResults, msgh, nil, nil)
+}
+
+/**
+ * ProtectedCall is like 'PCall' with no message handler, but reports
+ * errors as a *lua.LuaError, filled in while the failing call is still
+ * running. As with 'PCall', the error object is left on the stack.
+ */
+func (L *luaState) ProtectedCall(nArgs, nResults int) error {
+	e := &lua.LuaError{Line: -1}
+	base := L.GetTop() - nArgs /* function index */
+	L.PushGoFunction(func(lua.State) int {
+		for ci := L.ci.prev; ci != nil; ci = ci.prev { /* find the running Lua function */
+			if ci.callStatus&CIST_LUA != 0 {
+				e.ChunkName = shortSrc(L.stack[ci.cl].(*lClosure).proto)
+				e.Line = L.currentLine(ci)
+				break
 			}
 		}
+		L.Traceback(L, "", 1)
+		e.Traceback = L.ToString(-1)
+		L.Pop(1)
+		return 1 /* keep the error object */
+	})
+	L.Insert(base) /* put it under function and args */
+	status := L.PCall(nArgs, nResults, base)
+	L.Remove(base) /* remove message handler from the stack */
+	if status == lua.OK {
+		return nil
+	}
+	e.Status = status
+	e.Object = L.stack[len(L.stack)-1]
+	if msg, ok := toString(e.Object); ok {
+		e.Message = msg
+	} else {
+		e.Message = fmt.Sprintf("(error object is a %s value)", typeName(e.Object))
+	}
+	return e
+}
+
+func (L *luaState) Load(reader io.Reader, chunkName, mode string) int {
+	status, _ := L.load(reader, chunkName, mode)
+	return status
+}
+
+/**
+ * ProtectedLoad is like 'Load', but reports failures as a *lua.LuaError
+ * that wraps their cause, such as a *parser.SyntaxError. As with 'Load',
+ * the error message is left on the stack.
+ */
+func (L *luaState) ProtectedLoad(reader io.Reader, chunkName, mode string) error {
+	return L.loadError(L.load(reader, chunkName, mode))
+}
+
+/* Build the error of a failed load, whose message is on the stack. */
+func (L *luaState) loadError(status int, cause error) error {
+	if status == lua.OK {
+		return nil
+	}
+	msg := L.stack[len(L.stack)-1].(string)
+	e := &lua.LuaError{Status: status, Object: msg, Message: msg, Line: -1, Err: cause}
+	var se *parser.SyntaxError
+	if errors.As(cause, &se) {
+		e.ChunkName = se.ChunkName
+		e.Line = se.Line
+	}
+	return e
+}
+
+func (L *luaState) load(reader io.Reader, chunkName, mode string) (int, error) {
+	if chunkName == "" {
+		chunkName = "?"
+	}
+	status, err := L.protectedParser(reader, chunkName, mode)
+	if status == lua.OK { /* no errors? */
+		/* get newly created function */
+		cl := L.stack[len(L.stack)-1].(*lClosure)
 		if len(cl.upvals) > 0 { /* does it have an upvalue? */
 			/* get global table from registry */
 			reg := L.lG.lRegistry.(*luaTable)
@@ -476,8 +754,56 @@ func (L *luaState) Load(reader io.Reader, chunkName, mode string) int {
 			cl.upvals[0].value = gt
 		}
 	}
-	// @todo
-	return 0
+	return status, err
+}
+
+/**
+ * Dump writes the Lua function on the top of the stack as a binary
+ * chunk. It returns 1 if that value is not a Lua function or the writer
+ * fails, and 0 otherwise.
+ */
+func (L *luaState) Dump(writer io.Writer, strip bool) int {
+	val, _ := L.stackGet(-1)
+	if cl, ok := val.(*lClosure); ok {
+		if binary.Dump(cl.proto, writer, strip) == nil {
+			return 0
+		}
+	}
+	return 1
+}
+
+/**
+ * ToProto returns the prototype of the Lua function at the given
+ * index, or nil if the value is not a Lua function. (For use by luac.)
+ */
+func (L *luaState) ToProto(idx int) *binary.Proto {
+	val, _ := L.stackGet(idx)
+	if cl, ok := val.(*lClosure); ok {
+		return cl.proto
+	}
+	return nil
+}
+
+func (L *luaState) Error() int {
+	L.stackCheck(1)
+	/* the message handler is called by 'pCall' */
+	L.throw(lua.ERRRUN)
+	return 0 /* to avoid warnings */
+}
+
+func (L *luaState) Next(idx int) bool {
+	val, _ := L.stackGet(idx)
+	t, ok := val.(*luaTable)
+	if !ok {
+		panic("table expected")
+	}
+	k, v := t.next(L.stackPop())
+	if k == nil {
+		return false /* no more elements */
+	}
+	L.stackPush(k)
+	L.stackPush(v)
+	return true
 }
 
 func (L *luaState) Concat(n int) {
@@ -500,6 +826,20 @@ func (L *luaState) Len(idx int) {
 	L.stackPush(_len(L, val))
 }
 
+/**
+ * StringToNumber converts the numeral 's' to an integer or a float,
+ * following the lexical conventions of Lua (surrounding whitespace and
+ * a sign are allowed), and pushes it. Returns false, pushing nothing, if
+ * 's' is not a numeral.
+ */
+func (L *luaState) StringToNumber(s string) bool {
+	val, ok := number.StringToNumber(s)
+	if ok {
+		L.stackPush(val)
+	}
+	return ok
+}
+
 func (L *luaState) ToNumber(idx int) lua.Number {
 	val, _ := L.ToNumberX(idx)
 	return val
@@ -535,6 +875,10 @@ func (L *luaState) IsBoolean(idx int) bool {
 	return L.Type(idx) == lua.TBOOLEAN
 }
 
+func (L *luaState) IsLightUserdata(idx int) bool {
+	return L.Type(idx) == lua.TLIGHTUSERDATA
+}
+
 func (L *luaState) IsNone(idx int) bool {
 	return L.Type(idx) == lua.TNONE
 }
@@ -581,6 +925,62 @@ func (L *luaState) protectedRun(f func()) (ok bool) {
 	return true
 }
 
+func checkMode(mode, x string) error {
+	if mode != "" && !strings.ContainsRune(mode, rune(x[0])) {
+		return fmt.Errorf("attempt to load a %s chunk (mode is '%s')", x, mode)
+	}
+	return nil
+}
+
+func parseChunk(z *bufio.Reader, chunkName, mode string) (*binary.Proto, error) {
+	c, err := z.Peek(1) /* read first character */
+	if err == io.EOF {
+		c = []byte{0} /* empty chunk is a (empty) text chunk */
+	} else if err != nil {
+		return nil, err
+	}
+	if c[0] == lua.SIGNATURE[0] {
+		if err := checkMode(mode, "binary"); err != nil {
+			return nil, err
+		}
+		return binary.Undump(z, chunkName)
+	}
+	if err := checkMode(mode, "text"); err != nil {
+		return nil, err
+	}
+	chunk, err := io.ReadAll(z)
+	if err != nil {
+		return nil, err
+	}
+	block, err := parser.Parse(string(chunk), chunkName)
+	if err != nil {
+		return nil, err
+	}
+	return codegen.GenProto(block, chunkName)
+}
+
+/**
+ * Compile the chunk read from 'reader' and push its main closure. On
+ * errors, the message is pushed instead and the error is also returned.
+ */
+func (L *luaState) protectedParser(reader io.Reader, chunkName, mode string) (int, error) {
+	proto, err := parseChunk(bufio.NewReader(reader), chunkName, mode)
+	if err != nil {
+		L.stackPush(err.Error())
+		return lua.ERRSYNTAX, err
+	}
+	cl := newLuaClosure(proto)
+	L.stackPush(cl)
+	/* fill a closure with new closed upvalues */
+	for i := range cl.upvals {
+		cl.upvals[i] = &upvalue{
+			level: -1, /* make it closed */
+			value: nil,
+		}
+	}
+	return lua.OK, nil
+}
+
 func (L *luaState) getTableAux(t, k luaValue, raw bool) lua.Type {
 	v := L.getTable(t, k, raw)
 	L.stackPush(v)
@@ -606,7 +1006,7 @@ func (L *luaState) getTable(t, k luaValue, raw bool) luaValue {
 			}
 			tm = L.getMetafield(t, "__index")
 			if tm == nil {
-				panic(typeError(t, "index"))
+				panic(L.typeError(t, "index"))
 			}
 			/* else will try the metamethod */
 		}
@@ -637,7 +1037,7 @@ func (L *luaState) setTable(t, k, v luaValue, raw bool) {
 			}
 			tm = L.getMetafield(t, "__newindex")
 			if tm == nil {
-				panic(typeError(t, "index"))
+				panic(L.typeError(t, "index"))
 			}
 		}
 		/* try the metamethod */
@@ -657,9 +1057,6 @@ func (L *luaState) preCall(val luaValue, nArgs, nResults int) bool {
 	case *gClosure:
 		f = cl.f
 		goto GoFunc
-	case lua.GoFunction:
-		f = cl
-		goto GoFunc
 	case *lClosure: /* Lua function: prepare its call */
 		p := cl.proto
 		frameSize := int(p.MaxStackSize)
@@ -692,7 +1089,9 @@ func (L *luaState) preCall(val luaValue, nArgs, nResults int) bool {
 			nResults:   int16(nResults),
 			callStatus: CIST_LUA,
 		}
-		// @todo hookmask -> callhook
+		if L.hookMask&lua.MASKCALL != 0 {
+			L.callHook(L.ci)
+		}
 		return false
 	default: /* not a function */
 		if L.stackLast-top < 1 { /* ensure space for metamethod */
@@ -700,7 +1099,7 @@ func (L *luaState) preCall(val luaValue, nArgs, nResults int) bool {
 		}
 		tm := L.getMetafield(val, "__call")
 		if typeOf(tm) != lua.TFUNCTION {
-			panic(typeError(val, "call"))
+			panic(L.typeError(val, "call"))
 		}
 		L.stack = L.stack[:top+1]
 		/* open a hole inside the stack */
@@ -719,7 +1118,9 @@ GoFunc:
 		nResults:   int16(nResults),
 		callStatus: 0,
 	}
-	// @todo hook
+	if L.hookMask&lua.MASKCALL != 0 {
+		L.runHook(lua.HOOKCALL, -1)
+	}
 	n := f(L)
 	L.stackCheck(n)
 	L.postCall(len(L.stack)-n, n)
@@ -729,7 +1130,12 @@ GoFunc:
 func (L *luaState) postCall(firstResult, nResults int) bool {
 	ci := L.ci
 	wanted := int(ci.nResults)
-	// @todo L->hookmask
+	if L.hookMask&(lua.MASKRET|lua.MASKLINE) != 0 {
+		if L.hookMask&lua.MASKRET != 0 {
+			L.runHook(lua.HOOKRET, -1) /* results stay in place below the hook */
+		}
+		L.oldPC = ci.prev.pc /* 'oldPC' for caller function */
+	}
 	L.ci = ci.prev
 	/* move results to proper place */
 	if wanted == lua.MULTRET {
@@ -749,11 +1155,135 @@ func (L *luaState) postCall(firstResult, nResults int) bool {
 	return true
 }
 
-func (L *luaState) doCall(f luaValue, nArgs, nResults int) { // --> luaD_callnoyield
-	// @todo L->nny++
-	// @todo luaD_call
-	if !L.preCall(f, nArgs, nResults) {
-		L.execute()
+/**
+ * Call a function. The function and its 'nArgs' arguments are on the top
+ * of the stack; when it returns, its results replace them (luaD_call).
+ */
+func (L *luaState) call(f luaValue, nArgs, nResults int) {
+	L.nCcalls++
+	if L.nCcalls >= conf.LUAI_MAXCCALLS {
+		L.stackError()
+	}
+	if !L.preCall(f, nArgs, nResults) { /* is a Lua function? */
+		L.execute() /* call it */
+	}
+	L.nCcalls--
+}
+
+/**
+ * Check the number of nested Go calls: raise an error at the limit, and
+ * give up (with lua.ERRERR) if the error handling itself goes too deep.
+ */
+func (L *luaState) stackError() {
+	if L.nCcalls == conf.LUAI_MAXCCALLS {
+		panic(runtimeError("C stack overflow"))
+	} else if L.nCcalls >= conf.LUAI_MAXCCALLS+(conf.LUAI_MAXCCALLS>>3) {
+		L.throw(lua.ERRERR) /* error while handling stack error */
+	}
+}
+
+/* Similar to 'call', but does not allow yields during the call. */
+func (L *luaState) doCall(f luaValue, nArgs, nResults int) {
+	L.nny++
+	L.call(f, nArgs, nResults)
+	L.nny--
+}
+
+/* panic value of 'throw': the error object is on the top of the stack */
+type errorStatus int
+
+func (L *luaState) throw(status int) {
+	panic(errorStatus(status))
+}
+
+/**
+ * Call 'f' in protected mode, with 'errFunc' (a stack slot, or 0 for
+ * none) as the message handler. On errors, close the upvalues and
+ * restore the call chain, leaving the error object at 'oldTop', which
+ * becomes the new top.
+ */
+func (L *luaState) pCall(f func(), oldTop, errFunc int) int {
+	oldCI := L.ci
+	oldAllowHook := L.allowHook
+	oldNny := L.nny
+	oldErrFunc := L.errFunc
+	L.errFunc = errFunc
+	status := L.rawRunProtected(f)
+	if status != lua.OK { /* an error occurred? */
+		L.closeUpvalues(oldTop)
+		L.setErrorObj(status, oldTop)
+		L.ci = oldCI
+		L.allowHook = oldAllowHook
+		L.nny = oldNny
+		L.shrinkStack()
+	}
+	L.errFunc = oldErrFunc
+	return status
+}
+
+/**
+ * Run 'f', returning the status of the error (or yield) that interrupted
+ * it, if any. The call chain is left as it was at the point of the error.
+ */
+func (L *luaState) rawRunProtected(f func()) (status int) {
+	oldNCcalls := L.nCcalls
+	defer func() {
+		if x := recover(); x != nil {
+			status = L.errorMsg(x)
+		}
+		L.nCcalls = oldNCcalls
+	}()
+	f()
+	return lua.OK
+}
+
+/**
+ * Get the status of the error 'x' recovered by 'pCall', leaving the
+ * error object on the top of the stack. For runtime errors, the message
+ * handler is called before the call chain is unwound, so that it can
+ * still inspect the functions that raised the error (luaG_errormsg).
+ */
+func (L *luaState) errorMsg(x interface{}) int {
+	var status int
+	switch x := x.(type) {
+	case runtimeError:
+		msg := string(x)
+		if L.ci.callStatus&CIST_LUA != 0 { /* if Lua function, add source:line information */
+			msg = L.addInfo(msg, L.ci)
+		}
+		L.stack = append(L.stack, msg)
+		status = lua.ERRRUN
+	case errorStatus:
+		status = int(x)
+	default:
+		panic(x)
+	}
+	if status == lua.ERRRUN && L.errFunc != 0 { /* is there an error handling function? */
+		errFunc := L.stack[L.errFunc]
+		top := len(L.stack)
+		L.stack = append(L.stack, L.stack[top-1]) /* move argument */
+		L.stack[top-1] = errFunc                  /* push function */
+		/* errors in the handler are not handled again */
+		if L.pCall(func() { L.doCall(errFunc, 1, 1) }, top-1, 0) != lua.OK {
+			status = lua.ERRERR
+		}
+	}
+	return status
+}
+
+/* move the error object of 'status' to 'oldTop', which becomes the new top */
+func (L *luaState) setErrorObj(status, oldTop int) {
+	var errObj luaValue
+	switch status {
+	case lua.ERRMEM: /* memory error? */
+		errObj = MEMERRMSG /* reuse preregistered msg. */
+	case lua.ERRERR:
+		errObj = "error in error handling"
+	default:
+		errObj = L.stack[len(L.stack)-1] /* error message on current top */
+	}
+	for i := oldTop; i < len(L.stack); i++ {
+		L.stack[i] = nil
 	}
-	// @todo L->nny--
+	L.stack = append(L.stack[:oldTop], errObj)
 }
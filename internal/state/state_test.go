@@ -1,11 +1,15 @@
 package state
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"unsafe"
 
 	"github.com/uganh16/golua/pkg/lua"
 )
@@ -56,112 +60,543 @@ func TestLuaOp(t *testing.T) {
 }
 
 func TestLuaVM(t *testing.T) {
-	fileName := filepath.Join(os.TempDir(), "luac.out")
-	cmd := exec.Command("luac", "-o", fileName, "../../test/sum.lua")
-	if err := cmd.Run(); err != nil {
-		t.Errorf("Error running command: %v", err)
-	}
-
 	L := New()
-	f, err := os.Open(fileName)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	L.Load(f, "", "b")
+	loadFile(t, L, "../../test/sum.lua")
 	L.Call(0, 1)
 	printStack(L)
 }
 
-func TestTable(t *testing.T) {
-	fileName := filepath.Join(os.TempDir(), "luac.out")
-	cmd := exec.Command("luac", "-o", fileName, "../../test/test_table.lua")
-	if err := cmd.Run(); err != nil {
-		t.Errorf("Error running command: %v", err)
+func TestLoadNil(t *testing.T) {
+	L := New()
+	chunk := `do local x, y, z = 7, 8, 9 end do local a, b, c; return a, b, c end`
+	if status := L.Load(strings.NewReader(chunk), "=test", "t"); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
 	}
+	L.Call(0, lua.MULTRET)
+	if L.GetTop() != 3 {
+		t.Fatalf("expected 3 results, got %d", L.GetTop())
+	}
+	for idx := 1; idx <= 3; idx++ {
+		if !L.IsNil(idx) {
+			t.Errorf("expected nil at %d, got %s", idx, L.ToString(idx))
+		}
+	}
+}
 
+func TestTable(t *testing.T) {
 	L := New()
-	f, err := os.Open(fileName)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	L.Load(f, "", "b")
+	loadFile(t, L, "../../test/test_table.lua")
 	L.Call(0, 1)
 	printStack(L)
 }
 
 func TestLuaFunction(t *testing.T) {
-	fileName := filepath.Join(os.TempDir(), "luac.out")
-	cmd := exec.Command("luac", "-o", fileName, "../../test/max.lua")
-	if err := cmd.Run(); err != nil {
-		t.Errorf("Error running command: %v", err)
-	}
-
 	L := New()
-	f, err := os.Open(fileName)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	L.Load(f, "", "b")
+	loadFile(t, L, "../../test/max.lua")
 	L.Call(0, 0)
 }
 
 func TestGoFunction(t *testing.T) {
-	fileName := filepath.Join(os.TempDir(), "luac.out")
-	cmd := exec.Command("luac", "-o", fileName, "../../test/hello_world.lua")
-	if err := cmd.Run(); err != nil {
-		t.Errorf("Error running command: %v", err)
-	}
-
 	L := New()
 	L.Register("print", print)
-	f, err := os.Open(fileName)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	L.Load(f, "", "b")
+	loadFile(t, L, "../../test/hello_world.lua")
 	L.Call(0, 0)
 }
 
 func TestClosure(t *testing.T) {
-	fileName := filepath.Join(os.TempDir(), "luac.out")
-	cmd := exec.Command("luac", "-o", fileName, "../../test/test_closure.lua")
-	if err := cmd.Run(); err != nil {
-		t.Errorf("Error running command: %v", err)
-	}
-
 	L := New()
 	L.Register("print", print)
-	f, err := os.Open(fileName)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	L.Load(f, "", "b")
+	loadFile(t, L, "../../test/test_closure.lua")
 	L.Call(0, 0)
 }
 
 func TestMetatable(t *testing.T) {
-	fileName := filepath.Join(os.TempDir(), "luac.out")
-	cmd := exec.Command("luac", "-o", fileName, "../../test/vector2.lua")
-	if err := cmd.Run(); err != nil {
-		t.Errorf("Error running command: %v", err)
-	}
-
 	L := New()
 	L.Register("print", print)
 	L.Register("getmetatable", getMetatable)
 	L.Register("setmetatable", setMetatable)
+	loadFile(t, L, "../../test/vector2.lua")
+	L.Call(0, 0)
+}
+
+func TestLoad(t *testing.T) {
+	L := New()
+	if status := L.Load(strings.NewReader("return 1 +"), "=test", "t"); status != lua.ERRSYNTAX {
+		t.Fatalf("expected ERRSYNTAX, got %d", status)
+	}
+	if msg := L.ToString(-1); msg != "test:1: unexpected symbol near <eof>" {
+		t.Errorf("unexpected error message: %q", msg)
+	}
+	if status := L.Load(strings.NewReader("return 1"), "=test", "b"); status != lua.ERRSYNTAX {
+		t.Fatalf("expected ERRSYNTAX, got %d", status)
+	}
+	if msg := L.ToString(-1); msg != "attempt to load a text chunk (mode is 'b')" {
+		t.Errorf("unexpected error message: %q", msg)
+	}
+	if status := L.Load(strings.NewReader(lua.SIGNATURE+"\x53"), "=test", "t"); status != lua.ERRSYNTAX {
+		t.Fatalf("expected ERRSYNTAX, got %d", status)
+	}
+	if msg := L.ToString(-1); msg != "attempt to load a binary chunk (mode is 't')" {
+		t.Errorf("unexpected error message: %q", msg)
+	}
+	if status := L.Load(strings.NewReader(lua.SIGNATURE+"\x53"), "=test", "bt"); status != lua.ERRSYNTAX {
+		t.Fatalf("expected ERRSYNTAX, got %d", status)
+	}
+	if msg := L.ToString(-1); msg != "test: truncated precompiled chunk" {
+		t.Errorf("unexpected error message: %q", msg)
+	}
+	L.SetTop(0)
+	if status := L.Load(strings.NewReader("return 1 + 2"), "=test", ""); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	L.Call(0, 1)
+	if L.GetTop() != 1 || L.ToInteger(1) != 3 {
+		t.Errorf("unexpected result: %v", L.stack)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "script.lua")
+	if err := os.WriteFile(fileName, []byte("#!/usr/bin/env lua\nreturn 1 +"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	L := New()
+	if status := L.LoadFile(fileName); status != lua.ERRSYNTAX {
+		t.Fatalf("expected ERRSYNTAX, got %d", status)
+	}
+	if msg, expected := L.ToString(-1), fileName+":2: unexpected symbol near <eof>"; msg != expected {
+		t.Errorf("expected %q, got %q", expected, msg)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.lua")
+	if status := L.LoadFile(missing); status != lua.ERRFILE {
+		t.Fatalf("expected ERRFILE, got %d", status)
+	}
+	if msg, expected := L.ToString(-1), "cannot open "+missing+": no such file or directory"; msg != expected {
+		t.Errorf("expected %q, got %q", expected, msg)
+	}
+}
+
+func TestDump(t *testing.T) {
+	for _, strip := range []bool{false, true} {
+		L := New()
+		loadFile(t, L, "../../test/sum.lua")
+		var buf bytes.Buffer
+		if L.Dump(&buf, strip) != 0 {
+			t.Fatal("cannot dump function")
+		}
+		L.SetTop(0)
+		if status := L.Load(&buf, "=sum", "b"); status != lua.OK {
+			t.Fatalf("cannot load dumped chunk: %s", L.ToString(-1))
+		}
+		L.Call(0, 1)
+		if L.ToInteger(1) != 2550 {
+			t.Errorf("unexpected result: %v", L.stack)
+		}
+	}
+
+	/* corrupted sizes must be rejected before they are allocated */
+	L := New()
+	loadFile(t, L, "../../test/sum.lua")
+	var buf bytes.Buffer
+	L.Dump(&buf, false)
+	chunk := buf.Bytes()
+	for i := 33; i+8 <= len(chunk); i++ {
+		corrupted := append([]byte(nil), chunk...)
+		copy(corrupted[i:], "\xff\xff\xff\xff\xff\xff\xff\xff")
+		L.SetTop(0)
+		if status := L.Load(bytes.NewReader(corrupted), "=sum", "b"); status != lua.OK && status != lua.ERRSYNTAX {
+			t.Fatalf("expected OK or ERRSYNTAX at offset %d, got %d: %s", i, status, L.ToString(-1))
+		}
+	}
+	L.SetTop(0)
+	copy(chunk[60:], "\xff\xff\xff\xff\xff\xff\xff\xff")
+	if status := L.Load(bytes.NewReader(chunk), "=sum", "b"); status != lua.ERRSYNTAX {
+		t.Fatalf("expected ERRSYNTAX, got %d", status)
+	}
+	if got, expected := L.ToString(-1), "sum: truncated precompiled chunk"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	L = New()
+	L.PushGoFunction(print)
+	if L.Dump(io.Discard, false) != 1 {
+		t.Errorf("Go function should not be dumped")
+	}
+}
+
+func TestPCall(t *testing.T) {
+	L := New()
+	L.PushGoFunction(func(L lua.State) int {
+		/* called before unwinding: the failing function is still running */
+		L.PushString("handled: " + L.ToString(1))
+		return 1
+	})
+	chunk := `
+		local f
+		do
+			local x = 1
+			f = function() return x end
+		end
+		local y = 2
+		g = function() return y end
+		y = nil + y`
+	if status := L.Load(strings.NewReader(chunk), "=test", "t"); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	if status := L.PCall(0, 0, 1); status != lua.ERRRUN {
+		t.Fatalf("expected ERRRUN, got %d", status)
+	}
+	if L.GetTop() != 2 || L.ToString(2) != "handled: test:9: attempt to perform arithmetic on a nil value" {
+		t.Errorf("unexpected error object: %v", L.stack)
+	}
+	if L.ci != &L.baseCI || L.openUpval != nil {
+		t.Errorf("call chain and upvalues not restored")
+	}
+	L.SetTop(0)
+	L.GetGlobal("g")
+	L.Call(0, 1)
+	if L.ToInteger(1) != 2 {
+		t.Errorf("upvalue not closed on error: %v", L.stack)
+	}
+
+	L.SetTop(0)
+	L.PushGoFunction(func(L lua.State) int {
+		L.PushString("in handler")
+		return L.Error()
+	})
+	L.PushGoFunction(func(L lua.State) int {
+		L.PushInteger(42)
+		return L.Error()
+	})
+	if status := L.PCall(0, 1, 1); status != lua.ERRERR {
+		t.Fatalf("expected ERRERR, got %d", status)
+	}
+	if L.GetTop() != 2 || L.ToString(2) != "error in error handling" {
+		t.Errorf("unexpected error object: %v", L.stack)
+	}
+}
+
+func TestProtectedCall(t *testing.T) {
+	L := New()
+	chunk := "local t = {}\nlocal function f() return t.x.y end\nreturn (f())"
+	if status := L.Load(strings.NewReader(chunk), "@test.lua", "t"); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	err := L.ProtectedCall(0, 0)
+	var e *lua.LuaError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *lua.LuaError, got %v", err)
+	}
+	if !errors.Is(err, lua.ErrRun) || e.Status != lua.ERRRUN {
+		t.Errorf("unexpected status: %d", e.Status)
+	}
+	if e.ChunkName != "test.lua" || e.Line != 2 || e.Message != "test.lua:2: attempt to index a nil value (field 'x')" ||
+		!strings.HasPrefix(e.Traceback, "stack traceback:\n\ttest.lua:2: in local 'f'") {
+		t.Errorf("unexpected error: %+v", e)
+	}
+	if L.GetTop() != 1 || L.ToString(1) != e.Message {
+		t.Errorf("error object not left on the stack: %v", L.stack)
+	}
+
+	L.SetTop(0)
+	L.PushGoFunction(func(L lua.State) int {
+		L.NewTable()
+		return L.Error()
+	})
+	err = L.ProtectedCall(0, 0)
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *lua.LuaError, got %v", err)
+	}
+	if _, ok := e.Object.(*luaTable); !ok || e.Error() != "(error object is a table value)" || e.Line != -1 {
+		t.Errorf("unexpected error: %+v", e)
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	L := New()
+	chunk := `
+		local function f(n)
+			if n == 0 then return traceback() end
+			return (f(n - 1))
+		end
+		return (f(...))`
+	L.Register("traceback", func(L lua.State) int {
+		L.Traceback(L, "msg", 1)
+		return 1
+	})
+	if status := L.Load(strings.NewReader(chunk), "=test", "t"); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	L.PushValue(1)
+	L.PushInteger(1)
+	L.Call(1, 1)
+	expected := "msg\nstack traceback:\n" +
+		"\ttest:3: in upvalue 'f'\n" +
+		"\ttest:4: in local 'f'\n" +
+		"\ttest:6: in main chunk"
+	if tb := L.ToString(-1); tb != expected {
+		t.Errorf("unexpected traceback:\n%s", tb)
+	}
+	L.Pop(1)
+	L.PushInteger(100)
+	L.Call(1, 1)
+	lines := strings.Split(L.ToString(-1), "\n")
+	if len(lines) != 2+LEVELS1+1+LEVELS2 || lines[2+LEVELS1] != "\t..." || lines[len(lines)-1] != "\ttest:6: in main chunk" {
+		t.Errorf("unexpected traceback:\n%s", L.ToString(-1))
+	}
+	L.SetTop(0)
+
+	/* functions reachable from 'package.loaded' are named after it */
+	L.GetSubTable(lua.REGISTRYINDEX, lua.LOADED_TABLE)
+	L.PushGlobalTable()
+	L.SetField(-2, "_G")
+	L.Pop(1)
+	chunk = `
+		function g() return traceback() end
+		return (g())`
+	if status := L.Load(strings.NewReader(chunk), "=test", "t"); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	L.Call(0, 1)
+	expected = "msg\nstack traceback:\n" +
+		"\ttest:2: in function 'g'\n" +
+		"\ttest:3: in main chunk"
+	if tb := L.ToString(-1); tb != expected {
+		t.Errorf("unexpected traceback:\n%s", tb)
+	}
+}
+
+func TestTailCall(t *testing.T) {
+	L := New()
+	L.Register("traceback", func(L lua.State) int {
+		L.Traceback(L, "msg", 1)
+		return 1
+	})
+	chunk := `
+		local function loop(n, ...)
+			if n == 0 then return ... end
+			return loop(n - 1, ...)
+		end
+		local function g() return traceback() end
+		local function f() return g() end
+		return (f()), loop(1000000, "a", "b")`
+	if status := L.Load(strings.NewReader(chunk), "=test", "t"); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	L.Call(0, lua.MULTRET)
+	if L.GetTop() != 3 || L.ToString(2) != "a" || L.ToString(3) != "b" {
+		t.Fatalf("unexpected results: %d values", L.GetTop())
+	}
+	expected := "msg\nstack traceback:\n" +
+		"\ttest:6: in function <test:6>\n" +
+		"\t(...tail calls...)\n" +
+		"\ttest:8: in main chunk"
+	if tb := L.ToString(1); tb != expected {
+		t.Errorf("unexpected traceback:\n%s", tb)
+	}
+}
+
+func TestForLoop(t *testing.T) {
+	tests := []struct {
+		loop     string
+		expected string
+	}{
+		{`for i = 1, 3`, "1 2 3 "},
+		{`for i = 3, 1, -1`, "3 2 1 "},
+		{`for i = 1, 0`, ""},
+		{`for i = 1, 3.5`, "1 2 3 "},
+		{`for i = 3, 0.5, -1`, "3 2 1 "},
+		{`for i = 1.0, 2`, "1.0 2.0 "},
+		{`for i = 1, 2, 0.5`, "1.0 1.5 2.0 "},
+		{`for i = "1", 2`, "1.0 2.0 "},
+		{`for i = 1, -1e100`, ""},
+		{`for i = 0x7ffffffffffffffe, 0x7fffffffffffffff`, "9223372036854775806 9223372036854775807 "},
+		{`for i = -0x7fffffffffffffff, -0x7fffffffffffffff - 1, -1`, "-9223372036854775807 -9223372036854775808 "},
+		{`for i = 1, 10, 0x7fffffffffffffff`, "1 "},
+		{`for i = -0x7fffffffffffffff - 1, 0x7fffffffffffffff, 0x7fffffffffffffff`, "-9223372036854775808 -1 9223372036854775806 "},
+		{`for i = "a", 2`, "test:1: 'for' initial value must be a number"},
+		{`for i = 1, {}`, "test:1: 'for' limit must be a number"},
+		{`for i = 1, 2, nil`, "test:1: 'for' step must be a number"},
+	}
+	L := New()
+	for _, test := range tests {
+		L.SetTop(0)
+		chunk := `local s = "" ` + test.loop + ` do s = s .. i .. " " end return s`
+		if status := L.Load(strings.NewReader(chunk), "=test", "t"); status != lua.OK {
+			t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+		}
+		L.PCall(0, 1, 0)
+		if got := L.ToString(-1); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.loop, test.expected, got)
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	L := New()
+	tests := []struct {
+		chunk    string
+		handler  bool
+		status   int
+		expected string
+	}{
+		{`local function f() return 1 + f() end f()`, false, lua.ERRRUN, "test:1: stack overflow"},
+		{`local function f() return 1 + f() end f()`, false, lua.ERRRUN, "test:1: stack overflow"}, /* stack was shrunk */
+		{`local t = setmetatable({}, {}) getmetatable(t).__index = function(t, k) return t[k] end return t.x`,
+			false, lua.ERRRUN, "test:1: C stack overflow"},
+		{`local function f() return 1 + f() end f()`, true, lua.ERRERR, "error in error handling"},
+		{`return 1`, false, lua.OK, "1"},
+	}
+	L.Register("setmetatable", func(L lua.State) int {
+		L.SetTop(2)
+		L.SetMetatable(1)
+		return 1
+	})
+	L.Register("getmetatable", func(L lua.State) int {
+		L.GetMetatable(1)
+		return 1
+	})
+	for _, test := range tests {
+		L.SetTop(0)
+		errFunc := 0
+		if test.handler { /* a message handler that overflows again */
+			L.LoadString(`local function f() return 1 + f() end return f()`)
+			errFunc = 1
+		}
+		if status := L.Load(strings.NewReader(test.chunk), "=test", "t"); status != lua.OK {
+			t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+		}
+		if status := L.PCall(0, 1, errFunc); status != test.status {
+			t.Errorf("%s: expected status %d, got %d: %s", test.chunk, test.status, status, L.ToString(-1))
+		} else if got := L.ToString(-1); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.chunk, test.expected, got)
+		}
+	}
+}
+
+func TestHook(t *testing.T) {
+	L := New()
+	var events []string
+	names := []string{"call", "return", "line", "count", "tail call"}
+	hook := func(L lua.State, ar *lua.Debug) {
+		L.GetInfo("nSl", ar)
+		events = append(events, fmt.Sprintf("%s %s %s %d", names[ar.Event], ar.What, ar.Name, ar.CurrentLine))
+		/* calls made by the hook do not generate events */
+		L.PushGoFunction(func(L lua.State) int { return 0 })
+		L.Call(0, 0)
+	}
+	L.SetHook(hook, lua.MASKCALL|lua.MASKRET|lua.MASKLINE, 0)
+	if L.GetHook() == nil || L.GetHookMask() != lua.MASKCALL|lua.MASKRET|lua.MASKLINE || L.GetHookCount() != 0 {
+		t.Errorf("unexpected hook settings: mask %d, count %d", L.GetHookMask(), L.GetHookCount())
+	}
+	L.Register("id", func(L lua.State) int { return 1 })
+	chunk := "local function f(x)\n" +
+		"  return id(x) + 1\n" +
+		"end\n" +
+		"local y = f(1)\n" +
+		"for i = 1, 2 do y = y + i end\n" +
+		"local function g() return f(y) end\n" +
+		"return g()"
+	if status := L.Load(strings.NewReader(chunk), "=test", "t"); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	L.Call(0, 1)
+	if got := L.ToInteger(-1); got != 6 {
+		t.Errorf("expected 6, got %d", got)
+	}
+	expected := []string{
+		"call main  3", "line main  3", "line main  4",
+		"call Lua f 2", "line Lua f 2", "call Go id -1", "return Go id -1", "return Lua f 2",
+		"line main  5", "line main  5", "line main  5", /* loop jumps back */
+		"line main  6", "line main  7",
+		"tail call Lua  6", "line Lua  6",
+		"tail call Lua  2", "line Lua  2", "call Go id -1", "return Go id -1", "return Lua  2",
+	}
+	if got, want := strings.Join(events, "\n"), strings.Join(expected, "\n"); got != want {
+		t.Errorf("unexpected events:\n%s\nexpected:\n%s", got, want)
+	}
+	L.SetHook(nil, lua.MASKLINE, 0) /* turn off hooks */
+	if L.GetHook() != nil || L.GetHookMask() != 0 {
+		t.Errorf("expected hooks to be off, got mask %d", L.GetHookMask())
+	}
+
+	/* count hooks, inherited by new threads, can yield */
+	L.SetHook(func(L lua.State, ar *lua.Debug) {
+		if ar.Event != lua.HOOKCOUNT {
+			t.Errorf("expected a count event, got %d", ar.Event)
+		}
+		L.Yield(0)
+	}, lua.MASKCOUNT, 5)
+	co := L.NewThread()
+	L.SetHook(nil, 0, 0)
+	if co.GetHookMask() != lua.MASKCOUNT || co.GetHookCount() != 5 {
+		t.Fatalf("expected the hook to be inherited, got mask %d, count %d", co.GetHookMask(), co.GetHookCount())
+	}
+	co.Load(strings.NewReader("local s = 0 for i = 1, 10 do s = s + i end return s"), "=co", "t")
+	yields := 0
+	for co.Resume(L, 0) == lua.YIELD {
+		yields++
+	}
+	if co.Status() != lua.OK || co.ToInteger(-1) != 55 {
+		t.Errorf("unexpected end of coroutine: status %d, %s", co.Status(), co.ToString(-1))
+	}
+	if yields < 4 {
+		t.Errorf("expected the count hook to yield at least 4 times, got %d", yields)
+	}
+}
+
+func TestAuxlib(t *testing.T) {
+	L := New()
+	L.Register("f", func(L lua.State) int {
+		modes := []string{"fast", "slow"}
+		L.PushInteger(lua.Integer(L.CheckOption(1, "slow", modes)))
+		L.PushNumber(L.OptNumber(2, 0.5))
+		L.PushInteger(L.OptInteger(3, 7))
+		L.PushString(L.OptString(4, "x"))
+		return 4
+	})
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`return f()`, "1 0.5 7 x"},
+		{`return f("fast", 2, 3.0, 4)`, "0 2.0 3 4"},
+		{`return f("medium")`, "test:1: bad argument #1 to 'f' (invalid option 'medium')"},
+		{`return f(nil, "x")`, "test:1: bad argument #2 to 'f' (number expected, got string)"},
+		{`return f(nil, nil, 3.5)`, "test:1: bad argument #3 to 'f' (number has no integer representation)"},
+		{`return f(nil, nil, nil, {})`, "test:1: bad argument #4 to 'f' (string expected, got table)"},
+		{`local t = {f = f} return t:f(1)`, "test:1: calling 'f' on bad self (string expected, got table)"},
+	}
+	for _, test := range tests {
+		L.SetTop(0)
+		if status := L.Load(strings.NewReader(test.chunk), "=test", "t"); status != lua.OK {
+			t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+		}
+		var got string
+		if status := L.PCall(0, lua.MULTRET, 0); status != lua.OK {
+			got = L.ToString(-1)
+		} else {
+			var results []string
+			for i := 1; i <= L.GetTop(); i++ {
+				results = append(results, L.ToString(i))
+			}
+			got = strings.Join(results, " ")
+		}
+		if got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.chunk, test.expected, got)
+		}
+	}
+}
+
+func loadFile(t *testing.T, L *luaState, fileName string) {
 	f, err := os.Open(fileName)
 	if err != nil {
-		return
+		t.Fatal(err)
 	}
 	defer f.Close()
-	L.Load(f, "", "b")
-	L.Call(0, 0)
+	if status := L.Load(f, "@"+fileName, "bt"); status != lua.OK {
+		t.Fatalf("cannot load %s: %s", fileName, L.ToString(-1))
+	}
 }
 
 func printStack(L *luaState) {
@@ -210,3 +645,386 @@ func setMetatable(L lua.State) int {
 	L.SetMetatable(1)
 	return 1
 }
+
+func TestNext(t *testing.T) {
+	L := New()
+	if status := L.LoadString(`return {10, 20, 30, x = 1, y = 2, [2.5] = 3, [5] = 4}`); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	L.Call(0, 1)
+
+	/* clearing fields during the traversal must not disturb it */
+	var keys []string
+	L.PushNil()
+	for L.Next(1) {
+		L.Pop(1)
+		L.PushValue(-1) /* 'ToString' would change the key in place */
+		keys = append(keys, L.ToString(-1))
+		L.Pop(1)
+		L.PushValue(-1)
+		L.PushNil()
+		L.RawSet(1)
+	}
+	if got, expected := strings.Join(keys, " "), "1 2 3 x y 2.5 5"; got != expected {
+		t.Errorf("expected keys %q, got %q", expected, got)
+	}
+	L.PushNil()
+	if L.Next(1) {
+		t.Errorf("expected an empty table, got key %s", L.ToString(-2))
+	}
+
+	L.PushGoFunction(func(L lua.State) int {
+		L.PushString("z")
+		L.Next(1)
+		return 0
+	})
+	L.PushValue(1)
+	if status := L.PCall(1, 0, 0); status != lua.ERRRUN {
+		t.Fatalf("expected ERRRUN, got %d", status)
+	}
+	if got, expected := L.ToString(-1), "invalid key to 'next'"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	L.SetTop(0)
+
+	/* Go functions can be used as keys, each pushed function being distinct */
+	newKey := func(n lua.Integer) lua.GoFunction {
+		return func(L lua.State) int {
+			L.PushInteger(n)
+			return 1
+		}
+	}
+	L.PushGoFunction(newKey(1))
+	L.PushGoFunction(newKey(2))
+	if status := L.LoadString(`local f, g = ... local t = {} t[f] = 1 t[g] = 2 t[f] = t[f] + 10 return t, f ~= g`); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	L.PushValue(1)
+	L.PushValue(2)
+	if status := L.PCall(2, 2, 0); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+	}
+	if !L.ToBoolean(4) {
+		t.Errorf("expected distinct closures to be different")
+	}
+	L.Pop(1)
+	values := map[int]lua.Integer{}
+	L.PushNil()
+	for L.Next(3) {
+		for idx := 1; idx <= 2; idx++ {
+			if L.RawEqual(-2, idx) {
+				values[idx], _ = L.ToIntegerX(-1)
+			}
+		}
+		L.Pop(1)
+	}
+	if len(values) != 2 || values[1] != 11 || values[2] != 2 {
+		t.Errorf("expected values 11 and 2, got %v", values)
+	}
+}
+
+func TestRef(t *testing.T) {
+	L := New()
+	L.PushNil()
+	if ref := L.Ref(lua.REGISTRYINDEX); ref != lua.REFNIL {
+		t.Errorf("expected REFNIL for nil, got %d", ref)
+	}
+	var refs []int
+	for _, s := range []string{"a", "b", "c"} {
+		L.PushString(s)
+		refs = append(refs, L.Ref(lua.REGISTRYINDEX))
+	}
+	if L.GetTop() != 0 {
+		t.Fatalf("expected an empty stack, got %d values", L.GetTop())
+	}
+	if refs[0] == refs[1] || refs[1] == refs[2] || refs[0] == refs[2] {
+		t.Fatalf("expected distinct references, got %v", refs)
+	}
+	L.RawGetI(lua.REGISTRYINDEX, lua.Integer(refs[1]))
+	if got := L.ToString(-1); got != "b" {
+		t.Errorf("expected %q, got %q", "b", got)
+	}
+	L.Pop(1)
+
+	/* released references are reused, live ones are kept */
+	L.Unref(lua.REGISTRYINDEX, refs[0])
+	L.Unref(lua.REGISTRYINDEX, refs[1])
+	L.PushString("d")
+	d := L.Ref(lua.REGISTRYINDEX)
+	L.PushString("e")
+	e := L.Ref(lua.REGISTRYINDEX)
+	L.PushString("f")
+	f := L.Ref(lua.REGISTRYINDEX)
+	if d != refs[1] || e != refs[0] {
+		t.Errorf("expected references %d and %d to be reused, got %d and %d", refs[1], refs[0], d, e)
+	}
+	for ref, expected := range map[int]string{refs[2]: "c", d: "d", e: "e", f: "f"} {
+		L.RawGetI(lua.REGISTRYINDEX, lua.Integer(ref))
+		if got := L.ToString(-1); got != expected {
+			t.Errorf("expected %q for reference %d, got %q", expected, ref, got)
+		}
+		L.Pop(1)
+	}
+
+	/* a handle made on a thread can be used and released from another */
+	co := L.NewThread()
+	co.PushGoFunction(func(L lua.State) int {
+		L.PushInteger(L.ToInteger(1) * 2)
+		return 1
+	})
+	r := lua.NewRef(co)
+	r.Push(L)
+	L.PushInteger(21)
+	L.Call(1, 1)
+	if got := L.ToInteger(-1); got != 42 {
+		t.Errorf("expected 42, got %d", got)
+	}
+	L.Pop(1)
+	top := L.GetTop()
+	r.Release(L)
+	r.Release(L)
+	if L.GetTop() != top || co.GetTop() != 0 {
+		t.Errorf("expected release to leave the stacks alone, got %d and %d", L.GetTop(), co.GetTop())
+	}
+	if typ := r.Push(L); typ != lua.TNIL {
+		t.Errorf("expected nil after release, got %s", L.TypeName(typ))
+	}
+}
+
+func TestUserdata(t *testing.T) {
+	type point struct{ x, y int }
+	L := New()
+	L.NewMetatable("Point")
+	L.NewTable()
+	L.PushGoFunction(func(L lua.State) int {
+		p := L.CheckUdata(1, "Point").(*point)
+		L.PushInteger(lua.Integer(p.x + p.y))
+		return 1
+	})
+	L.SetField(-2, "sum")
+	L.SetField(-2, "__index")
+	L.PushGoFunction(func(L lua.State) int {
+		a, ok1 := L.TestUdata(1, "Point").(*point)
+		b, ok2 := L.TestUdata(2, "Point").(*point)
+		L.PushBoolean(ok1 && ok2 && *a == *b)
+		return 1
+	})
+	L.SetField(-2, "__eq")
+	L.Pop(1)
+	if L.NewMetatable("Point") {
+		t.Errorf("expected 'Point' to be registered already")
+	}
+	L.Pop(1)
+
+	newPoint := func(x, y int) {
+		L.NewUserdata(&point{x, y})
+		L.GetField(lua.REGISTRYINDEX, "Point")
+		L.SetMetatable(-2)
+	}
+	newPoint(1, 2)
+	L.SetGlobal("p")
+	newPoint(1, 2)
+	L.SetGlobal("q")
+	L.NewUserdata(nil) /* no metatable */
+	L.SetGlobal("u")
+	var x int
+	L.PushLightUserdata(unsafe.Pointer(&x))
+	L.SetGlobal("l")
+
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`return p:sum(), p == q, p ~= u, l == l`, "3 true true true"},
+		{`return p.sum({})`, "test:1: bad argument #1 to 'sum' (Point expected, got table)"},
+		{`return p.sum(u)`, "test:1: bad argument #1 to 'sum' (Point expected, got userdata)"},
+		{`return p.sum(l)`, "test:1: bad argument #1 to 'sum' (Point expected, got light userdata)"},
+		{`return u.x`, "test:1: attempt to index a userdata value (global 'u')"},
+	}
+	for _, test := range tests {
+		L.SetTop(0)
+		if status := L.Load(strings.NewReader(test.chunk), "=test", "t"); status != lua.OK {
+			t.Fatalf("expected OK, got %d: %s", status, L.ToString(-1))
+		}
+		var got string
+		if status := L.PCall(0, lua.MULTRET, 0); status != lua.OK {
+			got = L.ToString(-1)
+		} else {
+			var results []string
+			for i := 1; i <= L.GetTop(); i++ {
+				results = append(results, L.ToStringMeta(i))
+				L.Pop(1)
+			}
+			got = strings.Join(results, " ")
+		}
+		if got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.chunk, test.expected, got)
+		}
+	}
+
+	L.SetTop(0)
+	L.GetGlobal("p")
+	if L.GetUservalue(1) != lua.TNIL {
+		t.Errorf("expected a nil user value")
+	}
+	L.Pop(1)
+	L.PushString("extra")
+	L.SetUservalue(1)
+	if L.GetUservalue(1) != lua.TSTRING || L.ToString(-1) != "extra" {
+		t.Errorf("expected user value %q, got %q", "extra", L.ToString(-1))
+	}
+	if p, ok := L.TestUdata(1, "Point").(*point); !ok || p.x != 1 {
+		t.Errorf("expected a Point, got %v", L.TestUdata(1, "Point"))
+	}
+	if !L.IsUserdata(1) || L.IsLightUserdata(1) || L.TestUdata(1, "Other") != nil {
+		t.Errorf("unexpected userdata checks for a full userdata")
+	}
+	L.GetGlobal("q")
+	if L.RawEqual(1, -1) || !L.Compare(1, -1, lua.OPEQ) {
+		t.Errorf("expected distinct userdata comparing equal through '__eq'")
+	}
+	L.GetGlobal("l")
+	if L.Type(-1) != lua.TLIGHTUSERDATA || L.ToUserdata(-1) != unsafe.Pointer(&x) {
+		t.Errorf("expected light userdata %p, got %v", &x, L.ToUserdata(-1))
+	}
+}
+
+func TestResume(t *testing.T) {
+	L := New()
+	L.Register("yield", func(L lua.State) int {
+		return L.Yield(L.GetTop())
+	})
+	co := L.NewThread()
+	if co.Status() != lua.OK || co.IsYieldable() || L.Type(-1) != lua.TTHREAD || L.ToThread(-1) != co {
+		t.Fatalf("unexpected new thread")
+	}
+	if status := co.LoadString(`local a = yield(1, 2) local b = yield(a * 10) return a + b`); status != lua.OK {
+		t.Fatalf("expected OK, got %d: %s", status, co.ToString(-1))
+	}
+
+	steps := []struct {
+		arg    lua.Integer
+		status int
+		values string
+	}{
+		{0, lua.YIELD, "1 2"},
+		{4, lua.YIELD, "40"},
+		{5, lua.OK, "9"},
+	}
+	for i, step := range steps {
+		nArgs := 0
+		if i > 0 {
+			L.PushInteger(step.arg)
+			L.XMove(co, 1)
+			nArgs = 1
+		}
+		if status := co.Resume(L, nArgs); status != step.status {
+			t.Fatalf("step %d: expected status %d, got %d: %s", i, step.status, status, co.ToString(-1))
+		}
+		var values []string
+		for j := 1; j <= co.GetTop(); j++ {
+			values = append(values, co.ToString(j))
+		}
+		co.SetTop(0)
+		if got := strings.Join(values, " "); got != step.values {
+			t.Errorf("step %d: expected %q, got %q", i, step.values, got)
+		}
+	}
+
+	co = L.NewThread()
+	co.LoadString(`local t = nil; yield(); return t.x`)
+	co.Resume(L, 0)
+	if status := co.Resume(L, 0); status != lua.ERRRUN || co.Status() != lua.ERRRUN {
+		t.Fatalf("expected ERRRUN, got %d", status)
+	}
+	if got, expected := co.ToString(-1), `[string "local t = nil; yield(); return t.x"]:1: attempt to index a nil value (local 't')`; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if status := co.Resume(L, 0); status != lua.ERRRUN || co.ToString(-1) != "cannot resume dead coroutine" {
+		t.Errorf("expected a dead coroutine, got %d: %s", status, co.ToString(-1))
+	}
+
+	L.SetTop(0)
+	L.GetGlobal("yield")
+	if status := L.PCall(0, 0, 0); status != lua.ERRRUN || L.ToString(-1) != "attempt to yield from outside a coroutine" {
+		t.Errorf("expected a yield error, got %d: %s", status, L.ToString(-1))
+	}
+}
+
+func TestContinuation(t *testing.T) {
+	L := New()
+	var trace []string
+	L.Register("yield", func(L lua.State) int {
+		return L.YieldK(L.GetTop(), "yield", func(L lua.State, status int, ctx lua.KContext) int {
+			trace = append(trace, fmt.Sprintf("%s:%d:%d", ctx, status, L.GetTop()))
+			return L.GetTop()
+		})
+	})
+	/* call the first argument, then append "!" to each result */
+	L.Register("call", func(L lua.State) int {
+		k := func(L lua.State, status int, ctx lua.KContext) int {
+			trace = append(trace, fmt.Sprintf("%s:%d", ctx, status))
+			for i := 1; i <= L.GetTop(); i++ {
+				L.PushString(L.ToString(i) + "!")
+				L.Replace(i)
+			}
+			return L.GetTop()
+		}
+		L.CallK(L.GetTop()-1, lua.MULTRET, "call", k)
+		return k(L, lua.OK, "call")
+	})
+	L.Register("pcall", func(L lua.State) int {
+		k := func(L lua.State, status int, ctx lua.KContext) int {
+			trace = append(trace, fmt.Sprintf("%s:%d", ctx, status))
+			L.PushBoolean(status == lua.OK || status == lua.YIELD)
+			L.Insert(1)
+			return L.GetTop()
+		}
+		return k(L, L.PCallK(L.GetTop()-1, lua.MULTRET, 0, "pcall", k), "pcall")
+	})
+
+	co := L.NewThread()
+	co.LoadString(`
+		local a = call(function(x) return yield(x) end, "a")
+		local ok, e = pcall(function() yield() local t; return t.x end)
+		return a, ok, e`)
+	resumes := []struct {
+		args   []string
+		status int
+		values string
+	}{
+		{nil, lua.YIELD, "a"},
+		{[]string{"A"}, lua.YIELD, ""},
+		{nil, lua.OK, `A! false [string "..."]:3: attempt to index a nil value (local 't')`},
+	}
+	for i, r := range resumes {
+		for _, arg := range r.args {
+			co.PushString(arg)
+		}
+		if status := co.Resume(L, len(r.args)); status != r.status {
+			t.Fatalf("resume %d: expected status %d, got %d: %s", i, r.status, status, co.ToString(-1))
+		}
+		var values []string
+		for j := 1; j <= co.GetTop(); j++ {
+			values = append(values, co.ToStringMeta(j))
+			co.Pop(1)
+		}
+		co.SetTop(0)
+		if got := strings.Join(values, " "); got != r.values {
+			t.Errorf("resume %d: expected %q, got %q", i, r.values, got)
+		}
+	}
+	expected := "yield:1:1 call:1 yield:1:0 pcall:2"
+	if got := strings.Join(trace, " "); got != expected {
+		t.Errorf("expected trace %q, got %q", expected, got)
+	}
+
+	/* outside a coroutine, continuations are not used */
+	trace = nil
+	L.SetTop(0)
+	L.LoadString(`return call(function() return "x" end)`)
+	L.Call(0, 1)
+	if got := L.ToString(-1) + " " + strings.Join(trace, " "); got != "x! call:0" {
+		t.Errorf("expected %q, got %q", "x! call:0", got)
+	}
+}
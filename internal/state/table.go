@@ -7,10 +7,21 @@ import (
 	"github.com/uganh16/golua/pkg/lua"
 )
 
+/**
+ * Entry of the hash part. Removed entries keep their key with a nil value
+ * (dead keys) until the next rehash, so that a traversal can resume from
+ * a key whose field was cleared in the meantime.
+ */
+type luaNode struct {
+	key luaValue
+	val luaValue
+}
+
 type luaTable struct {
-	__mt *luaTable
-	_arr []luaValue
-	_map map[luaValue]luaValue
+	__mt   *luaTable
+	_arr   []luaValue
+	_nodes []luaNode        /* hash part, in insertion order */
+	_map   map[luaValue]int /* position of each key in '_nodes' */
 }
 
 func newLuaTable(nArr, nRec int) *luaTable {
@@ -19,13 +30,32 @@ func newLuaTable(nArr, nRec int) *luaTable {
 		t._arr = make([]luaValue, 0, nArr)
 	}
 	if nRec > 0 {
-		t._map = make(map[luaValue]luaValue, nRec)
+		t._nodes = make([]luaNode, 0, nRec)
+		t._map = make(map[luaValue]int, nRec)
 	}
 	return t
 }
 
+/**
+ * Try to find a boundary in the array part: an index 'i' such that t[i]
+ * is non-nil and t[i+1] is nil (or 0 if t[1] is nil).
+ */
 func (t *luaTable) len() int {
-	return len(t._arr)
+	j := len(t._arr)
+	if j > 0 && t._arr[j-1] == nil {
+		/* there must be a boundary before 'j': binary search for it */
+		i := 0
+		for j-i > 1 {
+			m := (i + j) / 2
+			if t._arr[m-1] == nil {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return i
+	}
+	return j
 }
 
 func (t *luaTable) get(key luaValue) luaValue {
@@ -35,7 +65,10 @@ func (t *luaTable) get(key luaValue) luaValue {
 			return t._arr[idx-1]
 		}
 	}
-	return t._map[key]
+	if i, found := t._map[key]; found {
+		return t._nodes[i].val
+	}
+	return nil
 }
 
 func (t *luaTable) set(key, val luaValue) {
@@ -53,13 +86,12 @@ func (t *luaTable) set(key, val luaValue) {
 		nArr := lua.Integer(len(t._arr))
 		if idx <= nArr {
 			t._arr[idx-1] = val
-			if idx == nArr && val == nil {
-				t._shrinkArr()
-			}
 			return
 		}
 		if idx == nArr+1 {
-			delete(t._map, key)
+			if i, found := t._map[key]; found {
+				t._nodes[i].val = nil
+			}
 			if val != nil {
 				t._arr = append(t._arr, val)
 				t._expandArr()
@@ -68,35 +100,98 @@ func (t *luaTable) set(key, val luaValue) {
 		}
 	}
 
-	if val != nil {
-		if t._map == nil {
-			t._map = make(map[luaValue]luaValue, 8)
+	if i, found := t._map[key]; found {
+		t._nodes[i].val = val /* may kill or revive the key */
+	} else if val != nil {
+		if len(t._nodes) == cap(t._nodes) {
+			t._rehash()
 		}
-		t._map[key] = val
-	} else {
-		delete(t._map, key)
+		t._map[key] = len(t._nodes)
+		t._nodes = append(t._nodes, luaNode{key, val})
 	}
 }
 
-func (t *luaTable) _shrinkArr() {
-	var nArr int
-	for nArr = len(t._arr); nArr > 0; nArr-- {
-		if t._arr[nArr-1] != nil {
-			break
+/**
+ * Returns the key and value of the entry following 'key' in a traversal
+ * of the table (the array part first, then the hash part), or nil if
+ * there are no more entries.
+ */
+func (t *luaTable) next(key luaValue) (luaValue, luaValue) {
+	i := t._findIndex(key)
+	for ; i < len(t._arr); i++ {
+		if t._arr[i] != nil {
+			return lua.Integer(i + 1), t._arr[i]
 		}
 	}
-	t._arr = t._arr[:nArr]
+	for i -= len(t._arr); i < len(t._nodes); i++ {
+		if n := &t._nodes[i]; n.val != nil {
+			return n.key, n.val
+		}
+	}
+	return nil, nil
 }
 
+/**
+ * Returns the position where a traversal resumes after 'key': the
+ * elements of the array part come first, followed by the nodes of the
+ * hash part. The first iteration is signaled by nil.
+ */
+func (t *luaTable) _findIndex(key luaValue) int {
+	if key == nil {
+		return 0 /* first iteration */
+	}
+	key = _normalizeKey(key)
+	if idx, ok := key.(lua.Integer); ok {
+		if 1 <= idx && idx <= lua.Integer(len(t._arr)) {
+			return int(idx)
+		}
+	}
+	if i, found := t._map[key]; found {
+		return len(t._arr) + i + 1
+	}
+	panic(runtimeError("invalid key to 'next'"))
+}
+
+/* Move the keys following the array part from the hash part into it. */
 func (t *luaTable) _expandArr() {
-	for idx := len(t._arr); true; idx++ {
-		if val, found := t._map[idx]; found {
-			delete(t._map, idx)
-			t._arr = append(t._arr, val)
-		} else {
+	for {
+		key := lua.Integer(len(t._arr) + 1)
+		i, found := t._map[key]
+		if !found || t._nodes[i].val == nil {
 			break
 		}
+		t._arr = append(t._arr, t._nodes[i].val)
+		t._nodes[i].val = nil
+	}
+}
+
+/**
+ * Called when the hash part is full: drop the dead keys (and the trailing
+ * nils of the array part), leaving room for at least as many new keys as
+ * there are live ones.
+ */
+func (t *luaTable) _rehash() {
+	nArr := len(t._arr)
+	for nArr > 0 && t._arr[nArr-1] == nil {
+		nArr--
+	}
+	t._arr = t._arr[:nArr]
+
+	nLive := 0
+	for i := range t._nodes {
+		if t._nodes[i].val != nil {
+			nLive++
+		}
+	}
+	nodes := make([]luaNode, 0, 2*nLive+4)
+	t._map = make(map[luaValue]int, cap(nodes))
+	for _, n := range t._nodes {
+		if n.val != nil {
+			t._map[n.key] = len(nodes)
+			nodes = append(nodes, n)
+		}
 	}
+	t._nodes = nodes
 }
 
 func _normalizeKey(key luaValue) luaValue {
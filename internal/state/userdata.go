@@ -0,0 +1,19 @@
+package state
+
+import "unsafe"
+
+/**
+ * Full userdata: a Go value with its own metatable and an associated
+ * Lua value (the user value).
+ */
+type userdata struct {
+	__mt  *luaTable
+	user  luaValue
+	value interface{}
+}
+
+/**
+ * Light userdata: a bare pointer. Light userdata are compared by address
+ * and share one metatable for the whole type.
+ */
+type lightUserdata unsafe.Pointer
@@ -3,6 +3,7 @@ package state
 import (
 	"fmt"
 	"math"
+	"strconv"
 
 	"github.com/uganh16/golua/internal/number"
 	"github.com/uganh16/golua/pkg/lua"
@@ -24,34 +25,55 @@ func typeOf(val luaValue) lua.Type {
 		return lua.TSTRING
 	case *luaTable:
 		return lua.TTABLE
-	case lua.GoFunction, *lClosure, *gClosure:
+	case *lClosure, *gClosure:
 		return lua.TFUNCTION
+	case *userdata:
+		return lua.TUSERDATA
+	case lightUserdata:
+		return lua.TLIGHTUSERDATA
+	case *luaState:
+		return lua.TTHREAD
 	default:
 		panic("not a Lua value")
 	}
 }
 
+/* Returns the type name of 'val', or its '__name' for tables and full userdata. */
 func typeName(val luaValue) string {
-	if t, ok := val.(*luaTable); ok && t.__mt != nil {
-		if name, ok := t.__mt.get("__name").(string); ok {
+	var mt *luaTable
+	switch val := val.(type) {
+	case *luaTable:
+		mt = val.__mt
+	case *userdata:
+		mt = val.__mt
+	}
+	if mt != nil {
+		if name, ok := mt.get("__name").(string); ok {
 			return name
 		}
 	}
 	return typeNames[typeOf(val)+1]
 }
 
+/* tables and full userdata have individual metatables; other types share one */
 func (L *luaState) getMetatable(val luaValue) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.__mt
-	} else {
+	switch val := val.(type) {
+	case *luaTable:
+		return val.__mt
+	case *userdata:
+		return val.__mt
+	default:
 		return L.lG.mt[typeOf(val)]
 	}
 }
 
 func (L *luaState) setMetatable(val luaValue, mt *luaTable) {
-	if t, ok := val.(*luaTable); ok {
-		t.__mt = mt
-	} else {
+	switch val := val.(type) {
+	case *luaTable:
+		val.__mt = mt
+	case *userdata:
+		val.__mt = mt
+	default:
 		L.lG.mt[typeOf(val)] = mt
 	}
 }
@@ -81,6 +103,9 @@ func toNumber(val luaValue) (lua.Number, bool) {
 	case lua.Integer:
 		return lua.Number(val), true
 	case string:
+		if val, ok := number.ParseInteger(val); ok {
+			return lua.Number(val), true
+		}
 		return number.ParseFloat(val)
 	default:
 		return 0.0, false
@@ -94,10 +119,10 @@ func toInteger(val luaValue) (lua.Integer, bool) {
 	case lua.Number:
 		return number.FloatToInteger(lua.Number(val))
 	case string:
-		if val, ok := number.ParseInteger(string(val)); ok {
+		if val, ok := number.ParseInteger(val); ok {
 			return val, ok
 		}
-		if val, ok := number.ParseFloat(string(val)); ok {
+		if val, ok := number.ParseFloat(val); ok {
 			return number.FloatToInteger(val)
 		}
 	}
@@ -108,9 +133,10 @@ func toString(val luaValue) (string, bool) {
 	switch val := val.(type) {
 	case string:
 		return val, true
-	case lua.Number, lua.Integer:
-		str := fmt.Sprintf("%v", val) // @todo
-		return str, true
+	case lua.Integer:
+		return strconv.FormatInt(val, 10), true
+	case lua.Number:
+		return number.FloatToString(val), true
 	default:
 		return "", false
 	}
@@ -204,6 +230,11 @@ func _arith(L *luaState, op lua.ArithOp, a, b luaValue) luaValue {
 		if iFunc != nil {
 			if a, ok := a.(lua.Integer); ok {
 				if b, ok := b.(lua.Integer); ok {
+					if b == 0 && op == lua.OPMOD {
+						panic(runtimeError("attempt to perform 'n%0'"))
+					} else if b == 0 && op == lua.OPIDIV {
+						panic(runtimeError("attempt to perform 'n//0'"))
+					}
 					return iFunc(a, b)
 				}
 			}
@@ -226,12 +257,12 @@ func _arith(L *luaState, op lua.ArithOp, a, b luaValue) luaValue {
 		_, ok1 := toNumber(a)
 		_, ok2 := toNumber(b)
 		if ok1 && ok2 {
-			panic(toIntError(a, b))
+			panic(L.toIntError(a, b))
 		} else {
-			panic(opIntError(a, b, "perform bitwise operation on"))
+			panic(L.opIntError(a, b, "perform bitwise operation on"))
 		}
 	default:
-		panic(opIntError(a, b, "perform arithmetic on"))
+		panic(L.opIntError(a, b, "perform arithmetic on"))
 	}
 }
 
@@ -274,6 +305,17 @@ func _eq(L *luaState, a, b luaValue) bool {
 			}
 		}
 		return false
+	case *userdata:
+		if b, ok := b.(*userdata); ok {
+			if a == b {
+				return true
+			} else if L != nil {
+				if r, ok := L.callMetamethod(a, b, "__eq"); ok {
+					return toBoolean(r)
+				}
+			}
+		}
+		return false
 	default:
 		return a == b
 	}
@@ -345,7 +387,7 @@ func _len(L *luaState, val luaValue) luaValue {
 	} else if t, ok := val.(*luaTable); ok {
 		return lua.Integer(t.len())
 	} else {
-		panic(typeError(val, "get length of"))
+		panic(L.typeError(val, "get length of"))
 	}
 }
 
@@ -362,7 +404,7 @@ func _concat(L *luaState, vals []luaValue) luaValue {
 		if r, ok := L.callMetamethod(a, b, "__concat"); ok {
 			b = r
 		} else {
-			panic(concatError(a, b))
+			panic(L.concatError(a, b))
 		}
 	}
 	return b
@@ -1,11 +1,32 @@
 package state
 
 import (
+	"math"
+
 	"github.com/uganh16/golua/internal/bytecode"
 	"github.com/uganh16/golua/internal/number"
 	"github.com/uganh16/golua/pkg/lua"
 )
 
+/**
+ * Finish the execution of an opcode interrupted by a yield. Only calls
+ * can be interrupted, as metamethods are called without allowing yields.
+ */
+func (L *luaState) finishOp() {
+	ci := L.ci
+	inst := L.stack[ci.cl].(*lClosure).proto.Code[ci.pc-1] /* interrupted instruction */
+	switch inst.Opcode() {
+	case bytecode.OP_CALL:
+		if _, _, c := inst.ABC(); c-1 >= 0 { /* nresults >= 0? */
+			L.stack = L.stack[:ci.top] /* adjust results */
+		}
+	case bytecode.OP_TFORCALL:
+		L.stack = L.stack[:ci.top] /* correct top */
+	case bytecode.OP_TAILCALL:
+		/* nothing to do: the next instruction returns the results */
+	}
+}
+
 func (L *luaState) execute() {
 	ci := L.ci
 	ci.callStatus |= CIST_FRESH
@@ -16,7 +37,9 @@ newFrame:
 	for {
 		i := p.Code[ci.pc]
 		ci.pc++
-		// @todo L->hookmask
+		if L.hookMask&(lua.MASKLINE|lua.MASKCOUNT) != 0 {
+			L.traceExec()
+		}
 		switch opcode := i.Opcode(); opcode {
 		case bytecode.OP_MOVE: /* R(A) := R(B) */
 			a, b, _ := i.ABC()
@@ -38,24 +61,24 @@ newFrame:
 		case bytecode.OP_LOADNIL: /* R(A), R(A+1), ..., R(A+B) := nil */
 			a, b, _ := i.ABC()
 			for b >= 0 {
-				L.setR(a, nil)
+				L.setR(a+b, nil)
 				b--
 			}
 		case bytecode.OP_GETUPVAL: /* R(A) := UpValue[B] */
 			a, b, _ := i.ABC()
-			L.setR(a, cl.upvals[b].get(L))
+			L.setR(a, cl.upvals[b].get())
 		case bytecode.OP_GETTABUP: /* R(A) := UpValue[B][RK(C)] */
 			a, b, c := i.ABC()
-			L.setR(a, L.getTable(cl.upvals[b].get(L), L.getRK(c), false))
+			L.setR(a, L.getTable(cl.upvals[b].get(), L.getRK(c), false))
 		case bytecode.OP_GETTABLE: /* R(A) := R(B)[RK(C)] */
 			a, b, c := i.ABC()
 			L.setR(a, L.getTable(L.getR(b), L.getRK(c), false))
 		case bytecode.OP_SETTABUP: /* UpValue[A][RK(B)] := RK(C) */
 			a, b, c := i.ABC()
-			L.setTable(cl.upvals[a].get(L), L.getRK(b), L.getRK(c), false)
+			L.setTable(cl.upvals[a].get(), L.getRK(b), L.getRK(c), false)
 		case bytecode.OP_SETUPVAL: /* UpValue[B] := R(A) */
 			a, b, _ := i.ABC()
-			cl.upvals[b].set(L, L.getR(a))
+			cl.upvals[b].set(L.getR(a))
 		case bytecode.OP_SETTABLE: /* R(A)[RK(B)] := RK(C) */
 			a, b, c := i.ABC()
 			L.setTable(L.getR(a), L.getRK(b), L.getRK(c), false)
@@ -150,10 +173,34 @@ newFrame:
 			if b >= 1 {
 				L.stack = L.stack[:base+a+b]
 			} /* (!) else previous instruction set top */
-			if !L.preCall(L.getR(a), len(L.stack)-(base+a)-1, lua.MULTRET) { /* Go function? */
-				ci = L.ci
-				goto newFrame
-				/* @todo tail call: put called frame (n) in place of caller one (o) */
+			if !L.preCall(L.getR(a), len(L.stack)-(base+a)-1, lua.MULTRET) { /* Lua function? */
+				/* tail call: put called frame (n) in place of caller one (o) */
+				nci := L.ci     /* called frame */
+				oci := nci.prev /* caller frame */
+				nFunc := nci.cl /* called function */
+				oFunc := oci.cl /* caller function */
+				top := len(L.stack)
+				/* last stack slot filled by 'preCall' */
+				lim := nci.base + int(L.stack[nFunc].(*lClosure).proto.NumParams)
+				/* close all upvalues from previous call */
+				if len(cl.proto.Protos) > 0 {
+					L.closeUpvalues(oci.base)
+				}
+				/* move new frame into old one */
+				for aux := 0; nFunc+aux < lim; aux++ {
+					L.stack[oFunc+aux] = L.stack[nFunc+aux]
+				}
+				oci.base = oFunc + (nci.base - nFunc) /* correct base */
+				oci.top = oFunc + (top - nFunc)       /* correct top */
+				for j := oci.top; j < top; j++ {
+					L.stack[j] = nil /* erase old copies (for GC) */
+				}
+				L.stack = L.stack[:oci.top]
+				oci.pc = nci.pc
+				oci.callStatus |= CIST_TAIL /* function was tail called */
+				ci = oci                    /* remove new frame */
+				L.ci = ci
+				goto newFrame /* restart 'execute' over new Lua function */
 			}
 		case bytecode.OP_RETURN: /* return R(A), ... ,R(A+B-2) */
 			a, b, _ := i.ABC()
@@ -179,26 +226,91 @@ newFrame:
 			}
 		case bytecode.OP_FORLOOP: /* R(A)+=R(A+2); if R(A) <?= R(A+1) then { pc+=sBx; R(A+3)=R(A) } */
 			a, sbx := i.AsBx()
-			initial := L.getR(a)
-			limit := L.getR(a + 1)
-			step := L.getR(a + 2)
-			initial = _arith(L, lua.OPADD, initial, step)
-			L.setR(a, initial)
-			if step, ok := toNumber(step); ok {
-				if step >= 0 && _le(L, initial, limit) ||
-					step < 0 && _le(L, limit, initial) {
-					ci.pc += sbx
-					L.setR(a+3, initial)
+			if idx, ok := L.getR(a).(lua.Integer); ok { /* integer loop? */
+				count := uint64(L.getR(a + 1).(lua.Integer))
+				if count > 0 { /* more iterations? */
+					idx += L.getR(a + 2).(lua.Integer) /* increment index */
+					L.setR(a+1, lua.Integer(count-1))  /* update counter */
+					L.setR(a, idx)                     /* update internal index... */
+					L.setR(a+3, idx)                   /* ...and external index */
+					ci.pc += sbx                       /* jump back */
+				}
+			} else { /* floating loop */
+				step := L.getR(a + 2).(lua.Number)
+				idx := L.getR(a).(lua.Number) + step /* increment index */
+				limit := L.getR(a + 1).(lua.Number)
+				var more bool
+				if 0 < step {
+					more = idx <= limit
+				} else {
+					more = limit <= idx
+				}
+				if more {
+					ci.pc += sbx     /* jump back */
+					L.setR(a, idx)   /* update internal index... */
+					L.setR(a+3, idx) /* ...and external index */
 				}
-			} else {
-				panic(runtimeError("'for' step must be a number"))
 			}
 		case bytecode.OP_FORPREP: /* R(A)-=R(A+2); pc+=sBx */
 			a, sbx := i.AsBx()
-			L.setR(a, _arith(L, lua.OPSUB, L.getR(a), L.getR(a+2)))
-			ci.pc += sbx
-		case bytecode.OP_TFORCALL:
-		case bytecode.OP_TFORLOOP:
+			init, ok1 := L.getR(a).(lua.Integer)
+			step, ok2 := L.getR(a + 2).(lua.Integer)
+			var limit lua.Integer
+			var stopNow, ok bool
+			if ok1 && ok2 {
+				limit, stopNow, ok = forLimit(L.getR(a+1), step)
+			}
+			if ok { /* all values are integer */
+				/*
+				 * Keep, in place of the limit, the number of iterations
+				 * to run, so that the index can never overflow.
+				 */
+				var count uint64
+				if !stopNow {
+					count = forCount(init, limit, step)
+				}
+				L.setR(a+1, lua.Integer(count))
+				L.setR(a, init-step)
+				ci.pc += sbx
+			} else { /* try making all control values floats */
+				limit, ok := toNumber(L.getR(a + 1))
+				if !ok {
+					panic(runtimeError("'for' limit must be a number"))
+				}
+				L.setR(a+1, limit)
+				step, ok := toNumber(L.getR(a + 2))
+				if !ok {
+					panic(runtimeError("'for' step must be a number"))
+				}
+				L.setR(a+2, step)
+				init, ok := toNumber(L.getR(a))
+				if !ok {
+					panic(runtimeError("'for' initial value must be a number"))
+				}
+				L.setR(a, init-step)
+				ci.pc += sbx
+			}
+		case bytecode.OP_TFORCALL: /* R(A+3), ... ,R(A+2+C) := R(A)(R(A+1), R(A+2)) */
+			a, _, c := i.ABC()
+			cb := base + a + 3       /* call base */
+			L.stack = L.stack[:cb+3] /* func. + 2 args (state and index) */
+			L.stack[cb+2] = L.stack[base+a+2]
+			L.stack[cb+1] = L.stack[base+a+1]
+			L.stack[cb] = L.stack[base+a]
+			L.call(L.stack[cb], 2, c)
+			L.stack = L.stack[:ci.top]
+			i = p.Code[ci.pc] /* go to next instruction */
+			if i.Opcode() != bytecode.OP_TFORLOOP {
+				panic("OP_TFORCALL must be followed by OP_TFORLOOP")
+			}
+			ci.pc++
+			fallthrough
+		case bytecode.OP_TFORLOOP: /* if R(A+1) ~= nil then { R(A)=R(A+1); pc += sBx } */
+			a, sbx := i.AsBx()
+			if L.getR(a+1) != nil { /* continue loop? */
+				L.setR(a, L.getR(a+1)) /* save control variable */
+				ci.pc += sbx           /* jump back */
+			}
 		case bytecode.OP_SETLIST: /* R(A)[(C-1)*FPF+i] := R(A+i), 1 <= i <= B */
 			a, b, c := i.ABC()
 			if b == 0 {
@@ -252,6 +364,69 @@ newFrame:
 	}
 }
 
+/**
+ * Try to convert a 'for' limit to an integer, preserving the semantics
+ * of the loop: a float limit is rounded towards the loop direction, and
+ * a float out of the integer range is clipped (with 'stopNow' true when
+ * the loop cannot run at all). 'ok' is false if the limit is not a
+ * number.
+ */
+func forLimit(obj luaValue, step lua.Integer) (limit lua.Integer, stopNow, ok bool) {
+	if s, isStr := obj.(string); isStr { /* numerals are converted first */
+		if v, isNum := number.StringToNumber(s); isNum {
+			obj = v
+		}
+	}
+	switch n := obj.(type) {
+	case lua.Integer:
+		return n, false, true
+	case lua.Number:
+		f := math.Floor(n)
+		if step < 0 {
+			f = math.Ceil(n)
+		}
+		if i, fits := number.FloatToInteger(f); fits {
+			return i, false, true
+		}
+		if 0 < n { /* if true, float is larger than max integer */
+			return math.MaxInt64, step < 0, true
+		}
+		/* float is smaller than min integer */
+		return math.MinInt64, step >= 0, true
+	default:
+		return 0, false, false /* not a number */
+	}
+}
+
+/**
+ * Number of iterations of an integer loop, as an unsigned value (which
+ * saturates for the single loop that would run 2^64 times).
+ */
+func forCount(init, limit, step lua.Integer) uint64 {
+	var n uint64
+	switch {
+	case step > 0:
+		if init > limit {
+			return 0 /* loop does not run */
+		}
+		n = (uint64(limit) - uint64(init)) / uint64(step)
+	case step < 0:
+		if init < limit {
+			return 0 /* loop does not run */
+		}
+		n = (uint64(init) - uint64(limit)) / (uint64(-(step + 1)) + 1) /* avoid overflow with the most negative step */
+	default:
+		if init < limit {
+			return 0 /* loop does not run */
+		}
+		return math.MaxUint64 /* zero step: the loop runs 'forever' */
+	}
+	if n == math.MaxUint64 {
+		return n
+	}
+	return n + 1
+}
+
 func (L *luaState) getR(idx int) luaValue {
 	return L.stack[L.ci.base+idx]
 }
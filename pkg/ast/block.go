@@ -0,0 +1,21 @@
+/**
+ * Package ast declares the types used to represent syntax trees for
+ * Lua 5.3 chunks. Every node records the line numbers it was built
+ * from, so that later passes can produce line information.
+ */
+package ast
+
+/* chunk ::= block */
+/* block ::= {stat} [retstat] */
+type Block struct {
+	Stats    []Stat
+	LastLine int /* line of the token that closes the block */
+}
+
+type Exp interface {
+	expNode()
+}
+
+type Stat interface {
+	statNode()
+}
@@ -0,0 +1,131 @@
+package ast
+
+import (
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/* nil */
+type NilExp struct {
+	Line int
+}
+
+/* true */
+type TrueExp struct {
+	Line int
+}
+
+/* false */
+type FalseExp struct {
+	Line int
+}
+
+/* '...' */
+type VarargExp struct {
+	Line int
+}
+
+type IntegerExp struct {
+	Line int
+	Val  lua.Integer
+}
+
+type FloatExp struct {
+	Line int
+	Val  lua.Number
+}
+
+type StringExp struct {
+	Line int
+	Str  string
+}
+
+type NameExp struct {
+	Line int
+	Name string
+}
+
+/* unop exp */
+type UnopExp struct {
+	Line int /* line of the operator */
+	Op   UnOp
+	Exp  Exp
+}
+
+/* exp binop exp */
+type BinopExp struct {
+	Line int /* line of the operator */
+	Op   BinOp
+	Exp1 Exp
+	Exp2 Exp
+}
+
+/**
+ * tableconstructor ::= '{' [fieldlist] '}'
+ * fieldlist ::= field {fieldsep field} [fieldsep]
+ */
+type TableExp struct {
+	Line     int /* line of '{' */
+	LastLine int /* line of '}' */
+	Fields   []*Field
+}
+
+/* field ::= '[' exp ']' '=' exp | Name '=' exp | exp */
+type Field struct {
+	Line  int /* line where the field starts */
+	Key   Exp /* nil for positional fields; a StringExp for 'Name = exp' */
+	Value Exp
+}
+
+/**
+ * functiondef ::= function funcbody
+ * funcbody ::= '(' [parlist] ')' block end
+ */
+type FuncDefExp struct {
+	Line     int /* line where the function is defined */
+	LastLine int /* line of the closing 'end' */
+	ParList  []string
+	IsVararg bool
+	IsMethod bool /* has an implicit 'self' parameter */
+	Block    *Block
+}
+
+/* '(' exp ')' */
+type ParensExp struct {
+	Line int /* line of '(' */
+	Exp  Exp
+}
+
+/* prefixexp '[' exp ']' | prefixexp '.' Name */
+type IndexExp struct {
+	Line   int
+	Prefix Exp
+	Key    Exp
+}
+
+/**
+ * functioncall ::= prefixexp args | prefixexp ':' Name args
+ * Method is empty for plain calls.
+ */
+type CallExp struct {
+	Line     int /* line where the prefix expression starts */
+	LastLine int /* line of the last token of the arguments */
+	Prefix   Exp
+	Method   string
+	Args     []Exp
+}
+
+func (*NilExp) expNode()     {}
+func (*TrueExp) expNode()    {}
+func (*FalseExp) expNode()   {}
+func (*VarargExp) expNode()  {}
+func (*IntegerExp) expNode() {}
+func (*FloatExp) expNode()   {}
+func (*StringExp) expNode()  {}
+func (*NameExp) expNode()    {}
+func (*UnopExp) expNode()    {}
+func (*BinopExp) expNode()   {}
+func (*TableExp) expNode()   {}
+func (*FuncDefExp) expNode() {}
+func (*ParensExp) expNode()  {}
+func (*IndexExp) expNode()   {}
+func (*CallExp) expNode()    {}
@@ -0,0 +1,52 @@
+package ast
+
+/* ORDER OPR */
+type BinOp int
+
+const (
+	OpAdd    BinOp = iota // +
+	OpSub                 // -
+	OpMul                 // *
+	OpMod                 // %
+	OpPow                 // ^
+	OpDiv                 // /
+	OpIDiv                // //
+	OpBAnd                // &
+	OpBOr                 // |
+	OpBXor                // ~
+	OpShl                 // <<
+	OpShr                 // >>
+	OpConcat              // ..
+	OpEq                  // ==
+	OpLt                  // <
+	OpLe                  // <=
+	OpNe                  // ~=
+	OpGt                  // >
+	OpGe                  // >=
+	OpAnd                 // and
+	OpOr                  // or
+)
+
+var binOpNames = [...]string{
+	"+", "-", "*", "%", "^", "/", "//", "&", "|", "~", "<<", ">>",
+	"..", "==", "<", "<=", "~=", ">", ">=", "and", "or",
+}
+
+func (op BinOp) String() string {
+	return binOpNames[op]
+}
+
+type UnOp int
+
+const (
+	OpMinus UnOp = iota // - (unary minus)
+	OpBNot              // ~
+	OpNot               // not
+	OpLen               // #
+)
+
+var unOpNames = [...]string{"-", "~", "not", "#"}
+
+func (op UnOp) String() string {
+	return unOpNames[op]
+}
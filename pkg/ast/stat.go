@@ -0,0 +1,133 @@
+package ast
+
+/* ';' */
+type EmptyStat struct {
+	Line int
+}
+
+/* break */
+type BreakStat struct {
+	Line int
+}
+
+/* '::' Name '::' */
+type LabelStat struct {
+	Line int
+	Name string
+}
+
+/* goto Name */
+type GotoStat struct {
+	Line int
+	Name string
+}
+
+/* do block end */
+type DoStat struct {
+	Line  int
+	Block *Block
+}
+
+/* functioncall */
+type CallStat struct {
+	Line int
+	Call *CallExp
+}
+
+/* while exp do block end */
+type WhileStat struct {
+	Line  int
+	Cond  Exp
+	Block *Block
+}
+
+/* repeat block until exp */
+type RepeatStat struct {
+	Line  int
+	Block *Block
+	Cond  Exp
+}
+
+/**
+ * if exp then block {elseif exp then block} [else block] end
+ * Conds[i] guards Blocks[i]; Else is nil when there is no 'else' part.
+ */
+type IfStat struct {
+	Line   int
+	Conds  []Exp
+	Blocks []*Block
+	Else   *Block
+}
+
+/* for Name '=' exp ',' exp [',' exp] do block end */
+type NumericForStat struct {
+	Line    int
+	VarName string
+	Init    Exp
+	Limit   Exp
+	Step    Exp /* nil when omitted */
+	Block   *Block
+}
+
+/* for namelist in explist do block end */
+type GenericForStat struct {
+	Line     int
+	NameList []string
+	ExpList  []Exp
+	Block    *Block
+}
+
+/**
+ * function funcname funcbody
+ * Var is a NameExp or a chain of IndexExps; for 'function a.b:c()' the
+ * last key is the method name and Func.IsMethod is set.
+ */
+type FuncStat struct {
+	Line int
+	Var  Exp
+	Func *FuncDefExp
+}
+
+/* local function Name funcbody */
+type LocalFuncStat struct {
+	Line int /* line of 'local' */
+	Name string
+	Func *FuncDefExp
+}
+
+/* local namelist ['=' explist] */
+type LocalStat struct {
+	Line     int
+	NameList []string
+	ExpList  []Exp
+}
+
+/* varlist '=' explist */
+type AssignStat struct {
+	Line    int
+	VarList []Exp
+	ExpList []Exp
+}
+
+/* return [explist] [';'] */
+type ReturnStat struct {
+	Line    int
+	ExpList []Exp
+}
+
+func (*EmptyStat) statNode()      {}
+func (*BreakStat) statNode()      {}
+func (*LabelStat) statNode()      {}
+func (*GotoStat) statNode()       {}
+func (*DoStat) statNode()         {}
+func (*CallStat) statNode()       {}
+func (*WhileStat) statNode()      {}
+func (*RepeatStat) statNode()     {}
+func (*IfStat) statNode()         {}
+func (*NumericForStat) statNode() {}
+func (*GenericForStat) statNode() {}
+func (*FuncStat) statNode()       {}
+func (*LocalFuncStat) statNode()  {}
+func (*LocalStat) statNode()      {}
+func (*AssignStat) statNode()     {}
+func (*ReturnStat) statNode()     {}
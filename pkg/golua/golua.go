@@ -1,6 +1,8 @@
 package golua
 
 import (
+	"strings"
+
 	"github.com/uganh16/golua/internal/state"
 	"github.com/uganh16/golua/pkg/lua"
 )
@@ -8,3 +10,37 @@ import (
 func NewState() lua.State {
 	return state.New()
 }
+
+/**
+ * DoFile loads and runs the given file, leaving its results on the
+ * stack. Failures are reported as a *lua.LuaError, with the stack left
+ * as it was.
+ */
+func DoFile(L lua.State, fileName string) error {
+	if err := L.ProtectedLoadFile(fileName); err != nil {
+		L.Pop(1) /* remove error message */
+		return err
+	}
+	return doCall(L)
+}
+
+/**
+ * DoString loads and runs the given string, leaving its results on the
+ * stack. Failures are reported as a *lua.LuaError, with the stack left
+ * as it was.
+ */
+func DoString(L lua.State, s string) error {
+	if err := L.ProtectedLoad(strings.NewReader(s), s, ""); err != nil {
+		L.Pop(1) /* remove error message */
+		return err
+	}
+	return doCall(L)
+}
+
+func doCall(L lua.State) error {
+	if err := L.ProtectedCall(0, lua.MULTRET); err != nil {
+		L.Pop(1) /* remove error object */
+		return err
+	}
+	return nil
+}
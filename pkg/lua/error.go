@@ -0,0 +1,66 @@
+package lua
+
+import "errors"
+
+/* errors matching each status code, for use with 'errors.Is' */
+var (
+	ErrRun    = errors.New("runtime error")
+	ErrSyntax = errors.New("syntax error")
+	ErrMem    = errors.New("memory allocation error")
+	ErrGCMM   = errors.New("error in __gc metamethod")
+	ErrErr    = errors.New("error in error handling")
+	ErrFile   = errors.New("file error")
+)
+
+/**
+ * LuaError describes a failure of a Lua chunk, as returned by
+ * 'ProtectedCall', 'ProtectedLoad' and the helpers in package golua.
+ *
+ * Object is the error object as it was raised. A nil, boolean, number
+ * or string is given as nil, a bool, an Integer or Number, or a string.
+ * Any other value (a table, function, userdata or thread) is an opaque
+ * reference into the State: it can only be compared for identity, and
+ * should be inspected through the stack, where the error object is left.
+ */
+type LuaError struct {
+	Status    int         /* status code (ERRRUN, ERRSYNTAX, ...) */
+	Object    interface{} /* the error object (see above) */
+	Message   string      /* the error object as a string */
+	ChunkName string      /* chunk running when the error was raised, if any */
+	Line      int         /* line running when the error was raised, or -1 */
+	Traceback string      /* stack traceback at the point of the error */
+	Err       error       /* Go error that caused the failure, if any */
+}
+
+func (e *LuaError) Error() string {
+	return e.Message
+}
+
+/* Unwrap returns the error matching the status code. */
+func (e *LuaError) Unwrap() error {
+	switch e.Status {
+	case ERRRUN:
+		return ErrRun
+	case ERRSYNTAX:
+		return ErrSyntax
+	case ERRMEM:
+		return ErrMem
+	case ERRGCMM:
+		return ErrGCMM
+	case ERRERR:
+		return ErrErr
+	case ERRFILE:
+		return ErrFile
+	default:
+		return nil
+	}
+}
+
+/* Is and As look into the Go error that caused the failure, if any. */
+func (e *LuaError) Is(target error) bool {
+	return e.Err != nil && errors.Is(e.Err, target)
+}
+
+func (e *LuaError) As(target interface{}) bool {
+	return e.Err != nil && errors.As(e.Err, target)
+}
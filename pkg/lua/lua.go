@@ -2,13 +2,22 @@ package lua
 
 import (
 	"io"
+	"unsafe"
 
 	"github.com/uganh16/golua/internal/conf"
 )
 
 const (
-	VERSION_MAJOR = 5
-	VERSION_MINOR = 3
+	VERSION_MAJOR   = 5
+	VERSION_MINOR   = 3
+	VERSION_RELEASE = 6
+)
+
+const (
+	VERSION   = "Lua 5.3"
+	RELEASE   = "Lua 5.3.6"
+	COPYRIGHT = RELEASE + "  Copyright (C) 1994-2020 Lua.org, PUC-Rio"
+	AUTHORS   = "R. Ierusalimschy, L. H. de Figueiredo, W. Celes"
 )
 
 /* mark for precompiled code ('<esc>Lua') */
@@ -17,6 +26,26 @@ const SIGNATURE = "\x1bLua"
 /* option for multiple returns */
 const MULTRET = -1
 
+/* thread status */
+const (
+	OK = iota
+	YIELD
+	ERRRUN
+	ERRSYNTAX
+	ERRMEM
+	ERRGCMM
+	ERRERR
+)
+
+/* extra error code for 'LoadFileX' */
+const ERRFILE = ERRERR + 1
+
+/* key, in the registry, for table of loaded modules */
+const LOADED_TABLE = "_LOADED"
+
+/* key, in the registry, for table of preloaded loaders */
+const PRELOAD_TABLE = "_PRELOAD"
+
 /* pseudo-indices */
 const REGISTRYINDEX = -conf.LUAI_MAXSTACK - 1000
 
@@ -47,6 +76,10 @@ const (
 /* minimum Lua stack available to a Go function */
 const MINSTACK = 20
 
+/* pre-defined references */
+const NOREF = -2
+const REFNIL = -1
+
 /* predefined values in the registry */
 const RIDX_MAINTHREAD = 1
 const RIDX_GLOBALS = 2
@@ -60,6 +93,15 @@ type Integer = int64
 
 type GoFunction func(State) int
 
+/* type for continuation-function contexts */
+type KContext interface{}
+
+/* type for continuation functions */
+type KFunction func(L State, status int, ctx KContext) int
+
+/* list of functions to be registered by 'NewLib' and 'SetFuncs' */
+type FuncReg map[string]GoFunction
+
 type ArithOp int
 
 const (
@@ -88,6 +130,11 @@ const (
 )
 
 type State interface {
+	/**
+	 * state manipulation
+	 */
+	NewThread() State
+
 	/**
 	 * basic stack manipulation
 	 */
@@ -98,6 +145,7 @@ type State interface {
 	Rotate(idx, n int)
 	Copy(srcIdx, dstIdx int)
 	CheckStack(n int) bool
+	XMove(to State, n int)
 
 	/**
 	 * access functions (stack -> Go)
@@ -105,6 +153,7 @@ type State interface {
 	IsNumber(idx int) bool
 	IsString(idx int) bool
 	IsGoFunction(idx int) bool
+	IsUserdata(idx int) bool
 	IsInteger(idx int) bool
 	Type(idx int) Type
 	TypeName(t Type) string
@@ -114,6 +163,8 @@ type State interface {
 	ToStringX(idx int) (string, bool)
 	RawLen(idx int) int
 	ToGoFunction(idx int) GoFunction
+	ToUserdata(idx int) interface{}
+	ToThread(idx int) State
 
 	/**
 	 * comparison and arithmetic functions
@@ -131,6 +182,8 @@ type State interface {
 	PushString(s string)
 	PushGoClosure(f GoFunction, n int)
 	PushBoolean(b bool)
+	PushLightUserdata(p unsafe.Pointer)
+	PushThread() bool
 
 	/**
 	 * get functions (Lua -> stack)
@@ -144,7 +197,9 @@ type State interface {
 	RawGetI(idx int, n Integer) Type
 
 	CreateTable(nArr, nRec int)
+	NewUserdata(v interface{})
 	GetMetatable(idx int) bool
+	GetUservalue(idx int) Type
 
 	/**
 	 * set functions (stack -> Lua)
@@ -156,18 +211,37 @@ type State interface {
 	RawSet(idx int)
 	RawSetI(idx int, n Integer)
 	SetMetatable(idx int) bool
+	SetUservalue(idx int)
 
 	/**
 	 * 'load' and 'call' functions (load and run Lua code)
 	 */
+	CallK(nArgs, nResults int, ctx KContext, k KFunction)
 	Call(nArgs, nResults int)
+	PCallK(nArgs, nResults, msgh int, ctx KContext, k KFunction) int
+	PCall(nArgs, nResults, msgh int) int
+	ProtectedCall(nArgs, nResults int) error
 	Load(reader io.Reader, chunkName, mode string) int
+	ProtectedLoad(reader io.Reader, chunkName, mode string) error
+	Dump(writer io.Writer, strip bool) int
+
+	/**
+	 * coroutine functions
+	 */
+	YieldK(nResults int, ctx KContext, k KFunction) int
+	Yield(nResults int) int
+	Resume(from State, nArgs int) int
+	Status() int
+	IsYieldable() bool
 
 	/**
 	 * miscellaneous functions
 	 */
+	Error() int
+	Next(idx int) bool
 	Concat(n int)
 	Len(idx int)
+	StringToNumber(s string) bool
 
 	/**
 	 * some useful macros
@@ -180,6 +254,7 @@ type State interface {
 	PushGoFunction(f GoFunction)
 	IsNil(idx int) bool
 	IsBoolean(idx int) bool
+	IsLightUserdata(idx int) bool
 	IsNone(idx int) bool
 	IsNoneOrNil(idx int) bool
 	PushGlobalTable()
@@ -187,4 +262,99 @@ type State interface {
 	Insert(idx int)
 	Remove(idx int)
 	Replace(idx int)
+
+	/**
+	 * debug API
+	 */
+	GetStack(level int, ar *Debug) bool
+	GetInfo(what string, ar *Debug) bool
+	SetHook(f Hook, mask, count int)
+	GetHook() Hook
+	GetHookMask() int
+	GetHookCount() int
+
+	/**
+	 * auxiliary library
+	 */
+	Traceback(L1 State, msg string, level int)
+	LoadFileX(fileName, mode string) int
+	LoadFile(fileName string) int
+	ProtectedLoadFile(fileName string) error
+	LoadString(s string) int
+	ArgError(arg int, extraMsg string) int
+	TypeError(arg int, tname string) int
+	Where(level int)
+	ErrorF(format string, a ...interface{}) int
+	NewMetatable(tname string) bool
+	TestUdata(ud int, tname string) interface{}
+	CheckUdata(ud int, tname string) interface{}
+	Ref(t int) int
+	Unref(t, ref int)
+	ArgCheck(cond bool, arg int, extraMsg string)
+	CheckOption(arg int, def string, lst []string) int
+	CheckType(arg int, t Type)
+	CheckAny(arg int)
+	CheckString(arg int) string
+	OptString(arg int, def string) string
+	CheckNumber(arg int) Number
+	OptNumber(arg int, def Number) Number
+	CheckInteger(arg int) Integer
+	OptInteger(arg int, def Integer) Integer
+	GetMetafield(obj int, e string) Type
+	CallMeta(obj int, e string) bool
+	ToStringMeta(idx int) string
+	GetSubTable(idx int, fname string) bool
+	RequireF(modName string, openF GoFunction, glb bool)
+	NewLib(l FuncReg)
+	SetFuncs(l FuncReg, nUp int)
+}
+
+/*
+** {======================================================================
+** Debug API
+** =======================================================================
+ */
+
+/**
+ * Event codes
+ */
+const (
+	HOOKCALL = iota
+	HOOKRET
+	HOOKLINE
+	HOOKCOUNT
+	HOOKTAILCALL
+)
+
+/**
+ * Event masks
+ */
+const (
+	MASKCALL  = 1 << HOOKCALL
+	MASKRET   = 1 << HOOKRET
+	MASKLINE  = 1 << HOOKLINE
+	MASKCOUNT = 1 << HOOKCOUNT
+)
+
+/* Functions to be called by the debugger in specific events */
+type Hook func(L State, ar *Debug)
+
+type Debug struct {
+	Event           int
+	Name            string /* (n) */
+	NameWhat        string /* (n) 'global', 'local', 'field', 'method' */
+	What            string /* (S) 'Lua', 'Go', 'main', 'tail' */
+	Source          string /* (S) */
+	CurrentLine     int    /* (l) */
+	LineDefined     int    /* (S) */
+	LastLineDefined int    /* (S) */
+	NUps            int    /* (u) number of upvalues */
+	NParams         int    /* (u) number of parameters */
+	IsVararg        bool   /* (u) */
+	IsTailCall      bool   /* (t) */
+	ShortSrc        string /* (S) */
+	/* private part */
+	CallInfo interface{} /* active function */
 }
+
+/* }====================================================================== */
@@ -0,0 +1,35 @@
+package lua
+
+/**
+ * Ref is a handle to a Lua value anchored in the registry, for Go code
+ * that holds on to Lua values (such as callbacks) between calls. The
+ * value stays alive until the handle is released.
+ */
+type Ref struct {
+	ref int /* reference in the registry, or NOREF once released */
+}
+
+/* NewRef pops the value on the top of the stack and returns a handle to it. */
+func NewRef(L State) *Ref {
+	return &Ref{ref: L.Ref(REGISTRYINDEX)}
+}
+
+/**
+ * Push pushes the referenced value onto the stack of 'L', which may be
+ * any thread sharing the registry, and returns its type. A released
+ * handle pushes nil.
+ */
+func (r *Ref) Push(L State) Type {
+	return L.RawGetI(REGISTRYINDEX, Integer(r.ref))
+}
+
+/**
+ * Release frees the reference, using the stack of 'L', which may be any
+ * running thread sharing the registry (not the thread that created the
+ * handle, which may be suspended or dead by now). Calling it more than
+ * once is harmless.
+ */
+func (r *Ref) Release(L State) {
+	L.Unref(REGISTRYINDEX, r.ref)
+	r.ref = NOREF
+}
@@ -0,0 +1,749 @@
+/**
+ * Package parser implements a recursive-descent parser for Lua 5.3
+ * source text, producing the syntax trees declared in package ast.
+ */
+package parser
+
+import (
+	"fmt"
+
+	"github.com/uganh16/golua/internal/conf"
+	"github.com/uganh16/golua/internal/lexer"
+	"github.com/uganh16/golua/pkg/ast"
+)
+
+/* priority for unary operators */
+const UNARY_PRIORITY = 12
+
+/* ORDER OPR */
+var priority = [...]struct {
+	left  int /* left priority for each binary operator */
+	right int /* right priority */
+}{
+	{10, 10}, {10, 10}, /* '+' '-' */
+	{11, 11}, {11, 11}, /* '*' '%' */
+	{14, 13},           /* '^' (right associative) */
+	{11, 11}, {11, 11}, /* '/' '//' */
+	{6, 6}, {4, 4}, {5, 5}, /* '&' '|' '~' */
+	{7, 7}, {7, 7}, /* '<<' '>>' */
+	{9, 8},                 /* '..' (right associative) */
+	{3, 3}, {3, 3}, {3, 3}, /* ==, <, <= */
+	{3, 3}, {3, 3}, {3, 3}, /* ~=, >, >= */
+	{2, 2}, {1, 1}, /* and, or */
+}
+
+/* state needed to check the function being parsed */
+type funcState struct {
+	prev        *funcState /* enclosing function */
+	lineDefined int
+	isVararg    bool
+}
+
+type parser struct {
+	ls      *lexer.Lexer
+	fs      *funcState
+	nCcalls int /* number of nested syntactical levels */
+}
+
+/**
+ * SyntaxError describes an error found while parsing a chunk. Its text
+ * is "chunkname:line: message", like the reference implementation's.
+ */
+type SyntaxError struct {
+	ChunkName string /* source name, as shown in messages */
+	Line      int    /* line where the error was detected */
+	Message   string /* description of the error, without the position */
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.ChunkName, e.Line, e.Message)
+}
+
+/**
+ * Parse parses a Lua chunk and returns the block of its main function.
+ * Syntax errors are reported as a *SyntaxError.
+ */
+func Parse(chunk, chunkName string) (block *ast.Block, err error) {
+	defer func() {
+		switch x := recover().(type) {
+		case nil:
+			/* no panic */
+		case lexer.SyntaxError:
+			block, err = nil, &SyntaxError{x.ChunkID, x.Line, x.Msg}
+		default:
+			panic(x)
+		}
+	}()
+
+	p := &parser{ls: lexer.New(chunk, chunkName)}
+	/* main function is always declared vararg */
+	p.fs = &funcState{isVararg: true}
+	p.ls.Next() /* read first token */
+	block = p.statList()
+	p.check(lexer.TK_EOS)
+	return
+}
+
+func (p *parser) token() int {
+	return p.ls.Token().Kind
+}
+
+func (p *parser) errorExpected(token int) {
+	p.ls.SyntaxError(fmt.Sprintf("%s expected", lexer.Token2Str(token)))
+}
+
+func (p *parser) errorLimit(limit int, what string) {
+	where := "main function"
+	if line := p.fs.lineDefined; line != 0 {
+		where = fmt.Sprintf("function at line %d", line)
+	}
+	p.ls.SyntaxError(fmt.Sprintf("too many %s (limit is %d) in %s", what, limit, where))
+}
+
+func (p *parser) checkLimit(v, l int, what string) {
+	if v > l {
+		p.errorLimit(l, what)
+	}
+}
+
+func (p *parser) testNext(c int) bool {
+	if p.token() == c {
+		p.ls.Next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) check(c int) {
+	if p.token() != c {
+		p.errorExpected(c)
+	}
+}
+
+func (p *parser) checkNext(c int) {
+	p.check(c)
+	p.ls.Next()
+}
+
+func (p *parser) checkCondition(c bool, msg string) {
+	if !c {
+		p.ls.SyntaxError(msg)
+	}
+}
+
+func (p *parser) checkMatch(what, who, where int) {
+	if !p.testNext(what) {
+		if where == p.ls.LineNumber() {
+			p.errorExpected(what)
+		} else {
+			p.ls.SyntaxError(fmt.Sprintf("%s expected (to close %s at line %d)",
+				lexer.Token2Str(what), lexer.Token2Str(who), where))
+		}
+	}
+}
+
+func (p *parser) strCheckName() string {
+	p.check(lexer.TK_NAME)
+	s := p.ls.Token().Str
+	p.ls.Next()
+	return s
+}
+
+func (p *parser) enterLevel() {
+	p.nCcalls++
+	p.checkLimit(p.nCcalls, conf.LUAI_MAXCCALLS, "C levels")
+}
+
+func (p *parser) leaveLevel() {
+	p.nCcalls--
+}
+
+/*
+** {======================================================================
+** Rules for Statements
+** =======================================================================
+ */
+
+/* check whether current token is in the follow set of a block */
+func (p *parser) blockFollow(withUntil bool) bool {
+	switch p.token() {
+	case lexer.TK_ELSE, lexer.TK_ELSEIF, lexer.TK_END, lexer.TK_EOS:
+		return true
+	case lexer.TK_UNTIL:
+		return withUntil
+	default:
+		return false
+	}
+}
+
+/* statlist -> { stat [';'] } */
+func (p *parser) statList() *ast.Block {
+	block := &ast.Block{}
+	for !p.blockFollow(true) {
+		if p.token() == lexer.TK_RETURN {
+			block.Stats = append(block.Stats, p.statement())
+			break /* 'return' must be last statement */
+		}
+		block.Stats = append(block.Stats, p.statement())
+	}
+	block.LastLine = p.ls.LineNumber()
+	return block
+}
+
+/* block -> statlist */
+func (p *parser) block() *ast.Block {
+	return p.statList()
+}
+
+func (p *parser) statement() ast.Stat {
+	line := p.ls.LineNumber() /* may be needed for error messages */
+	p.enterLevel()
+	defer p.leaveLevel()
+	switch p.token() {
+	case ';': /* stat -> ';' (empty statement) */
+		p.ls.Next() /* skip ';' */
+		return &ast.EmptyStat{Line: line}
+	case lexer.TK_IF: /* stat -> ifstat */
+		return p.ifStat(line)
+	case lexer.TK_WHILE: /* stat -> whilestat */
+		return p.whileStat(line)
+	case lexer.TK_DO: /* stat -> DO block END */
+		p.ls.Next() /* skip DO */
+		block := p.block()
+		p.checkMatch(lexer.TK_END, lexer.TK_DO, line)
+		return &ast.DoStat{Line: line, Block: block}
+	case lexer.TK_FOR: /* stat -> forstat */
+		return p.forStat(line)
+	case lexer.TK_REPEAT: /* stat -> repeatstat */
+		return p.repeatStat(line)
+	case lexer.TK_FUNCTION: /* stat -> funcstat */
+		return p.funcStat(line)
+	case lexer.TK_LOCAL: /* stat -> localstat */
+		p.ls.Next()                        /* skip LOCAL */
+		if p.testNext(lexer.TK_FUNCTION) { /* local function? */
+			return p.localFunc(line)
+		}
+		return p.localStat(line)
+	case lexer.TK_DBCOLON: /* stat -> label */
+		p.ls.Next() /* skip double colon */
+		name := p.strCheckName()
+		p.checkNext(lexer.TK_DBCOLON)
+		return &ast.LabelStat{Line: line, Name: name}
+	case lexer.TK_RETURN: /* stat -> retstat */
+		p.ls.Next() /* skip RETURN */
+		return p.retStat(line)
+	case lexer.TK_BREAK: /* stat -> breakstat */
+		p.ls.Next() /* skip BREAK */
+		return &ast.BreakStat{Line: line}
+	case lexer.TK_GOTO: /* stat -> 'goto' NAME */
+		p.ls.Next() /* skip GOTO */
+		return &ast.GotoStat{Line: line, Name: p.strCheckName()}
+	default: /* stat -> func | assignment */
+		return p.exprStat(line)
+	}
+}
+
+/* ifstat -> IF cond THEN block {ELSEIF cond THEN block} [ELSE block] END */
+func (p *parser) ifStat(line int) *ast.IfStat {
+	stat := &ast.IfStat{Line: line}
+	for {
+		/* [IF | ELSEIF] cond THEN block */
+		p.ls.Next() /* skip IF or ELSEIF */
+		stat.Conds = append(stat.Conds, p.expr())
+		p.checkNext(lexer.TK_THEN)
+		stat.Blocks = append(stat.Blocks, p.block())
+		if p.token() != lexer.TK_ELSEIF {
+			break
+		}
+	}
+	if p.testNext(lexer.TK_ELSE) {
+		stat.Else = p.block() /* 'else' part */
+	}
+	p.checkMatch(lexer.TK_END, lexer.TK_IF, line)
+	return stat
+}
+
+/* whilestat -> WHILE cond DO block END */
+func (p *parser) whileStat(line int) *ast.WhileStat {
+	p.ls.Next() /* skip WHILE */
+	cond := p.expr()
+	p.checkNext(lexer.TK_DO)
+	block := p.block()
+	p.checkMatch(lexer.TK_END, lexer.TK_WHILE, line)
+	return &ast.WhileStat{Line: line, Cond: cond, Block: block}
+}
+
+/* repeatstat -> REPEAT block UNTIL cond */
+func (p *parser) repeatStat(line int) *ast.RepeatStat {
+	p.ls.Next() /* skip REPEAT */
+	block := p.statList()
+	p.checkMatch(lexer.TK_UNTIL, lexer.TK_REPEAT, line)
+	cond := p.expr() /* read condition (inside scope block) */
+	return &ast.RepeatStat{Line: line, Block: block, Cond: cond}
+}
+
+/* forstat -> FOR (fornum | forlist) END */
+func (p *parser) forStat(line int) ast.Stat {
+	var stat ast.Stat
+	p.ls.Next()                 /* skip 'for' */
+	varName := p.strCheckName() /* first variable name */
+	switch p.token() {
+	case '=':
+		stat = p.forNum(varName, line)
+	case ',', lexer.TK_IN:
+		stat = p.forList(varName, line)
+	default:
+		p.ls.SyntaxError("'=' or 'in' expected")
+	}
+	p.checkMatch(lexer.TK_END, lexer.TK_FOR, line)
+	return stat
+}
+
+/* fornum -> NAME = exp1,exp1[,exp1] forbody */
+func (p *parser) forNum(varName string, line int) *ast.NumericForStat {
+	stat := &ast.NumericForStat{Line: line, VarName: varName}
+	p.checkNext('=')
+	stat.Init = p.expr() /* initial value */
+	p.checkNext(',')
+	stat.Limit = p.expr()
+	if p.testNext(',') {
+		stat.Step = p.expr() /* optional step */
+	}
+	stat.Block = p.forBody()
+	return stat
+}
+
+/* forlist -> NAME {,NAME} IN explist forbody */
+func (p *parser) forList(varName string, line int) *ast.GenericForStat {
+	stat := &ast.GenericForStat{Line: line, NameList: []string{varName}}
+	for p.testNext(',') {
+		stat.NameList = append(stat.NameList, p.strCheckName())
+	}
+	p.checkNext(lexer.TK_IN)
+	stat.ExpList = p.exprList()
+	stat.Block = p.forBody()
+	return stat
+}
+
+/* forbody -> DO block */
+func (p *parser) forBody() *ast.Block {
+	p.checkNext(lexer.TK_DO)
+	return p.block()
+}
+
+/* funcstat -> FUNCTION funcname body */
+func (p *parser) funcStat(line int) *ast.FuncStat {
+	p.ls.Next() /* skip FUNCTION */
+	v, isMethod := p.funcName()
+	return &ast.FuncStat{Line: line, Var: v, Func: p.body(isMethod, line)}
+}
+
+/* funcname -> NAME {fieldsel} [':' NAME] */
+func (p *parser) funcName() (ast.Exp, bool) {
+	var v ast.Exp = p.singleVar()
+	for p.token() == '.' {
+		v = p.fieldSel(v)
+	}
+	if p.token() == ':' {
+		return p.fieldSel(v), true
+	}
+	return v, false
+}
+
+func (p *parser) localFunc(line int) *ast.LocalFuncStat {
+	name := p.strCheckName()
+	return &ast.LocalFuncStat{Line: line, Name: name, Func: p.body(false, p.ls.LineNumber())}
+}
+
+/* stat -> LOCAL NAME {',' NAME} ['=' explist] */
+func (p *parser) localStat(line int) *ast.LocalStat {
+	stat := &ast.LocalStat{Line: line}
+	for {
+		stat.NameList = append(stat.NameList, p.strCheckName())
+		if !p.testNext(',') {
+			break
+		}
+	}
+	if p.testNext('=') {
+		stat.ExpList = p.exprList()
+	}
+	return stat
+}
+
+/* stat -> func | assignment */
+func (p *parser) exprStat(line int) ast.Stat {
+	v := p.suffixedExp()
+	if p.token() == '=' || p.token() == ',' { /* stat -> assignment ? */
+		return p.assignment(v, line)
+	}
+	/* stat -> func */
+	call, ok := v.(*ast.CallExp)
+	p.checkCondition(ok, "syntax error")
+	return &ast.CallStat{Line: line, Call: call}
+}
+
+/* assignment -> suffixedexp {',' suffixedexp} '=' explist */
+func (p *parser) assignment(v ast.Exp, line int) *ast.AssignStat {
+	stat := &ast.AssignStat{Line: line}
+	for {
+		p.checkCondition(isVar(v), "syntax error")
+		stat.VarList = append(stat.VarList, v)
+		if !p.testNext(',') {
+			break
+		}
+		v = p.suffixedExp()
+		p.checkLimit(len(stat.VarList)+p.nCcalls, conf.LUAI_MAXCCALLS, "C levels")
+	}
+	p.checkNext('=')
+	stat.ExpList = p.exprList()
+	return stat
+}
+
+/* retstat -> RETURN [explist] [';'] */
+func (p *parser) retStat(line int) *ast.ReturnStat {
+	stat := &ast.ReturnStat{Line: line}
+	if !p.blockFollow(true) && p.token() != ';' {
+		stat.ExpList = p.exprList() /* optional return values */
+	}
+	p.testNext(';') /* skip optional semicolon */
+	return stat
+}
+
+/* }====================================================================== */
+
+/*
+** {======================================================================
+** Rules for Expressions
+** =======================================================================
+ */
+
+func isVar(e ast.Exp) bool {
+	switch e.(type) {
+	case *ast.NameExp, *ast.IndexExp:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *parser) singleVar() *ast.NameExp {
+	line := p.ls.LineNumber()
+	return &ast.NameExp{Line: line, Name: p.strCheckName()}
+}
+
+/* fieldsel -> ['.' | ':'] NAME */
+func (p *parser) fieldSel(v ast.Exp) *ast.IndexExp {
+	line := p.ls.LineNumber()
+	p.ls.Next() /* skip the dot or colon */
+	return &ast.IndexExp{Line: line, Prefix: v, Key: p.codeName()}
+}
+
+func (p *parser) codeName() *ast.StringExp {
+	line := p.ls.LineNumber()
+	return &ast.StringExp{Line: line, Str: p.strCheckName()}
+}
+
+/* index -> '[' expr ']' */
+func (p *parser) yIndex() ast.Exp {
+	p.ls.Next() /* skip the '[' */
+	e := p.expr()
+	p.checkNext(']')
+	return e
+}
+
+/*
+** {======================================================================
+** Rules for Constructors
+** =======================================================================
+ */
+
+/*
+constructor -> '{' [ field { sep field } [sep] ] '}'
+
+	sep -> ',' | ';'
+*/
+func (p *parser) constructor() *ast.TableExp {
+	line := p.ls.LineNumber()
+	t := &ast.TableExp{Line: line}
+	p.checkNext('{')
+	for p.token() != '}' {
+		t.Fields = append(t.Fields, p.field())
+		if !p.testNext(',') && !p.testNext(';') {
+			break
+		}
+	}
+	t.LastLine = p.ls.LineNumber()
+	p.checkMatch('}', '{', line)
+	return t
+}
+
+/* field -> listfield | recfield */
+func (p *parser) field() *ast.Field {
+	line := p.ls.LineNumber()
+	switch p.token() {
+	case lexer.TK_NAME: /* may be 'listfield' or 'recfield' */
+		if p.ls.Lookahead().Kind != '=' { /* expression? */
+			return &ast.Field{Line: line, Value: p.expr()}
+		}
+		/* recfield -> NAME = exp */
+		key := p.codeName()
+		p.checkNext('=')
+		return &ast.Field{Line: line, Key: key, Value: p.expr()}
+	case '[': /* recfield -> '[' exp ']' = exp */
+		key := p.yIndex()
+		p.checkNext('=')
+		return &ast.Field{Line: line, Key: key, Value: p.expr()}
+	default: /* listfield -> exp */
+		return &ast.Field{Line: line, Value: p.expr()}
+	}
+}
+
+/* }====================================================================== */
+
+/* parlist -> [ param { ',' param } ] */
+func (p *parser) parList(f *ast.FuncDefExp) {
+	if p.token() != ')' { /* is 'parlist' not empty? */
+		for {
+			switch p.token() {
+			case lexer.TK_NAME: /* param -> NAME */
+				f.ParList = append(f.ParList, p.strCheckName())
+			case lexer.TK_DOTS: /* param -> '...' */
+				p.ls.Next()
+				f.IsVararg = true
+			default:
+				p.ls.SyntaxError("<name> or '...' expected")
+			}
+			if f.IsVararg || !p.testNext(',') {
+				break
+			}
+		}
+	}
+}
+
+/* body ->  '(' parlist ')' block END */
+func (p *parser) body(isMethod bool, line int) *ast.FuncDefExp {
+	f := &ast.FuncDefExp{Line: line, IsMethod: isMethod}
+	p.checkNext('(')
+	p.parList(f)
+	p.checkNext(')')
+	p.fs = &funcState{prev: p.fs, lineDefined: line, isVararg: f.IsVararg}
+	f.Block = p.statList()
+	p.fs = p.fs.prev
+	f.LastLine = p.ls.LineNumber()
+	p.checkMatch(lexer.TK_END, lexer.TK_FUNCTION, line)
+	return f
+}
+
+/* explist -> expr { ',' expr } */
+func (p *parser) exprList() []ast.Exp {
+	list := []ast.Exp{p.expr()}
+	for p.testNext(',') {
+		list = append(list, p.expr())
+	}
+	return list
+}
+
+func (p *parser) funcArgs(f ast.Exp, method string, line int) *ast.CallExp {
+	call := &ast.CallExp{Line: line, Prefix: f, Method: method}
+	switch p.token() {
+	case '(': /* funcargs -> '(' [ explist ] ')' */
+		p.ls.Next()
+		if p.token() != ')' { /* arg list is not empty? */
+			call.Args = p.exprList()
+		}
+		call.LastLine = p.ls.LineNumber()
+		p.checkMatch(')', '(', line)
+	case '{': /* funcargs -> constructor */
+		t := p.constructor()
+		call.Args = []ast.Exp{t}
+		call.LastLine = t.LastLine
+	case lexer.TK_STRING: /* funcargs -> STRING */
+		tok := p.ls.Token()
+		call.Args = []ast.Exp{&ast.StringExp{Line: tok.Line, Str: tok.Str}}
+		call.LastLine = tok.Line
+		p.ls.Next() /* must use 'seminfo' before 'next' */
+	default:
+		p.ls.SyntaxError("function arguments expected")
+	}
+	return call
+}
+
+/* primaryexp -> NAME | '(' expr ')' */
+func (p *parser) primaryExp() ast.Exp {
+	switch p.token() {
+	case '(':
+		line := p.ls.LineNumber()
+		p.ls.Next()
+		e := p.expr()
+		p.checkMatch(')', '(', line)
+		return &ast.ParensExp{Line: line, Exp: e}
+	case lexer.TK_NAME:
+		return p.singleVar()
+	default:
+		p.ls.SyntaxError("unexpected symbol")
+		panic("unreachable")
+	}
+}
+
+/*
+suffixedexp ->
+
+	primaryexp { '.' NAME | '[' exp ']' | ':' NAME funcargs | funcargs }
+*/
+func (p *parser) suffixedExp() ast.Exp {
+	line := p.ls.LineNumber()
+	v := p.primaryExp()
+	for {
+		switch p.token() {
+		case '.': /* fieldsel */
+			v = p.fieldSel(v)
+		case '[': /* '[' exp1 ']' */
+			keyLine := p.ls.LineNumber()
+			v = &ast.IndexExp{Line: keyLine, Prefix: v, Key: p.yIndex()}
+		case ':': /* ':' NAME funcargs */
+			p.ls.Next()
+			v = p.funcArgs(v, p.strCheckName(), line)
+		case '(', lexer.TK_STRING, '{': /* funcargs */
+			v = p.funcArgs(v, "", line)
+		default:
+			return v
+		}
+	}
+}
+
+/*
+simpleexp -> FLT | INT | STRING | NIL | TRUE | FALSE | ... |
+
+	constructor | FUNCTION body | suffixedexp
+*/
+func (p *parser) simpleExp() ast.Exp {
+	var e ast.Exp
+	tok := p.ls.Token()
+	line := p.ls.LineNumber()
+	switch tok.Kind {
+	case lexer.TK_FLT:
+		e = &ast.FloatExp{Line: line, Val: tok.Num}
+	case lexer.TK_INT:
+		e = &ast.IntegerExp{Line: line, Val: tok.Int}
+	case lexer.TK_STRING:
+		e = &ast.StringExp{Line: line, Str: tok.Str}
+	case lexer.TK_NIL:
+		e = &ast.NilExp{Line: line}
+	case lexer.TK_TRUE:
+		e = &ast.TrueExp{Line: line}
+	case lexer.TK_FALSE:
+		e = &ast.FalseExp{Line: line}
+	case lexer.TK_DOTS: /* vararg */
+		p.checkCondition(p.fs.isVararg, "cannot use '...' outside a vararg function")
+		e = &ast.VarargExp{Line: line}
+	case '{': /* constructor */
+		return p.constructor()
+	case lexer.TK_FUNCTION:
+		p.ls.Next()
+		return p.body(false, line)
+	default:
+		return p.suffixedExp()
+	}
+	p.ls.Next()
+	return e
+}
+
+func getUnOpr(op int) (ast.UnOp, bool) {
+	switch op {
+	case lexer.TK_NOT:
+		return ast.OpNot, true
+	case '-':
+		return ast.OpMinus, true
+	case '~':
+		return ast.OpBNot, true
+	case '#':
+		return ast.OpLen, true
+	default:
+		return 0, false
+	}
+}
+
+func getBinOpr(op int) (ast.BinOp, bool) {
+	switch op {
+	case '+':
+		return ast.OpAdd, true
+	case '-':
+		return ast.OpSub, true
+	case '*':
+		return ast.OpMul, true
+	case '%':
+		return ast.OpMod, true
+	case '^':
+		return ast.OpPow, true
+	case '/':
+		return ast.OpDiv, true
+	case lexer.TK_IDIV:
+		return ast.OpIDiv, true
+	case '&':
+		return ast.OpBAnd, true
+	case '|':
+		return ast.OpBOr, true
+	case '~':
+		return ast.OpBXor, true
+	case lexer.TK_SHL:
+		return ast.OpShl, true
+	case lexer.TK_SHR:
+		return ast.OpShr, true
+	case lexer.TK_CONCAT:
+		return ast.OpConcat, true
+	case lexer.TK_NE:
+		return ast.OpNe, true
+	case lexer.TK_EQ:
+		return ast.OpEq, true
+	case '<':
+		return ast.OpLt, true
+	case lexer.TK_LE:
+		return ast.OpLe, true
+	case '>':
+		return ast.OpGt, true
+	case lexer.TK_GE:
+		return ast.OpGe, true
+	case lexer.TK_AND:
+		return ast.OpAnd, true
+	case lexer.TK_OR:
+		return ast.OpOr, true
+	default:
+		return 0, false
+	}
+}
+
+/**
+ * subexpr -> (simpleexp | unop subexpr) { binop subexpr }
+ * where 'binop' is any binary operator with a priority higher than 'limit'
+ */
+func (p *parser) subExpr(limit int) ast.Exp {
+	var e ast.Exp
+	p.enterLevel()
+	if uop, ok := getUnOpr(p.token()); ok {
+		line := p.ls.LineNumber()
+		p.ls.Next()
+		e = &ast.UnopExp{Line: line, Op: uop, Exp: p.subExpr(UNARY_PRIORITY)}
+	} else {
+		e = p.simpleExp()
+	}
+	/* expand while operators have priorities higher than 'limit' */
+	for {
+		op, ok := getBinOpr(p.token())
+		if !ok || priority[op].left <= limit {
+			break
+		}
+		line := p.ls.LineNumber()
+		p.ls.Next()
+		/* read sub-expression with higher priority */
+		e2 := p.subExpr(priority[op].right)
+		e = &ast.BinopExp{Line: line, Op: op, Exp1: e, Exp2: e2}
+	}
+	p.leaveLevel()
+	return e
+}
+
+func (p *parser) expr() ast.Exp {
+	return p.subExpr(0)
+}
+
+/* }==================================================================== */
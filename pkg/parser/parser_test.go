@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/uganh16/golua/pkg/ast"
+)
+
+func parseExp(t *testing.T, src string) ast.Exp {
+	block, err := Parse("return "+src, "=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block.Stats[0].(*ast.ReturnStat).ExpList[0]
+}
+
+func TestPrecedence(t *testing.T) {
+	/* 1 + 2 * 3 */
+	e := parseExp(t, "1 + 2 * 3").(*ast.BinopExp)
+	if e.Op != ast.OpAdd || e.Exp2.(*ast.BinopExp).Op != ast.OpMul {
+		t.Errorf("'*' should bind tighter than '+'")
+	}
+
+	/* a .. b .. c is a .. (b .. c) */
+	e = parseExp(t, "a .. b .. c").(*ast.BinopExp)
+	if _, ok := e.Exp2.(*ast.BinopExp); !ok || e.Exp1.(*ast.NameExp).Name != "a" {
+		t.Errorf("'..' should be right associative")
+	}
+
+	/* 2 ^ 3 ^ 2 is 2 ^ (3 ^ 2) */
+	e = parseExp(t, "2 ^ 3 ^ 2").(*ast.BinopExp)
+	if _, ok := e.Exp2.(*ast.BinopExp); !ok {
+		t.Errorf("'^' should be right associative")
+	}
+
+	/* -x ^ 2 is -(x ^ 2) */
+	u := parseExp(t, "-x ^ 2").(*ast.UnopExp)
+	if u.Op != ast.OpMinus || u.Exp.(*ast.BinopExp).Op != ast.OpPow {
+		t.Errorf("'^' should bind tighter than unary minus")
+	}
+
+	/* a or b and c < d is a or (b and (c < d)) */
+	e = parseExp(t, "a or b and c < d").(*ast.BinopExp)
+	if e.Op != ast.OpOr {
+		t.Fatalf("expected 'or' at the top, got %v", e.Op)
+	}
+	e = e.Exp2.(*ast.BinopExp)
+	if e.Op != ast.OpAnd || e.Exp2.(*ast.BinopExp).Op != ast.OpLt {
+		t.Errorf("unexpected tree for 'b and c < d'")
+	}
+
+	/* 1 - 2 - 3 is (1 - 2) - 3 */
+	e = parseExp(t, "1 - 2 - 3").(*ast.BinopExp)
+	if _, ok := e.Exp1.(*ast.BinopExp); !ok {
+		t.Errorf("'-' should be left associative")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	chunk := `local a, b = 1
+function t.x.y:m(p, ...) return self end
+for i = 1, 10, 2 do goto continue ::continue:: end
+for k, v in pairs(t) do break end
+if a then elseif b then else end
+while false do end
+repeat local x until x
+obj:method "arg" { 1, 2; k = 3, [4] = 5 }
+a, t[1] = t[1], a
+do ; end
+return`
+	block, err := Parse(chunk, "=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		&ast.LocalStat{}, &ast.FuncStat{}, &ast.NumericForStat{}, &ast.GenericForStat{},
+		&ast.IfStat{}, &ast.WhileStat{}, &ast.RepeatStat{}, &ast.CallStat{}, &ast.AssignStat{},
+		&ast.DoStat{}, &ast.ReturnStat{},
+	}
+	if len(block.Stats) != len(expected) {
+		t.Fatalf("expected %d statements, got %d", len(expected), len(block.Stats))
+	}
+	for i, stat := range block.Stats {
+		line := i + 1
+		switch stat := stat.(type) {
+		case *ast.LocalStat:
+			if len(stat.NameList) != 2 || len(stat.ExpList) != 1 || stat.Line != line {
+				t.Errorf("bad local statement: %+v", stat)
+			}
+		case *ast.FuncStat:
+			key := stat.Var.(*ast.IndexExp).Key.(*ast.StringExp)
+			if key.Str != "m" || !stat.Func.IsMethod || !stat.Func.IsVararg || len(stat.Func.ParList) != 1 {
+				t.Errorf("bad function statement: %+v", stat)
+			}
+		case *ast.NumericForStat:
+			body := stat.Block.Stats
+			if stat.Step == nil || len(body) != 2 {
+				t.Errorf("bad numeric for: %+v", stat)
+			} else if g, ok := body[0].(*ast.GotoStat); !ok || g.Name != "continue" {
+				t.Errorf("bad goto: %+v", body[0])
+			} else if l, ok := body[1].(*ast.LabelStat); !ok || l.Name != "continue" {
+				t.Errorf("bad label: %+v", body[1])
+			}
+		case *ast.GenericForStat:
+			if len(stat.NameList) != 2 || len(stat.ExpList) != 1 {
+				t.Errorf("bad generic for: %+v", stat)
+			}
+		case *ast.IfStat:
+			if len(stat.Conds) != 2 || stat.Else == nil {
+				t.Errorf("bad if statement: %+v", stat)
+			}
+		case *ast.CallStat:
+			outer := stat.Call
+			inner := outer.Prefix.(*ast.CallExp)
+			if inner.Method != "method" || inner.Args[0].(*ast.StringExp).Str != "arg" {
+				t.Errorf("bad method call: %+v", inner)
+			}
+			if fields := outer.Args[0].(*ast.TableExp).Fields; len(fields) != 4 || fields[0].Key != nil || fields[2].Key.(*ast.StringExp).Str != "k" {
+				t.Errorf("bad table constructor: %+v", fields)
+			}
+		case *ast.AssignStat:
+			if len(stat.VarList) != 2 || len(stat.ExpList) != 2 {
+				t.Errorf("bad assignment: %+v", stat)
+			}
+		}
+		if stat, ok := stat.(*ast.ReturnStat); ok && (stat.ExpList != nil || stat.Line != line) {
+			t.Errorf("bad return statement: %+v", stat)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	block, err := Parse(";\nlocal function f() end\nf(\n(1), {\nx = 1})", "=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := block.Stats[0].(*ast.EmptyStat); stat.Line != 1 {
+		t.Errorf("bad empty statement: %+v", stat)
+	}
+	if stat := block.Stats[1].(*ast.LocalFuncStat); stat.Line != 2 {
+		t.Errorf("bad local function: %+v", stat)
+	}
+	stat := block.Stats[2].(*ast.CallStat)
+	if stat.Line != 3 {
+		t.Errorf("bad call statement: %+v", stat)
+	}
+	if e := stat.Call.Args[0].(*ast.ParensExp); e.Line != 4 {
+		t.Errorf("bad parenthesized expression: %+v", e)
+	}
+	if f := stat.Call.Args[1].(*ast.TableExp).Fields[0]; f.Line != 5 {
+		t.Errorf("bad field: %+v", f)
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{"x = ", "test:1: unexpected symbol near <eof>"},
+		{"f() = 1", "test:1: syntax error near '='"},
+		{"x", "test:1: syntax error near <eof>"},
+		{"function f()\nreturn 1\nx = 1", "test:3: 'end' expected (to close 'function' at line 1) near 'x'"},
+		{"local function f() return ... end", "test:1: cannot use '...' outside a vararg function near '...'"},
+		{"for i do end", "test:1: '=' or 'in' expected near 'do'"},
+		{"return return", "test:1: unexpected symbol near 'return'"},
+		{"t = {1, 2", "test:1: '}' expected near <eof>"},
+		{"x = 1 + 'a", "test:1: unfinished string near <eof>"},
+		{"(a) = 1", "test:1: syntax error near '='"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.chunk, "=test")
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%q: expected error %q, got %v", test.chunk, test.expected, err)
+		}
+	}
+
+	_, err := Parse("local x = 1\nx = = 2", "@script.lua")
+	e, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("expected a *SyntaxError, got %T", err)
+	}
+	if e.ChunkName != "script.lua" || e.Line != 2 || e.Message != "unexpected symbol near '='" {
+		t.Errorf("unexpected fields: %+v", *e)
+	}
+}
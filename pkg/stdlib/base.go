@@ -0,0 +1,301 @@
+package stdlib
+
+import (
+	"os"
+	"strings"
+
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+var baseFuncs = lua.FuncReg{
+	"assert":       baseAssert,
+	"dofile":       baseDoFile,
+	"error":        baseError,
+	"getmetatable": baseGetMetatable,
+	"next":         baseNext,
+	"pairs":        basePairs,
+	"pcall":        basePCall,
+	"print":        basePrint,
+	"rawequal":     baseRawEqual,
+	"rawlen":       baseRawLen,
+	"rawget":       baseRawGet,
+	"rawset":       baseRawSet,
+	"select":       baseSelect,
+	"setmetatable": baseSetMetatable,
+	"tonumber":     baseToNumber,
+	"tostring":     baseToString,
+	"type":         baseType,
+	"xpcall":       baseXPCall,
+}
+
+func OpenBase(L lua.State) int {
+	/* open lib into global table */
+	L.PushGlobalTable()
+	L.SetFuncs(baseFuncs, 0)
+	/* set global _G */
+	L.PushValue(-1)
+	L.SetField(-2, "_G")
+	/* set global _VERSION */
+	L.PushString(lua.VERSION)
+	L.SetField(-2, "_VERSION")
+	return 1
+}
+
+func basePrint(L lua.State) int {
+	n := L.GetTop() /* number of arguments */
+	L.GetGlobal("tostring")
+	for i := 1; i <= n; i++ {
+		L.PushValue(-1) /* function to be called */
+		L.PushValue(i)  /* value to print */
+		L.Call(1, 1)
+		s, ok := L.ToStringX(-1) /* get result */
+		if !ok {
+			return L.ErrorF("'tostring' must return a string to 'print'")
+		}
+		if i > 1 {
+			os.Stdout.WriteString("\t")
+		}
+		os.Stdout.WriteString(s)
+		L.Pop(1) /* pop result */
+	}
+	os.Stdout.WriteString("\n")
+	return 0
+}
+
+/**
+ * Convert a string to an integer in the given base, returning false if
+ * it is not a valid numeral.
+ */
+func str2int(s string, base int) (lua.Integer, bool) {
+	var n lua.Integer
+	s = strings.Trim(s, " \f\n\r\t\v") /* skip initial and trailing spaces */
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	if s == "" { /* no digit? */
+		return 0, false
+	}
+	for _, c := range s {
+		var digit int
+		switch {
+		case c >= '0' && c <= '9':
+			digit = int(c - '0')
+		case c >= 'a' && c <= 'z':
+			digit = int(c-'a') + 10
+		case c >= 'A' && c <= 'Z':
+			digit = int(c-'A') + 10
+		default:
+			return 0, false
+		}
+		if digit >= base {
+			return 0, false /* invalid numeral */
+		}
+		n = n*lua.Integer(base) + lua.Integer(digit)
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+func baseToNumber(L lua.State) int {
+	if L.IsNoneOrNil(2) { /* standard conversion? */
+		if L.Type(1) == lua.TNUMBER { /* already a number? */
+			L.SetTop(1) /* yes; return it */
+			return 1
+		}
+		if s, ok := L.ToStringX(1); ok && L.StringToNumber(s) {
+			return 1 /* successful conversion to number */
+		} /* else not a number */
+		L.CheckAny(1) /* (but there must be some parameter) */
+	} else {
+		base := L.CheckInteger(2)
+		L.CheckType(1, lua.TSTRING) /* no numbers as strings */
+		L.ArgCheck(2 <= base && base <= 36, 2, "base out of range")
+		if n, ok := str2int(L.ToString(1), int(base)); ok {
+			L.PushInteger(n)
+			return 1
+		} /* else not a number */
+	}
+	L.PushNil() /* not a number */
+	return 1
+}
+
+func baseError(L lua.State) int {
+	level := L.OptInteger(2, 1)
+	L.SetTop(1)
+	if L.Type(1) == lua.TSTRING && level > 0 {
+		L.Where(int(level)) /* add extra information */
+		L.PushValue(1)
+		L.Concat(2)
+	}
+	return L.Error()
+}
+
+func baseGetMetatable(L lua.State) int {
+	L.CheckAny(1)
+	if !L.GetMetatable(1) {
+		L.PushNil()
+		return 1 /* no metatable */
+	}
+	L.GetMetafield(1, "__metatable")
+	return 1 /* returns either __metatable field (if present) or metatable */
+}
+
+func baseSetMetatable(L lua.State) int {
+	t := L.Type(2)
+	L.CheckType(1, lua.TTABLE)
+	L.ArgCheck(t == lua.TNIL || t == lua.TTABLE, 2, "nil or table expected")
+	if L.GetMetafield(1, "__metatable") != lua.TNIL {
+		return L.ErrorF("cannot change a protected metatable")
+	}
+	L.SetTop(2)
+	L.SetMetatable(1)
+	return 1
+}
+
+func baseRawEqual(L lua.State) int {
+	L.CheckAny(1)
+	L.CheckAny(2)
+	L.PushBoolean(L.RawEqual(1, 2))
+	return 1
+}
+
+func baseRawLen(L lua.State) int {
+	t := L.Type(1)
+	L.ArgCheck(t == lua.TTABLE || t == lua.TSTRING, 1, "table or string expected")
+	L.PushInteger(lua.Integer(L.RawLen(1)))
+	return 1
+}
+
+func baseRawGet(L lua.State) int {
+	L.CheckType(1, lua.TTABLE)
+	L.CheckAny(2)
+	L.SetTop(2)
+	L.RawGet(1)
+	return 1
+}
+
+func baseRawSet(L lua.State) int {
+	L.CheckType(1, lua.TTABLE)
+	L.CheckAny(2)
+	L.CheckAny(3)
+	L.SetTop(3)
+	L.RawSet(1)
+	return 1
+}
+
+func baseType(L lua.State) int {
+	t := L.Type(1)
+	L.ArgCheck(t != lua.TNONE, 1, "value expected")
+	L.PushString(L.TypeName(t))
+	return 1
+}
+
+func baseNext(L lua.State) int {
+	L.CheckType(1, lua.TTABLE)
+	L.SetTop(2) /* create a 2nd argument if there isn't one */
+	if L.Next(1) {
+		return 2
+	}
+	L.PushNil()
+	return 1
+}
+
+func basePairs(L lua.State) int {
+	L.CheckAny(1)
+	if L.GetMetafield(1, "__pairs") == lua.TNIL { /* no metamethod? */
+		L.PushGoFunction(baseNext) /* will return generator, */
+		L.PushValue(1)             /* state, */
+		L.PushNil()                /* and initial value */
+	} else {
+		L.PushValue(1) /* argument 'self' to metamethod */
+		L.Call(1, 3)   /* get 3 values from metamethod */
+	}
+	return 3
+}
+
+func baseDoFile(L lua.State) int {
+	fname := L.OptString(1, "")
+	L.SetTop(1)
+	if L.LoadFile(fname) != lua.OK {
+		return L.Error()
+	}
+	L.Call(0, lua.MULTRET)
+	return L.GetTop() - 1
+}
+
+func baseAssert(L lua.State) int {
+	if L.ToBoolean(1) { /* condition is true? */
+		return L.GetTop() /* return all arguments */
+	}
+	L.CheckAny(1)                     /* there must be a condition */
+	L.Remove(1)                       /* remove it */
+	L.PushString("assertion failed!") /* default message */
+	L.SetTop(1)                       /* leave only message (default if no other one) */
+	return baseError(L)               /* call 'error' */
+}
+
+func baseSelect(L lua.State) int {
+	n := lua.Integer(L.GetTop())
+	if L.Type(1) == lua.TSTRING && strings.HasPrefix(L.ToString(1), "#") {
+		L.PushInteger(n - 1)
+		return 1
+	}
+	i := L.CheckInteger(1)
+	if i < 0 {
+		i = n + i
+	} else if i > n {
+		i = n
+	}
+	L.ArgCheck(1 <= i, 1, "index out of range")
+	return int(n - i)
+}
+
+/**
+ * Continuation function for 'pcall' and 'xpcall'. Both functions
+ * already pushed a 'true' before doing the call, so in case of success
+ * 'finishPCall' only has to return everything in the stack minus
+ * 'extra' values (where 'extra' is exactly the number of items to be
+ * ignored).
+ */
+func finishPCall(L lua.State, status int, extra lua.KContext) int {
+	if status != lua.OK && status != lua.YIELD { /* error? */
+		L.PushBoolean(false) /* first result (false) */
+		L.PushValue(-2)      /* error message */
+		return 2             /* return false, msg */
+	}
+	return L.GetTop() - extra.(int) /* return all results */
+}
+
+func basePCall(L lua.State) int {
+	L.CheckAny(1)
+	L.PushBoolean(true) /* first result if no errors */
+	L.Insert(1)         /* put it in place */
+	status := L.PCallK(L.GetTop()-2, lua.MULTRET, 0, 0, finishPCall)
+	return finishPCall(L, status, 0)
+}
+
+/**
+ * Do a protected call with error handling. After 'Rotate', the stack
+ * will have <f, err, true, f, [args...]>; so, the function passes
+ * 2 to 'finishPCall' to skip the 2 first values when returning results.
+ */
+func baseXPCall(L lua.State) int {
+	n := L.GetTop()
+	L.CheckType(2, lua.TFUNCTION) /* check error function */
+	L.PushBoolean(true)           /* first result */
+	L.PushValue(1)                /* function */
+	L.Rotate(3, 2)                /* move them below function's arguments */
+	status := L.PCallK(n-2, lua.MULTRET, 2, 2, finishPCall)
+	return finishPCall(L, status, 2)
+}
+
+func baseToString(L lua.State) int {
+	L.CheckAny(1)
+	L.ToStringMeta(1)
+	return 1
+}
@@ -0,0 +1,138 @@
+package stdlib
+
+import (
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+var coFuncs = lua.FuncReg{
+	"create":      coCreate,
+	"resume":      coResume,
+	"running":     coRunning,
+	"status":      coStatus,
+	"wrap":        coWrap,
+	"yield":       coYield,
+	"isyieldable": coYieldable,
+}
+
+func OpenCoroutine(L lua.State) int {
+	L.NewLib(coFuncs)
+	return 1
+}
+
+func getCo(L lua.State) lua.State {
+	co := L.ToThread(1)
+	L.ArgCheck(co != nil, 1, "coroutine expected")
+	return co
+}
+
+/**
+ * Resume 'co' with the 'nArg' values on the top of the stack of 'L' and
+ * move its results (or the error message) to 'L'. Returns the number of
+ * results, or -1 on errors.
+ */
+func auxResume(L, co lua.State, nArg int) int {
+	if !co.CheckStack(nArg) {
+		L.PushString("too many arguments to resume")
+		return -1 /* error flag */
+	}
+	if co.Status() == lua.OK && co.GetTop() == 0 {
+		L.PushString("cannot resume dead coroutine")
+		return -1 /* error flag */
+	}
+	L.XMove(co, nArg)
+	status := co.Resume(L, nArg)
+	if status == lua.OK || status == lua.YIELD {
+		nRes := co.GetTop()
+		if !L.CheckStack(nRes + 1) {
+			co.Pop(nRes) /* remove results anyway */
+			L.PushString("too many results to resume")
+			return -1 /* error flag */
+		}
+		co.XMove(L, nRes) /* move yielded values */
+		return nRes
+	} else {
+		co.XMove(L, 1) /* move error message */
+		return -1      /* error flag */
+	}
+}
+
+func coResume(L lua.State) int {
+	co := getCo(L)
+	r := auxResume(L, co, L.GetTop()-1)
+	if r < 0 {
+		L.PushBoolean(false)
+		L.Insert(-2)
+		return 2 /* return false + error message */
+	} else {
+		L.PushBoolean(true)
+		L.Insert(-(r + 1))
+		return r + 1 /* return true + 'resume' returns */
+	}
+}
+
+func auxWrap(L lua.State) int {
+	co := L.ToThread(lua.UpvalueIndex(1))
+	r := auxResume(L, co, L.GetTop())
+	if r < 0 {
+		if L.Type(-1) == lua.TSTRING { /* error object is a string? */
+			L.Where(1) /* get extra info */
+			L.Insert(-2)
+			L.Concat(2)
+		}
+		return L.Error() /* propagate error */
+	}
+	return r
+}
+
+func coCreate(L lua.State) int {
+	L.CheckType(1, lua.TFUNCTION)
+	NL := L.NewThread()
+	L.PushValue(1) /* move function to top */
+	L.XMove(NL, 1) /* move function from L to NL */
+	return 1
+}
+
+func coWrap(L lua.State) int {
+	coCreate(L)
+	L.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+func coYield(L lua.State) int {
+	return L.Yield(L.GetTop())
+}
+
+func coStatus(L lua.State) int {
+	co := getCo(L)
+	if L == co {
+		L.PushString("running")
+	} else {
+		switch co.Status() {
+		case lua.YIELD:
+			L.PushString("suspended")
+		case lua.OK:
+			var ar lua.Debug
+			if co.GetStack(0, &ar) { /* does it have frames? */
+				L.PushString("normal") /* it is running */
+			} else if co.GetTop() == 0 {
+				L.PushString("dead")
+			} else {
+				L.PushString("suspended") /* initial state */
+			}
+		default: /* some error occurred */
+			L.PushString("dead")
+		}
+	}
+	return 1
+}
+
+func coYieldable(L lua.State) int {
+	L.PushBoolean(L.IsYieldable())
+	return 1
+}
+
+func coRunning(L lua.State) int {
+	isMain := L.PushThread()
+	L.PushBoolean(isMain)
+	return 2
+}
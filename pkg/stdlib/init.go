@@ -0,0 +1,31 @@
+/**
+ * Package stdlib implements the Lua standard libraries on top of the
+ * lua.State API.
+ */
+package stdlib
+
+import (
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/**
+ * these libs are loaded by lua.go and are readily available to any Lua
+ * program
+ */
+var loadedLibs = []struct {
+	name string
+	f    lua.GoFunction
+}{
+	{"_G", OpenBase},
+	{"package", OpenPackage},
+	{"coroutine", OpenCoroutine},
+}
+
+/* OpenLibs opens all standard libraries into the given state. */
+func OpenLibs(L lua.State) {
+	/* "require" functions from 'loadedLibs' and set results to global table */
+	for _, lib := range loadedLibs {
+		L.RequireF(lib.name, lib.f, true)
+		L.Pop(1) /* remove lib */
+	}
+}
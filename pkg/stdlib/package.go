@@ -0,0 +1,242 @@
+package stdlib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/uganh16/golua/internal/conf"
+	"github.com/uganh16/golua/pkg/lua"
+)
+
+/**
+ * LUA_PATH_SEP is the character that separates templates in a path.
+ * LUA_PATH_MARK is the string that marks the substitution points in a
+ * template.
+ * LUA_EXEC_DIR in a Windows path is replaced by the executable's
+ * directory.
+ */
+const (
+	LUA_PATH_SEP  = ";"
+	LUA_PATH_MARK = "?"
+	LUA_EXEC_DIR  = "!"
+)
+
+/**
+ * LUA_PATH_VAR is the name of the environment variable that Lua checks
+ * to set its path. The versioned name 'LUA_PATH_5_3' is tried first.
+ */
+const LUA_PATH_VAR = "LUA_PATH"
+
+const LUA_VERSUFFIX = "_5_3"
+
+/**
+ * LUA_IGMARK is a mark to ignore all before it when building the
+ * luaopen_ function name.
+ */
+const LUA_IGMARK = "-"
+
+/* auxiliary mark */
+const AUXMARK = "\x01"
+
+var pkFuncs = lua.FuncReg{
+	"searchpath": llSearchPath,
+}
+
+var llFuncs = lua.FuncReg{
+	"require": llRequire,
+}
+
+func OpenPackage(L lua.State) int {
+	L.NewLib(pkFuncs) /* create 'package' table */
+	createSearchersTable(L)
+	/* set paths */
+	setPath(L, "path", LUA_PATH_VAR, conf.LUA_PATH_DEFAULT)
+	/* store config information */
+	L.PushString(conf.LUA_DIRSEP + "\n" + LUA_PATH_SEP + "\n" + LUA_PATH_MARK + "\n" +
+		LUA_EXEC_DIR + "\n" + LUA_IGMARK + "\n")
+	L.SetField(-2, "config")
+	/* set field 'loaded' */
+	L.GetSubTable(lua.REGISTRYINDEX, lua.LOADED_TABLE)
+	L.SetField(-2, "loaded")
+	/* set field 'preload' */
+	L.GetSubTable(lua.REGISTRYINDEX, lua.PRELOAD_TABLE)
+	L.SetField(-2, "preload")
+	L.PushGlobalTable()
+	L.PushValue(-2)        /* set 'package' as upvalue for next lib */
+	L.SetFuncs(llFuncs, 1) /* open lib into global table */
+	L.Pop(1)               /* pop global table */
+	return 1               /* return 'package' table */
+}
+
+func createSearchersTable(L lua.State) {
+	searchers := []lua.GoFunction{searcherPreload, searcherLua}
+	/* create 'searchers' table */
+	L.CreateTable(len(searchers), 0)
+	/* fill it with predefined searchers */
+	for i, searcher := range searchers {
+		L.PushValue(-2) /* set 'package' as upvalue for all searchers */
+		L.PushGoClosure(searcher, 1)
+		L.RawSetI(-2, lua.Integer(i+1))
+	}
+	L.SetField(-2, "searchers") /* put it in field 'searchers' */
+}
+
+/* return registry.LUA_NOENV as a boolean */
+func noEnv(L lua.State) bool {
+	L.GetField(lua.REGISTRYINDEX, "LUA_NOENV")
+	b := L.ToBoolean(-1)
+	L.Pop(1) /* remove value */
+	return b
+}
+
+/* set a path */
+func setPath(L lua.State, fieldName, envName, dft string) {
+	path, ok := os.LookupEnv(envName + LUA_VERSUFFIX) /* use versioned name */
+	if !ok {                                          /* no environment variable? */
+		path, ok = os.LookupEnv(envName) /* try unversioned name */
+	}
+	if !ok || noEnv(L) { /* no environment variable? */
+		L.PushString(dft) /* use default */
+	} else {
+		/* replace ";;" by ";AUXMARK;" and then AUXMARK by default path */
+		path = strings.ReplaceAll(path, LUA_PATH_SEP+LUA_PATH_SEP, LUA_PATH_SEP+AUXMARK+LUA_PATH_SEP)
+		L.PushString(strings.ReplaceAll(path, AUXMARK, dft))
+	}
+	L.SetField(-2, fieldName) /* package[fieldName] = path value */
+}
+
+/*
+** {======================================================
+** 'require' function
+** =======================================================
+ */
+
+func readable(fileName string) bool {
+	f, err := os.Open(fileName) /* try to open file */
+	if err != nil {
+		return false /* open failed */
+	}
+	f.Close()
+	return true
+}
+
+func searchPath(name, path, sep, dirSep string) (string, string) {
+	var msg strings.Builder /* to build error message */
+	if sep != "" {          /* non-empty separator? */
+		name = strings.ReplaceAll(name, sep, dirSep) /* replace it by 'dirSep' */
+	}
+	for _, template := range strings.Split(path, LUA_PATH_SEP) {
+		if template == "" {
+			continue
+		}
+		fileName := strings.ReplaceAll(template, LUA_PATH_MARK, name)
+		if readable(fileName) { /* does it exist and is readable? */
+			return fileName, "" /* return that file name */
+		}
+		fmt.Fprintf(&msg, "\n\tno file '%s'", fileName)
+	}
+	return "", msg.String() /* not found */
+}
+
+func llSearchPath(L lua.State) int {
+	f, msg := searchPath(L.CheckString(1), L.CheckString(2),
+		L.OptString(3, "."), L.OptString(4, conf.LUA_DIRSEP))
+	if f != "" {
+		L.PushString(f)
+		return 1
+	}
+	L.PushNil()
+	L.PushString(msg)
+	return 2 /* return nil + error message */
+}
+
+func findFile(L lua.State, name, pname, dirSep string) (string, string) {
+	L.GetField(lua.UpvalueIndex(1), pname)
+	path, ok := L.ToStringX(-1)
+	L.Pop(1)
+	if !ok {
+		L.ErrorF("'package.%s' must be a string", pname)
+	}
+	return searchPath(name, path, ".", dirSep)
+}
+
+func checkLoad(L lua.State, stat bool, fileName string) int {
+	if stat { /* module loaded successfully? */
+		L.PushString(fileName) /* will be 2nd argument to module */
+		return 2               /* return open function and file name */
+	}
+	return L.ErrorF("error loading module '%s' from file '%s':\n\t%s", L.ToString(1), fileName, L.ToString(-1))
+}
+
+func searcherLua(L lua.State) int {
+	name := L.ToString(1)
+	fileName, msg := findFile(L, name, "path", conf.LUA_DIRSEP)
+	if fileName == "" {
+		L.PushString(msg)
+		return 1 /* module not found in this path */
+	}
+	return checkLoad(L, L.LoadFile(fileName) == lua.OK, fileName)
+}
+
+func searcherPreload(L lua.State) int {
+	name := L.ToString(1)
+	L.GetField(lua.REGISTRYINDEX, lua.PRELOAD_TABLE)
+	if L.GetField(-1, name) == lua.TNIL { /* not found? */
+		L.PushString(fmt.Sprintf("\n\tno field package.preload['%s']", name))
+	}
+	return 1
+}
+
+func findLoader(L lua.State, name string) {
+	var msg strings.Builder /* to build error message */
+	/* push 'package.searchers' to index 3 in the stack */
+	if L.GetField(lua.UpvalueIndex(1), "searchers") != lua.TTABLE {
+		L.ErrorF("'package.searchers' must be a table")
+	}
+	/* iterate over available searchers to find a loader */
+	for i := lua.Integer(1); ; i++ {
+		if L.RawGetI(3, i) == lua.TNIL { /* no more searchers? */
+			L.Pop(1) /* remove nil */
+			L.ErrorF("module '%s' not found:%s", name, msg.String())
+		}
+		L.PushString(name)
+		L.Call(1, 2)                     /* call it */
+		if L.Type(-2) == lua.TFUNCTION { /* did it find a loader? */
+			return /* module loader found */
+		} else if L.IsString(-2) { /* searcher returned error message? */
+			L.Pop(1)                        /* remove extra return */
+			msg.WriteString(L.ToString(-1)) /* concatenate error message */
+			L.Pop(1)
+		} else {
+			L.Pop(2) /* remove both returns */
+		}
+	}
+}
+
+func llRequire(L lua.State) int {
+	name := L.CheckString(1)
+	L.SetTop(1) /* LOADED table will be at index 2 */
+	L.GetField(lua.REGISTRYINDEX, lua.LOADED_TABLE)
+	L.GetField(2, name)  /* LOADED[name] */
+	if L.ToBoolean(-1) { /* is it there? */
+		return 1 /* package is already loaded */
+	}
+	/* else must load package */
+	L.Pop(1) /* remove 'GetField' result */
+	findLoader(L, name)
+	L.PushString(name) /* pass name as argument to module loader */
+	L.Insert(-2)       /* name is 1st argument (before search data) */
+	L.Call(2, 1)       /* run loader to load module */
+	if !L.IsNil(-1) {  /* non-nil return? */
+		L.SetField(2, name) /* LOADED[name] = returned value */
+	}
+	if L.GetField(2, name) == lua.TNIL { /* module set no value? */
+		L.PushBoolean(true) /* use true as result */
+		L.PushValue(-1)     /* extra copy to be returned */
+		L.SetField(2, name) /* LOADED[name] = true */
+	}
+	return 1
+}
+
+/* }====================================================== */
@@ -0,0 +1,322 @@
+package stdlib
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uganh16/golua/pkg/golua"
+	"github.com/uganh16/golua/pkg/lua"
+	"github.com/uganh16/golua/pkg/parser"
+)
+
+func doString(t *testing.T, L lua.State, chunk string) int {
+	if status := L.Load(strings.NewReader(chunk), "=test", "t"); status != lua.OK {
+		t.Fatalf("cannot load chunk: %s", L.ToString(-1))
+	}
+	return L.PCall(0, lua.MULTRET, 0)
+}
+
+func TestBase(t *testing.T) {
+	L := golua.NewState()
+	OpenLibs(L)
+	chunk := `
+		local t = setmetatable({}, {__tostring = function() return "T" end})
+		return tostring(t), tonumber("0x10"), tonumber("z", 36), select("#", 1, 2, 3), select(-1, 1, 2, 3), type(print), rawlen("abc"),
+			next({}), select(2, next({"a"})), select(2, next(select(2, pairs(setmetatable({}, {__pairs = function() return next, {8} end})))))`
+	if status := doString(t, L, chunk); status != lua.OK {
+		t.Fatalf("unexpected error: %s", L.ToString(-1))
+	}
+	expected := []string{"T", "16", "35", "3", "3", "function", "3", "nil", "a", "8"}
+	if L.GetTop() != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), L.GetTop())
+	}
+	for i, s := range expected {
+		if L.IsNil(i+1) && s == "nil" {
+			continue
+		}
+		if got := L.ToString(i + 1); got != s {
+			t.Errorf("result #%d: expected %q, got %q", i+1, s, got)
+		}
+	}
+}
+
+func TestToNumber(t *testing.T) {
+	tests := []struct {
+		s       string
+		isNum   bool
+		isInt   bool
+		integer lua.Integer
+		number  lua.Number
+	}{
+		{"10", true, true, 10, 0},
+		{" \t-7\n", true, true, -7, 0},
+		{"+5", true, true, 5, 0},
+		{"0xff", true, true, 255, 0},
+		{"-0x10", true, true, -16, 0},
+		{"0xffffffffffffffff", true, true, -1, 0}, /* hex integers wrap around */
+		{"-9223372036854775808", true, true, math.MinInt64, 0},
+		{"9223372036854775808", true, false, 0, 9223372036854775808.0},
+		{"3.0", true, false, 0, 3},
+		{".5e1", true, false, 0, 5},
+		{"0x1p4", true, false, 0, 16},
+		{"0x.8", true, false, 0, 0.5},
+		{"1e400", true, false, 0, math.Inf(1)},
+		{"1_000", false, false, 0, 0},
+		{"Inf", false, false, 0, 0},
+		{"nan", false, false, 0, 0},
+		{"0x", false, false, 0, 0},
+		{"1e", false, false, 0, 0},
+		{"1 2", false, false, 0, 0},
+		{"", false, false, 0, 0},
+	}
+	L := golua.NewState()
+	OpenLibs(L)
+	for _, test := range tests {
+		L.GetGlobal("tonumber")
+		L.PushString(test.s)
+		L.Call(1, 1)
+		if !test.isNum {
+			if !L.IsNil(-1) {
+				t.Errorf("tonumber(%q): expected nil, got %s", test.s, L.ToString(-1))
+			}
+		} else if test.isInt {
+			if !L.IsInteger(-1) || L.ToInteger(-1) != test.integer {
+				t.Errorf("tonumber(%q): expected integer %d, got %s", test.s, test.integer, L.ToString(-1))
+			}
+		} else if L.Type(-1) != lua.TNUMBER || L.IsInteger(-1) || L.ToNumber(-1) != test.number {
+			t.Errorf("tonumber(%q): expected float %g, got %s", test.s, test.number, L.ToString(-1))
+		}
+		L.SetTop(0)
+	}
+
+	/* string coercion in arithmetic follows the same rules */
+	if status := doString(t, L, `return " 0x10 " * 2, "0x1p4" + 0, "-7" // 2`); status != lua.OK {
+		t.Fatalf("unexpected error: %s", L.ToString(-1))
+	}
+	if L.ToInteger(1) != 32 || L.ToNumber(2) != 16 || L.IsInteger(2) || L.ToInteger(3) != -4 {
+		t.Errorf("unexpected results: %s %s %s", L.ToString(1), L.ToString(2), L.ToString(3))
+	}
+	L.SetTop(0)
+	if status := doString(t, L, `return "1_000" + 1`); status != lua.ERRRUN {
+		t.Errorf("expected ERRRUN, got %d", status)
+	}
+}
+
+func TestToString(t *testing.T) {
+	L := golua.NewState()
+	OpenLibs(L)
+	chunk := `
+		return 1000000.0, 3.0, -0.0, 0.1, 1/3, 2^53, 2^63, 1e100, 1/0, -1/0, 100, -5, 0x7fffffffffffffff, 1.5 .. "", 2^4 .. ""`
+	if status := doString(t, L, chunk); status != lua.OK {
+		t.Fatalf("unexpected error: %s", L.ToString(-1))
+	}
+	expected := []string{"1000000.0", "3.0", "-0.0", "0.1", "0.33333333333333", "9.007199254741e+15", "9.2233720368548e+18",
+		"1e+100", "inf", "-inf", "100", "-5", "9223372036854775807", "1.5", "16.0"}
+	if L.GetTop() != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), L.GetTop())
+	}
+	for i, s := range expected {
+		L.GetGlobal("tostring")
+		L.PushValue(i + 1)
+		L.Call(1, 1)
+		if got := L.ToString(-1); got != s {
+			t.Errorf("result #%d: expected %q, got %q", i+1, s, got)
+		}
+		L.Pop(1)
+	}
+	L.SetTop(0)
+	L.PushNumber(math.NaN())
+	L.PushNumber(math.Copysign(math.NaN(), -1))
+	if got := L.ToString(1) + " " + L.ToString(2); got != "nan -nan" {
+		t.Errorf("expected %q, got %q", "nan -nan", got)
+	}
+}
+
+func TestGenericFor(t *testing.T) {
+	L := golua.NewState()
+	OpenLibs(L)
+	L.Register("chars", func(L lua.State) int { /* iterator written in Go */
+		L.PushGoFunction(func(L lua.State) int {
+			s, i := L.CheckString(1), L.OptInteger(2, 0)
+			if int(i) >= len(s) {
+				return 0
+			}
+			L.PushInteger(i + 1)
+			L.PushString(s[i : i+1])
+			return 2
+		})
+		L.PushValue(1)
+		return 2
+	})
+	chunk := `
+		local keys, sum = {}, 0
+		for k, v in pairs({10, 20, 30, x = 40}) do keys[#keys + 1] = k; sum = sum + v end
+		local s = ""
+		for i, c in chars("abc") do s = s .. i .. c end
+		local function range(n) -- iterator written in Lua
+			return function(_, c) if c < n then return c + 1 end end, nil, 0
+		end
+		local n = 0
+		for i in range(3) do for j in range(i) do n = n + j end end
+		local co = coroutine.wrap(function()
+			for i in function(_, c) c = (c or 0) + 1 if c <= 2 then coroutine.yield(c) return c end end do end
+			return "done"
+		end)
+		return #keys, sum, s, n, co() .. co() .. co()`
+	if status := doString(t, L, chunk); status != lua.OK {
+		t.Fatalf("unexpected error: %s", L.ToString(-1))
+	}
+	expected := []string{"4", "100", "1a2b3c", "10", "12done"}
+	for i, s := range expected {
+		if got := L.ToString(i + 1); got != s {
+			t.Errorf("result #%d: expected %q, got %q", i+1, s, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`assert(false)`, "test:1: assertion failed!"},
+		{`assert(nil, "custom")`, "test:1: custom"},
+		{`setmetatable(1, {})`, "test:1: bad argument #1 to 'setmetatable' (table expected, got number)"},
+		{`xpcall(print)`, "test:1: bad argument #2 to 'xpcall' (function expected, got no value)"},
+		{`local ok, e = pcall(error, {}) error(type(e))`, "test:1: table"},
+		{`error("no position", 0)`, "no position"},
+		{`local t = {} t.x.y = 1`, "test:1: attempt to index a nil value (field 'x')"},
+		{`local s = "a" .. {}`, "test:1: attempt to concatenate a table value"},
+		{`x = #undefined`, "test:1: attempt to get length of a nil value (global 'undefined')"},
+		{`local up = nil; (function() return up.x end)()`, "test:1: attempt to index a nil value (upvalue 'up')"},
+		{`local t = {} t:method()`, "test:1: attempt to call a nil value (method 'method')"},
+		{`local a = 1.5 return a | 1`, "test:1: number (local 'a') has no integer representation"},
+		{`tonumber("10", 99)`, "test:1: bad argument #2 to 'tonumber' (base out of range)"},
+		{`select(1.5)`, "test:1: bad argument #1 to 'select' (number has no integer representation)"},
+		{`local t = {m = setmetatable} t:m(5)`, "test:1: bad argument #1 to 'm' (nil or table expected)"},
+		{`select(0)`, "test:1: bad argument #1 to 'select' (index out of range)"},
+		{`setmetatable(setmetatable({}, {__metatable = 1}), {})`, "test:1: cannot change a protected metatable"},
+		{`next({}, "nokey")`, "invalid key to 'next'"},
+		{`pairs()`, "test:1: bad argument #1 to 'pairs' (value expected)"},
+		{`for x in 42 do end`, "test:1: attempt to call a number value"},
+		{`local ok, e = pcall(function() return 1 % 0 end) error(e, 0)`, "test:1: attempt to perform 'n%0'"},
+		{`local ok, e = pcall(function() return 1 // 0 end) error(e, 0)`, "test:1: attempt to perform 'n//0'"},
+	}
+	L := golua.NewState()
+	OpenLibs(L)
+	for _, test := range tests {
+		if status := doString(t, L, test.chunk); status != lua.ERRRUN {
+			t.Errorf("%s: expected ERRRUN, got %d", test.chunk, status)
+		} else if msg := L.ToString(-1); msg != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.chunk, test.expected, msg)
+		}
+		L.SetTop(0)
+	}
+
+	/* syntax errors keep their position */
+	err := golua.DoString(L, "x = 1\nx = = 2")
+	e, ok := err.(*lua.LuaError)
+	if !ok {
+		t.Fatalf("expected *lua.LuaError, got %v", err)
+	}
+	if e.Status != lua.ERRSYNTAX || e.ChunkName != `[string "x = 1..."]` || e.Line != 2 {
+		t.Errorf("unexpected error: %+v", *e)
+	}
+	var se *parser.SyntaxError
+	if !errors.Is(err, lua.ErrSyntax) || !errors.As(err, &se) || se.Line != 2 || se.Message != "unexpected symbol near '='" {
+		t.Errorf("expected a syntax error, got %#v", e.Err)
+	}
+	err = golua.DoFile(L, filepath.Join(t.TempDir(), "missing.lua"))
+	if !errors.Is(err, lua.ErrFile) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a file error, got %v", err)
+	}
+	if L.GetTop() != 0 {
+		t.Errorf("expected an empty stack, got %d values", L.GetTop())
+	}
+}
+
+func TestRequire(t *testing.T) {
+	L := golua.NewState()
+	OpenLibs(L)
+	chunk := `
+		package.preload.mod = function(name) return {name = name} end
+		local m = require "mod"
+		return m.name, require("mod") == m, package.loaded.mod == m`
+	if status := doString(t, L, chunk); status != lua.OK {
+		t.Fatalf("unexpected error: %s", L.ToString(-1))
+	}
+	if L.ToString(1) != "mod" || !L.ToBoolean(2) || !L.ToBoolean(3) {
+		t.Errorf("unexpected results: %q %v %v", L.ToString(1), L.ToBoolean(2), L.ToBoolean(3))
+	}
+	L.SetTop(0)
+	if status := doString(t, L, `require "no.such.module"`); status != lua.ERRRUN {
+		t.Fatalf("expected ERRRUN, got %d", status)
+	}
+	if msg := L.ToString(-1); !strings.HasPrefix(msg, "test:1: module 'no.such.module' not found:\n\tno field package.preload['no.such.module']") ||
+		!strings.Contains(msg, "no file './no/such/module.lua'") {
+		t.Errorf("unexpected error message: %q", msg)
+	}
+}
+
+func TestCoroutine(t *testing.T) {
+	L := golua.NewState()
+	OpenLibs(L)
+	chunk := `
+		local log = {}
+		local co = coroutine.create(function(a, b)
+			local c = coroutine.yield(a + b)
+			local d, e = coroutine.yield(c * 2)
+			return d .. e, coroutine.isyieldable()
+		end)
+		local function add(...) for i = 1, select("#", ...) do log[#log + 1] = tostring((select(i, ...))) end end
+		add(coroutine.status(co))
+		add(coroutine.resume(co, 1, 2))
+		add(coroutine.resume(co, 10))
+		add(coroutine.resume(co, "x", "y"))
+		add(coroutine.status(co), coroutine.resume(co))
+		local function deep(n) if n == 0 then return coroutine.yield("bottom") end return deep(n - 1) end
+		local gen = coroutine.wrap(function() return "back " .. deep(3) end)
+		add(gen(), gen("up"))
+		local p = coroutine.wrap(function() return pcall(function() coroutine.yield("py") error("pe", 0) end) end)
+		add(p(), p())
+		local self
+		self = coroutine.create(function() return coroutine.status(self), coroutine.resume(self) end)
+		add(coroutine.resume(self))
+		return log`
+	if status := doString(t, L, chunk); status != lua.OK {
+		t.Fatalf("unexpected error: %s", L.ToString(-1))
+	}
+	var log []string
+	for i := lua.Integer(1); L.GetI(-1, i) != lua.TNIL; i++ {
+		log = append(log, L.ToString(-1))
+		L.Pop(1)
+	}
+	expected := "suspended true 3 true 20 true xy true dead false cannot resume dead coroutine " +
+		"bottom back up py false pe true running false cannot resume non-suspended coroutine"
+	if got := strings.Join(log, " "); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`coroutine.yield()`, "attempt to yield from outside a coroutine"},
+		{`coroutine.wrap(function() error("oops") end)()`, "test:1: test:1: oops"},
+		{`coroutine.wrap(function() local x; return x.y end)()`, "test:1: test:1: attempt to index a nil value (local 'x')"},
+		{`coroutine.resume(1)`, "test:1: bad argument #1 to 'resume' (coroutine expected)"},
+		{`coroutine.create(1)`, "test:1: bad argument #1 to 'create' (function expected, got number)"},
+	}
+	for _, test := range tests {
+		L.SetTop(0)
+		if status := doString(t, L, test.chunk); status != lua.ERRRUN {
+			t.Errorf("%s: expected ERRRUN, got %d", test.chunk, status)
+		} else if msg := L.ToString(-1); msg != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.chunk, test.expected, msg)
+		}
+	}
+}